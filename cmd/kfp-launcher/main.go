@@ -0,0 +1,156 @@
+// Command kfp-launcher runs Kubeflow Pipelines tooling outside of a cluster.
+//
+// Usage:
+//
+//	kfp-launcher run-pipeline --pipeline_spec_path=pipeline.json [flags]
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/neuromage/kfp-launcher/component"
+	"github.com/neuromage/kfp-launcher/metadata/embedded"
+	"github.com/neuromage/kfp-launcher/runner"
+)
+
+// parameterFlags collects repeated --parameter name=value flags.
+type parameterFlags map[string]string
+
+func (p parameterFlags) String() string {
+	var s []string
+	for k, v := range p {
+		s = append(s, k+"="+v)
+	}
+	return strings.Join(s, ",")
+}
+
+func (p parameterFlags) Set(s string) error {
+	kv := strings.SplitN(s, "=", 2)
+	if len(kv) != 2 || len(kv[0]) == 0 {
+		return fmt.Errorf("expected name=value, got %q", s)
+	}
+	p[kv[0]] = kv[1]
+	return nil
+}
+
+// commands are the subcommands, by name.
+var commands = map[string]func(args []string) int{
+	"run-pipeline": runPipeline,
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s <command> [flags]\n\nCommands:\n", filepath.Base(os.Args[0]))
+	fmt.Fprintf(os.Stderr, "  run-pipeline\tRun a compiled pipeline locally.\n")
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n", os.Args[1])
+		usage()
+		os.Exit(2)
+	}
+	code := cmd(os.Args[2:])
+	glog.Flush()
+	os.Exit(code)
+}
+
+// newFlagSet returns a flag set for the named command that includes the
+// global flags, e.g. those of glog.
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	flag.CommandLine.VisitAll(func(f *flag.Flag) {
+		fs.Var(f.Value, f.Name, f.Usage)
+	})
+	return fs
+}
+
+func runPipeline(args []string) int {
+	fs := newFlagSet("run-pipeline")
+	var (
+		specPath        = fs.String("pipeline_spec_path", "", "JSON encoded PipelineJob or PipelineSpec to run.")
+		pipelineRoot    = fs.String("pipeline_root", "", "Pipeline root. Defaults to the output directory of the PipelineJob, or a directory in --work_dir.")
+		runID           = fs.String("run_id", "", "Pipeline run ID. Defaults to the pipeline name followed by the current time.")
+		workDir         = fs.String("work_dir", "", "Directory for the files passed between tasks. Defaults to a directory named after the run in the temporary directory.")
+		mlmdMode        = fs.String("mlmd_mode", "remote", "Metadata store to record to: \"remote\" connects to --mlmd_server_address, \"embedded\" runs an in-process store.")
+		mlmdPath        = fs.String("mlmd_embedded_path", "", "File the embedded metadata store persists to, for --mlmd_mode=embedded. Defaults to a file in --work_dir.")
+		mlmdAddress     = fs.String("mlmd_server_address", "localhost", "")
+		mlmdPort        = fs.String("mlmd_server_port", "8080", "")
+		parameterValues = make(parameterFlags)
+	)
+	fs.Var(parameterValues, "parameter", "Pipeline parameter value as name=value. May be repeated.")
+	fs.Parse(args)
+
+	if len(*specPath) == 0 || fs.NArg() > 0 {
+		fmt.Fprintf(os.Stderr, "Usage: run-pipeline --pipeline_spec_path=<path> [flags]\n")
+		fs.PrintDefaults()
+		return 2
+	}
+
+	p, err := runner.LoadPipeline(*specPath)
+	if err != nil {
+		glog.Errorf("%v", err)
+		return 1
+	}
+	if len(*runID) == 0 {
+		*runID = fmt.Sprintf("%s-%s", p.Name(), time.Now().Format("20060102-150405"))
+	}
+	if len(*workDir) == 0 {
+		*workDir = filepath.Join(os.TempDir(), "kfp-launcher-runs", *runID)
+	}
+	dir, err := filepath.Abs(*workDir)
+	if err != nil {
+		glog.Errorf("%v", err)
+		return 1
+	}
+
+	switch *mlmdMode {
+	case "remote":
+	case "embedded":
+		if len(*mlmdPath) == 0 {
+			*mlmdPath = filepath.Join(dir, "metadata.json")
+		}
+		endpoint, err := embedded.Start(*mlmdPath)
+		if err != nil {
+			glog.Errorf("Failed to start the embedded metadata store: %v", err)
+			return 1
+		}
+		defer endpoint.Stop()
+		*mlmdAddress, *mlmdPort = endpoint.Address, endpoint.Port
+		glog.Infof("Recording metadata to %q", *mlmdPath)
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown --mlmd_mode %q, want remote or embedded\n", *mlmdMode)
+		return 2
+	}
+
+	err = runner.Run(context.Background(), p, &runner.Options{
+		Launcher: component.LauncherOptions{
+			MLMDServerAddress: *mlmdAddress,
+			MLMDServerPort:    *mlmdPort,
+		},
+		PipelineRoot: *pipelineRoot,
+		RunID:        *runID,
+		WorkDir:      dir,
+		Parameters:   parameterValues,
+	})
+	if err != nil {
+		glog.Errorf("Pipeline run %q failed: %v", *runID, err)
+		if code, ok := component.UserCommandExitCode(err); ok {
+			return code
+		}
+		return 1
+	}
+	glog.Infof("Pipeline run %q succeeded", *runID)
+	return 0
+}
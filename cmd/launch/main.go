@@ -2,46 +2,206 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
+	"fmt"
+	"os"
 
 	"github.com/golang/glog"
 	"github.com/neuromage/kfp-launcher/component"
+	"github.com/neuromage/kfp-launcher/metadata/embedded"
+	"github.com/neuromage/kfp-launcher/third_party/pipeline_spec"
+	"google.golang.org/protobuf/encoding/protojson"
 )
 
 var (
-	mlmdServerAddress = flag.String("mlmd_server_address", "", "")
-	mlmdServerPort    = flag.String("mlmd_server_port", "8080", "")
-	runtimeInfoJSON   = flag.String("runtime_info_json", "", "")
-	containerImage    = flag.String("container_image", "", "")
-	taskName          = flag.String("task_name", "", "")
-	pipelineName      = flag.String("pipeline_name", "", "")
-	pipelineRunID     = flag.String("pipeline_run_id", "", "")
-	pipelineTaskID    = flag.String("pipeline_task_id", "", "")
-	pipelineRoot      = flag.String("pipeline_root", "", "")
+	mlmdMode               = flag.String("mlmd_mode", mlmdModeRemote, "Metadata store to record to: \"remote\" connects to --mlmd_server_address, \"embedded\" runs an in-process store.")
+	mlmdEmbeddedPath       = flag.String("mlmd_embedded_path", "", "File the embedded metadata store persists to, for --mlmd_mode=embedded. Metadata is kept in memory if unset.")
+	mlmdServerAddress      = flag.String("mlmd_server_address", "", "")
+	mlmdServerPort         = flag.String("mlmd_server_port", "8080", "")
+	mlmdUseTLS             = flag.Bool("mlmd_use_tls", false, "Connect to the metadata store over TLS.")
+	mlmdCACertFile         = flag.String("mlmd_ca_cert_file", "", "PEM CA bundle used to verify the metadata store. Implies --mlmd_use_tls.")
+	mlmdClientCertFile     = flag.String("mlmd_client_cert_file", "", "PEM client certificate for mutual TLS with the metadata store.")
+	mlmdClientKeyFile      = flag.String("mlmd_client_key_file", "", "PEM client key for mutual TLS with the metadata store.")
+	mlmdServerNameOverride = flag.String("mlmd_server_name_override", "", "Server name used to verify the metadata store certificate.")
+	mlmdTokenFile          = flag.String("mlmd_token_file", "", "File containing a bearer token for the metadata store. Re-read when it changes.")
+	mlmdRPCTimeout         = flag.Duration("mlmd_rpc_timeout", 0, "Deadline for each metadata store RPC attempt. Defaults to 30s.")
+	mlmdMaxAttempts        = flag.Int("mlmd_max_attempts", 0, "Number of attempts for each metadata store RPC. Defaults to 8.")
+	runtimeInfoJSON        = flag.String("runtime_info_json", "", "")
+	executorType           = flag.String("executor_type", executorTypeContainer, "What to run for the task: \"container\" runs the user command, \"importer\" registers the artifact in --importer_spec_json, \"resolver\" queries MLMD for the artifacts in --resolver_spec_json.")
+	importerSpecJSON       = flag.String("importer_spec_json", "", "JSON encoded PipelineDeploymentConfig.ImporterSpec, for --executor_type=importer.")
+	resolverSpecJSON       = flag.String("resolver_spec_json", "", "JSON encoded PipelineDeploymentConfig.ResolverSpec, for --executor_type=resolver.")
+	containerImage         = flag.String("container_image", "", "")
+	taskName               = flag.String("task_name", "", "")
+	pipelineName           = flag.String("pipeline_name", "", "")
+	pipelineRunID          = flag.String("pipeline_run_id", "", "")
+	pipelineTaskID         = flag.String("pipeline_task_id", "", "")
+	pipelineRoot           = flag.String("pipeline_root", "", "")
+	retryAttempt           = flag.Int("retry_attempt", 0, "Zero-based retry attempt of the task, e.g. Argo's {{retries}}.")
+	terminationGracePeriod = flag.Duration("termination_grace_period", component.DefaultTerminationGracePeriod, "Time the user command may take to exit after SIGTERM or SIGINT before it is killed.")
+	keepPartialOutputs     = flag.Bool("keep_partial_outputs", false, "Upload and record the outputs written so far when the user command is terminated.")
+	transferConcurrency    = flag.Int("transfer_concurrency", component.DefaultTransferConcurrency, "Maximum number of artifact objects, or parts of objects, transferred at once.")
+	transferPartSize       = flag.Int("transfer_part_size_bytes", component.DefaultTransferPartSize, "Size of the parts large artifact objects are uploaded and downloaded in.")
+	cachingOptions         = flag.String("caching_options_json", "", "JSON encoded PipelineTaskSpec.CachingOptions. Caching is enabled if unset.")
+	localDir               = flag.String("local_dir", component.DefaultLocalDir, "Absolute path of the directory holding the local copies of input and output artifacts.")
+	outputURITemplate      = flag.String("output_uri_template", component.DefaultOutputURITemplate, "Output artifact location relative to the pipeline root.")
+	s3Endpoint             = flag.String("s3_endpoint", "", "Endpoint for S3-compatible storage, e.g. a MinIO service.")
+	s3Region               = flag.String("s3_region", "", "S3 region. Defaults to us-east-1.")
+	s3ForcePathStyle       = flag.Bool("s3_force_path_style", false, "Use path-style addressing for S3 buckets.")
+	s3DisableSSL           = flag.Bool("s3_disable_ssl", false, "Connect to the S3 endpoint over plain HTTP.")
+	s3AccessKeyID          = flag.String("s3_access_key_id", "", "S3 access key ID. Defaults to the AWS credential chain.")
+	s3SecretAccessKey      = flag.String("s3_secret_access_key", "", "S3 secret access key.")
 )
 
-func check(err error) {
-	if err != nil {
-		glog.Fatalf("CHECK-fail: %s", err)
+// Values of --executor_type.
+const (
+	executorTypeContainer = "container"
+	executorTypeImporter  = "importer"
+	executorTypeResolver  = "resolver"
+)
+
+// Values of --mlmd_mode.
+const (
+	mlmdModeRemote   = "remote"
+	mlmdModeEmbedded = "embedded"
+)
+
+// Exit codes of the launcher. When the user command fails, the launcher exits
+// with the command's exit status, or 128+N if it was killed by signal N, so
+// that retry policies can key on them. Failures of the launcher itself use the
+// range 250-252 instead. User commands exiting with those codes cannot be told
+// apart from launcher failures.
+const (
+	// exitLauncherError means the launcher failed, e.g. to reach the metadata
+	// store or to transfer artifacts.
+	exitLauncherError = 250
+	// exitInvalidArguments means the flags or runtime info are invalid.
+	exitInvalidArguments = 251
+	// exitCanceled means the launcher was terminated before the user command
+	// exited, e.g. while downloading inputs.
+	exitCanceled = 252
+)
+
+// fail logs err and returns exitCode, for run to return.
+func fail(err error, exitCode int) int {
+	glog.Errorf("CHECK-fail: %s", err)
+	return exitCode
+}
+
+// exitCodeFor maps an error returned by RunComponent to the launcher's exit
+// code.
+func exitCodeFor(err error) int {
+	if code, ok := component.UserCommandExitCode(err); ok {
+		return code
 	}
+	if component.IsCanceled(err) {
+		return exitCanceled
+	}
+	return exitLauncherError
 }
 
 func main() {
 	flag.Parse()
-	ctx := context.Background()
+	// run returns instead of exiting, so that its deferred cleanup, e.g.
+	// stopping the embedded metadata store, happens before the exit.
+	code := run(context.Background())
+	glog.Flush()
+	os.Exit(code)
+}
+
+func run(ctx context.Context) int {
+	var taskCachingOptions *pipeline_spec.PipelineTaskSpec_CachingOptions
+	if len(*cachingOptions) > 0 {
+		taskCachingOptions = &pipeline_spec.PipelineTaskSpec_CachingOptions{}
+		if err := protojson.Unmarshal([]byte(*cachingOptions), taskCachingOptions); err != nil {
+			return fail(err, exitInvalidArguments)
+		}
+	}
 
 	opts := &component.LauncherOptions{
-		PipelineName:      *pipelineName,
-		PipelineRunID:     *pipelineRunID,
-		PipelineTaskID:    *pipelineTaskID,
-		PipelineRoot:      *pipelineRoot,
-		TaskName:          *taskName,
-		ContainerImage:    *containerImage,
-		MLMDServerAddress: *mlmdServerAddress,
-		MLMDServerPort:    *mlmdServerPort,
+		PipelineName:           *pipelineName,
+		PipelineRunID:          *pipelineRunID,
+		PipelineTaskID:         *pipelineTaskID,
+		PipelineRoot:           *pipelineRoot,
+		TaskName:               *taskName,
+		RetryAttempt:           *retryAttempt,
+		TerminationGracePeriod: *terminationGracePeriod,
+		KeepPartialOutputs:     *keepPartialOutputs,
+		TransferConcurrency:    *transferConcurrency,
+		TransferPartSize:       *transferPartSize,
+		ContainerImage:         *containerImage,
+		MLMDServerAddress:      *mlmdServerAddress,
+		MLMDServerPort:         *mlmdServerPort,
+		MLMDUseTLS:             *mlmdUseTLS,
+		MLMDCACertFile:         *mlmdCACertFile,
+		MLMDClientCertFile:     *mlmdClientCertFile,
+		MLMDClientKeyFile:      *mlmdClientKeyFile,
+		MLMDServerNameOverride: *mlmdServerNameOverride,
+		MLMDTokenFile:          *mlmdTokenFile,
+		MLMDRPCTimeout:         *mlmdRPCTimeout,
+		MLMDMaxAttempts:        *mlmdMaxAttempts,
+		CachingOptions:         taskCachingOptions,
+		LocalDir:               *localDir,
+		OutputURITemplate:      *outputURITemplate,
+		S3Endpoint:             *s3Endpoint,
+		S3Region:               *s3Region,
+		S3ForcePathStyle:       *s3ForcePathStyle,
+		S3DisableSSL:           *s3DisableSSL,
+		S3AccessKeyID:          *s3AccessKeyID,
+		S3SecretAccessKey:      *s3SecretAccessKey,
+	}
+	switch *executorType {
+	case executorTypeContainer:
+		if flag.NArg() == 0 {
+			return fail(errors.New("Must specify the user command"), exitInvalidArguments)
+		}
+	case executorTypeImporter, executorTypeResolver:
+		if flag.NArg() > 0 {
+			return fail(fmt.Errorf("Executor type %q runs no user command, got %v", *executorType, flag.Args()), exitInvalidArguments)
+		}
+	default:
+		return fail(fmt.Errorf("Unknown executor type %q", *executorType), exitInvalidArguments)
 	}
+
+	switch *mlmdMode {
+	case mlmdModeRemote:
+	case mlmdModeEmbedded:
+		if opts.MLMDUseTLS || len(opts.MLMDCACertFile) > 0 || len(opts.MLMDTokenFile) > 0 {
+			return fail(errors.New("The embedded metadata store does not support TLS or tokens"), exitInvalidArguments)
+		}
+		endpoint, err := embedded.Start(*mlmdEmbeddedPath)
+		if err != nil {
+			return fail(err, exitLauncherError)
+		}
+		defer endpoint.Stop()
+		opts.MLMDServerAddress, opts.MLMDServerPort = endpoint.Address, endpoint.Port
+	default:
+		return fail(fmt.Errorf("Unknown metadata store mode %q", *mlmdMode), exitInvalidArguments)
+	}
+
 	launcher, err := component.NewLauncher(*runtimeInfoJSON, opts)
-	check(err)
+	if err != nil {
+		return fail(err, exitInvalidArguments)
+	}
 
-	check(launcher.RunComponent(ctx, flag.Args()[0], flag.Args()[1:]...))
+	switch *executorType {
+	case executorTypeImporter:
+		spec := &pipeline_spec.PipelineDeploymentConfig_ImporterSpec{}
+		if err := protojson.Unmarshal([]byte(*importerSpecJSON), spec); err != nil {
+			return fail(err, exitInvalidArguments)
+		}
+		err = launcher.RunImporter(ctx, spec)
+	case executorTypeResolver:
+		spec := &pipeline_spec.PipelineDeploymentConfig_ResolverSpec{}
+		if err := protojson.Unmarshal([]byte(*resolverSpecJSON), spec); err != nil {
+			return fail(err, exitInvalidArguments)
+		}
+		err = launcher.RunResolver(ctx, spec)
+	default:
+		err = launcher.RunComponent(ctx, flag.Args()[0], flag.Args()[1:]...)
+	}
+	if err != nil {
+		return fail(err, exitCodeFor(err))
+	}
+	return 0
 }
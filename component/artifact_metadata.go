@@ -0,0 +1,92 @@
+package component
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	pb "github.com/neuromage/kfp-launcher/third_party/ml_metadata"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// artifactMetadataFileName is the name of the file, next to an output
+// artifact's local path, where the component may write metadata about it.
+const artifactMetadataFileName = "metadata.json"
+
+// readArtifactMetadata reads the JSON object a component wrote to path and
+// converts its fields to MLMD values, to be recorded as artifact custom
+// properties. It returns nil if the component did not write the file.
+//
+// Strings and numbers map to STRING, INT or DOUBLE values, and objects to
+// struct values. MLMD has no list or boolean values, so lists and booleans are
+// stored as structs of the form {"value": ...}. Null fields are skipped.
+func readArtifactMetadata(path string) (map[string]*pb.Value, error) {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return nil, fmt.Errorf("Failed to parse artifact metadata %q, must be a JSON object: %v", path, err)
+	}
+
+	metadata := make(map[string]*pb.Value)
+	for name, raw := range fields {
+		v, err := jsonToMLMDValue(raw)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to convert field %q of artifact metadata %q: %v", name, path, err)
+		}
+		if v != nil {
+			metadata[name] = v
+		}
+	}
+	return metadata, nil
+}
+
+// jsonToMLMDValue converts a JSON value to an MLMD value, or nil for null.
+func jsonToMLMDValue(raw json.RawMessage) (*pb.Value, error) {
+	d := json.NewDecoder(bytes.NewReader(raw))
+	d.UseNumber()
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		return nil, err
+	}
+
+	switch t := v.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return &pb.Value{Value: &pb.Value_StringValue{StringValue: t}}, nil
+	case json.Number:
+		if i, err := t.Int64(); err == nil {
+			return &pb.Value{Value: &pb.Value_IntValue{IntValue: i}}, nil
+		}
+		f, err := t.Float64()
+		if err != nil {
+			return nil, err
+		}
+		return &pb.Value{Value: &pb.Value_DoubleValue{DoubleValue: f}}, nil
+	}
+
+	// structpb does not understand json.Number, so decode structured values
+	// again with plain float64 numbers.
+	var plain interface{}
+	if err := json.Unmarshal(raw, &plain); err != nil {
+		return nil, err
+	}
+	fields, ok := plain.(map[string]interface{})
+	if !ok {
+		fields = map[string]interface{}{"value": plain}
+	}
+	s, err := structpb.NewStruct(fields)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.Value{Value: &pb.Value_StructValue{StructValue: s}}, nil
+}
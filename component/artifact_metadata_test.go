@@ -0,0 +1,73 @@
+package component
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	pb "github.com/neuromage/kfp-launcher/third_party/ml_metadata"
+	"google.golang.org/protobuf/testing/protocmp"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func Test_readArtifactMetadata(t *testing.T) {
+	structValue := func(m map[string]interface{}) *pb.Value {
+		s, err := structpb.NewStruct(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &pb.Value{Value: &pb.Value_StructValue{StructValue: s}}
+	}
+
+	tests := []struct {
+		name    string
+		content string
+		want    map[string]*pb.Value
+		wantErr bool
+	}{
+		{
+			name:    "Scalars",
+			content: `{"framework": "tensorflow", "rows": 1200, "accuracy": 0.93, "skipped": null}`,
+			want: map[string]*pb.Value{
+				"framework": {Value: &pb.Value_StringValue{StringValue: "tensorflow"}},
+				"rows":      {Value: &pb.Value_IntValue{IntValue: 1200}},
+				"accuracy":  {Value: &pb.Value_DoubleValue{DoubleValue: 0.93}},
+			},
+		},
+		{
+			name:    "Structured values",
+			content: `{"labels": {"team": "ml"}, "columns": ["a", "b"], "validated": true}`,
+			want: map[string]*pb.Value{
+				"labels":    structValue(map[string]interface{}{"team": "ml"}),
+				"columns":   structValue(map[string]interface{}{"value": []interface{}{"a", "b"}}),
+				"validated": structValue(map[string]interface{}{"value": true}),
+			},
+		},
+		{
+			name:    "Not an object",
+			content: `[1, 2]`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), artifactMetadataFileName)
+			if err := ioutil.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := readArtifactMetadata(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("readArtifactMetadata() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if diff := cmp.Diff(tt.want, got, protocmp.Transform()); diff != "" {
+				t.Errorf("readArtifactMetadata() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	got, err := readArtifactMetadata(filepath.Join(t.TempDir(), "missing.json"))
+	if err != nil || got != nil {
+		t.Errorf("readArtifactMetadata() for a missing file = %v, %v, want nil, nil", got, err)
+	}
+}
@@ -0,0 +1,139 @@
+package component
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+
+	"github.com/golang/glog"
+	"github.com/neuromage/kfp-launcher/metadata"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// cacheKey holds everything that determines the outputs of an execution.
+// Executions with equal cache keys are assumed to produce equivalent outputs.
+type cacheKey struct {
+	ContainerImage   string                       `json:"containerImage"`
+	Command          []string                     `json:"command"`
+	InputParameters  map[string]cacheKeyParameter `json:"inputParameters"`
+	InputArtifacts   map[string]cacheKeyArtifact  `json:"inputArtifacts"`
+	OutputParameters map[string]string            `json:"outputParameters"`
+	OutputArtifacts  map[string]string            `json:"outputArtifacts"`
+}
+
+type cacheKeyParameter struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type cacheKeyArtifact struct {
+	ID  int64  `json:"id"`
+	URI string `json:"uri"`
+}
+
+// cachingEnabled reports whether the task opted into caching. Caching is on
+// unless CachingOptions explicitly disables it.
+func (l *Launcher) cachingEnabled() bool {
+	return l.options.CachingOptions == nil || l.options.CachingOptions.GetEnableCache()
+}
+
+// cacheFingerprint computes the fingerprint of this execution from the
+// unresolved command line template and the inputs. It must be called after
+// prepareInputs.
+func (l *Launcher) cacheFingerprint(cmd string, args []string) (string, error) {
+	key := &cacheKey{
+		ContainerImage:   l.options.ContainerImage,
+		Command:          append([]string{cmd}, args...),
+		InputParameters:  make(map[string]cacheKeyParameter),
+		InputArtifacts:   make(map[string]cacheKeyArtifact),
+		OutputParameters: make(map[string]string),
+		OutputArtifacts:  make(map[string]string),
+	}
+	for n, ip := range l.runtimeInfo.InputParameters {
+		key.InputParameters[n] = cacheKeyParameter{Type: ip.ParameterType, Value: ip.value()}
+	}
+	for n, ia := range l.runtimeInfo.InputArtifacts {
+		key.InputArtifacts[n] = cacheKeyArtifact{ID: ia.Artifact.GetId(), URI: ia.Artifact.GetUri()}
+	}
+	for n, op := range l.runtimeInfo.OutputParameters {
+		key.OutputParameters[n] = op.ParameterType
+	}
+	for n, oa := range l.runtimeInfo.OutputArtifacts {
+		key.OutputArtifacts[n] = oa.ArtifactSchema
+	}
+
+	// encoding/json sorts map keys, so the encoding is deterministic.
+	b, err := json.Marshal(key)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// lookupCache returns a prior execution whose outputs can be reused, or nil.
+// Cache lookups are best effort: errors are logged and treated as a miss.
+func (l *Launcher) lookupCache(ctx context.Context, fingerprint string) *metadata.CachedExecution {
+	cached, err := l.metadata.GetCachedExecution(ctx, fingerprint)
+	if err != nil {
+		glog.Warningf("Cache lookup failed, running the component instead: %v", err)
+		return nil
+	}
+	if cached == nil {
+		return nil
+	}
+
+	// Only reuse executions that recorded every output we need.
+	for n := range l.runtimeInfo.OutputParameters {
+		if _, ok := cached.OutputParameters[n]; !ok {
+			glog.Infof("Cached execution %d has no output parameter %q, ignoring it", cached.ExecutionID, n)
+			return nil
+		}
+	}
+	for n := range l.runtimeInfo.OutputArtifacts {
+		if _, ok := cached.OutputArtifacts[n]; !ok {
+			glog.Infof("Cached execution %d has no output artifact %q, ignoring it", cached.ExecutionID, n)
+			return nil
+		}
+	}
+	return cached
+}
+
+// reuseCachedExecution writes the outputs of cached to the output files and
+// publishes execution as CACHED.
+func (l *Launcher) reuseCachedExecution(ctx context.Context, execution *metadata.Execution, cached *metadata.CachedExecution) error {
+	glog.Infof("Reusing outputs of cached execution %d", cached.ExecutionID)
+
+	for n, op := range l.runtimeInfo.OutputParameters {
+		text, err := parameterText(op.ParameterType, cached.OutputParameters[n])
+		if err != nil {
+			return fmt.Errorf("Cached output parameter %q: %v", n, err)
+		}
+		if err := os.MkdirAll(path.Dir(op.FileOutputPath), 0755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(op.FileOutputPath, []byte(text), 0644); err != nil {
+			return err
+		}
+	}
+
+	for n, oa := range l.runtimeInfo.OutputArtifacts {
+		b, err := protojson.Marshal(cached.OutputArtifacts[n])
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(path.Dir(oa.FileOutputPath), 0755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(oa.FileOutputPath, b, 0644); err != nil {
+			return err
+		}
+	}
+
+	return l.metadata.PublishCachedExecution(ctx, execution, cached)
+}
@@ -0,0 +1,80 @@
+package component
+
+import (
+	"testing"
+
+	pb "github.com/neuromage/kfp-launcher/third_party/ml_metadata"
+	"github.com/neuromage/kfp-launcher/third_party/pipeline_spec"
+	"google.golang.org/protobuf/proto"
+)
+
+func newCacheTestLauncher(paramValue string, artifactID int64) *Launcher {
+	return &Launcher{
+		options: &LauncherOptions{ContainerImage: "python:3.7"},
+		runtimeInfo: &runtimeInfo{
+			InputParameters: map[string]*inputParameter{
+				"lr": {ParameterType: "DOUBLE", ParameterValue: proto.String(paramValue)},
+			},
+			InputArtifacts: map[string]*inputArtifact{
+				"dataset": {Artifact: &pb.Artifact{Id: proto.Int64(artifactID), Uri: proto.String("gs://b/dataset")}},
+			},
+			OutputArtifacts: map[string]*outputArtifact{
+				"model": {ArtifactSchema: "title: kfp.Model\n"},
+			},
+		},
+	}
+}
+
+func TestLauncher_cacheFingerprint(t *testing.T) {
+	args := []string{"--lr={{$.inputs.parameters['lr']}}"}
+	fingerprint := func(l *Launcher, args []string) string {
+		t.Helper()
+		fp, err := l.cacheFingerprint("python", args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fp
+	}
+
+	base := fingerprint(newCacheTestLauncher("0.1", 1), args)
+	if got := fingerprint(newCacheTestLauncher("0.1", 1), args); got != base {
+		t.Errorf("Fingerprint is not deterministic: %q != %q", got, base)
+	}
+
+	changed := map[string]string{
+		"parameter value": fingerprint(newCacheTestLauncher("0.2", 1), args),
+		"artifact ID":     fingerprint(newCacheTestLauncher("0.1", 2), args),
+		"arguments":       fingerprint(newCacheTestLauncher("0.1", 1), []string{"--verbose"}),
+	}
+	for what, fp := range changed {
+		if fp == base {
+			t.Errorf("Fingerprint did not change when %s changed", what)
+		}
+	}
+
+	l := newCacheTestLauncher("0.1", 1)
+	l.options.ContainerImage = "python:3.8"
+	if fingerprint(l, args) == base {
+		t.Errorf("Fingerprint did not change when container image changed")
+	}
+}
+
+func TestLauncher_cachingEnabled(t *testing.T) {
+	tests := []struct {
+		name    string
+		options *pipeline_spec.PipelineTaskSpec_CachingOptions
+		want    bool
+	}{
+		{name: "Unset", options: nil, want: true},
+		{name: "Enabled", options: &pipeline_spec.PipelineTaskSpec_CachingOptions{EnableCache: true}, want: true},
+		{name: "Disabled", options: &pipeline_spec.PipelineTaskSpec_CachingOptions{EnableCache: false}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := &Launcher{options: &LauncherOptions{CachingOptions: tt.options}}
+			if got := l.cachingEnabled(); got != tt.want {
+				t.Errorf("cachingEnabled() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
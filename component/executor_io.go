@@ -0,0 +1,208 @@
+package component
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	pb "github.com/neuromage/kfp-launcher/third_party/ml_metadata"
+	"github.com/neuromage/kfp-launcher/third_party/pipeline_spec"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+const (
+	// executorInputPlaceholder is replaced by the JSON encoded ExecutorInput.
+	executorInputPlaceholder = "{{$}}"
+
+	// localPathProperty is the custom property of the artifacts in the
+	// ExecutorInput holding their local path: where input artifacts were
+	// downloaded to, and where output artifacts may be written to.
+	localPathProperty = "local_path"
+)
+
+// toPipelineValue converts a runtimeInfo parameter value to a pipeline spec
+// Value, according to its declared type.
+func toPipelineValue(parameterType, value string) (*pipeline_spec.Value, error) {
+	switch parameterType {
+	case "STRING":
+		return &pipeline_spec.Value{Value: &pipeline_spec.Value_StringValue{StringValue: value}}, nil
+	case "INT":
+		i, err := strconv.ParseInt(strings.TrimSpace(value), 10, 0)
+		if err != nil {
+			return nil, err
+		}
+		return &pipeline_spec.Value{Value: &pipeline_spec.Value_IntValue{IntValue: i}}, nil
+	case "DOUBLE":
+		f, err := strconv.ParseFloat(strings.TrimSpace(value), 0)
+		if err != nil {
+			return nil, err
+		}
+		return &pipeline_spec.Value{Value: &pipeline_spec.Value_DoubleValue{DoubleValue: f}}, nil
+	case "BOOLEAN", "LIST", "STRUCT":
+		// The pipeline spec has no such values, so they are passed as their
+		// JSON text, like KFP does.
+		text, err := validatedParameterText(parameterType, value)
+		if err != nil {
+			return nil, err
+		}
+		return &pipeline_spec.Value{Value: &pipeline_spec.Value_StringValue{StringValue: text}}, nil
+	}
+	return nil, fmt.Errorf("Unknown parameter type %q", parameterType)
+}
+
+// pipelineValueText returns the text representation of v, as it would be
+// written to an output parameter file.
+func pipelineValueText(v *pipeline_spec.Value) string {
+	switch t := v.GetValue().(type) {
+	case *pipeline_spec.Value_StringValue:
+		return t.StringValue
+	case *pipeline_spec.Value_IntValue:
+		return strconv.FormatInt(t.IntValue, 10)
+	case *pipeline_spec.Value_DoubleValue:
+		return strconv.FormatFloat(t.DoubleValue, 'f', -1, 64)
+	}
+	return ""
+}
+
+func stringPipelineValue(s string) *pipeline_spec.Value {
+	return &pipeline_spec.Value{Value: &pipeline_spec.Value_StringValue{StringValue: s}}
+}
+
+func toMLMDValue(v *pipeline_spec.Value) *pb.Value {
+	switch t := v.GetValue().(type) {
+	case *pipeline_spec.Value_StringValue:
+		return &pb.Value{Value: &pb.Value_StringValue{StringValue: t.StringValue}}
+	case *pipeline_spec.Value_IntValue:
+		return &pb.Value{Value: &pb.Value_IntValue{IntValue: t.IntValue}}
+	case *pipeline_spec.Value_DoubleValue:
+		return &pb.Value{Value: &pb.Value_DoubleValue{DoubleValue: t.DoubleValue}}
+	}
+	return nil
+}
+
+func toPipelineValueFromMLMD(v *pb.Value) *pipeline_spec.Value {
+	switch t := v.GetValue().(type) {
+	case *pb.Value_StringValue:
+		return &pipeline_spec.Value{Value: &pipeline_spec.Value_StringValue{StringValue: t.StringValue}}
+	case *pb.Value_IntValue:
+		return &pipeline_spec.Value{Value: &pipeline_spec.Value_IntValue{IntValue: t.IntValue}}
+	case *pb.Value_DoubleValue:
+		return &pipeline_spec.Value{Value: &pipeline_spec.Value_DoubleValue{DoubleValue: t.DoubleValue}}
+	}
+	return nil
+}
+
+func toRuntimeArtifact(a *pb.Artifact) *pipeline_spec.RuntimeArtifact {
+	ra := &pipeline_spec.RuntimeArtifact{
+		Name:             strconv.FormatInt(a.GetId(), 10),
+		Uri:              a.GetUri(),
+		Properties:       make(map[string]*pipeline_spec.Value),
+		CustomProperties: make(map[string]*pipeline_spec.Value),
+	}
+	if len(a.GetType()) > 0 {
+		ra.Type = &pipeline_spec.ArtifactTypeSchema{
+			Kind: &pipeline_spec.ArtifactTypeSchema_SchemaTitle{SchemaTitle: a.GetType()},
+		}
+	}
+	for k, v := range a.GetProperties() {
+		if pv := toPipelineValueFromMLMD(v); pv != nil {
+			ra.Properties[k] = pv
+		}
+	}
+	for k, v := range a.GetCustomProperties() {
+		if pv := toPipelineValueFromMLMD(v); pv != nil {
+			ra.CustomProperties[k] = pv
+		}
+	}
+	return ra
+}
+
+// executorInput builds the ExecutorInput handed to components through the
+// {{$}} placeholder. It must be called after prepareInputs and prepareOutputs.
+//
+// The ExecutorInput has no field for local paths, so they are passed as the
+// localPathProperty custom property of each artifact. Components may write an
+// output artifact either there, like with the path placeholder, or directly
+// to its URI.
+func (l *Launcher) executorInput() (*pipeline_spec.ExecutorInput, error) {
+	ei := &pipeline_spec.ExecutorInput{
+		Inputs: &pipeline_spec.ExecutorInput_Inputs{
+			Parameters: make(map[string]*pipeline_spec.Value),
+			Artifacts:  make(map[string]*pipeline_spec.ArtifactList),
+		},
+		Outputs: &pipeline_spec.ExecutorInput_Outputs{
+			Parameters: make(map[string]*pipeline_spec.ExecutorInput_OutputParameter),
+			Artifacts:  make(map[string]*pipeline_spec.ArtifactList),
+			OutputFile: l.executorOutputPath(),
+		},
+	}
+
+	for n, ip := range l.runtimeInfo.InputParameters {
+		v, err := toPipelineValue(ip.ParameterType, ip.value())
+		if err != nil {
+			return nil, fmt.Errorf("Failed to convert input parameter %q: %v", n, err)
+		}
+		ei.Inputs.Parameters[n] = v
+	}
+
+	for n, ia := range l.runtimeInfo.InputArtifacts {
+		ra := toRuntimeArtifact(ia.Artifact)
+		ra.CustomProperties[localPathProperty] = stringPipelineValue(ia.LocalArtifactFilePath)
+		ei.Inputs.Artifacts[n] = &pipeline_spec.ArtifactList{
+			Artifacts: []*pipeline_spec.RuntimeArtifact{ra},
+		}
+	}
+
+	for n, op := range l.runtimeInfo.OutputParameters {
+		ei.Outputs.Parameters[n] = &pipeline_spec.ExecutorInput_OutputParameter{OutputFile: op.FileOutputPath}
+	}
+
+	for n, oa := range l.runtimeInfo.OutputArtifacts {
+		ei.Outputs.Artifacts[n] = &pipeline_spec.ArtifactList{
+			Artifacts: []*pipeline_spec.RuntimeArtifact{{
+				Name: n,
+				Uri:  oa.URIOutputPath,
+				Type: &pipeline_spec.ArtifactTypeSchema{
+					Kind: &pipeline_spec.ArtifactTypeSchema_InstanceSchema{InstanceSchema: oa.ArtifactSchema},
+				},
+				CustomProperties: map[string]*pipeline_spec.Value{
+					localPathProperty: stringPipelineValue(oa.LocalArtifactFilePath),
+				},
+			}},
+		}
+	}
+
+	return ei, nil
+}
+
+// readExecutorOutput reads the ExecutorOutput written by the component. It
+// returns an empty ExecutorOutput if the component did not write one.
+func readExecutorOutput(path string) (*pipeline_spec.ExecutorOutput, error) {
+	eo := &pipeline_spec.ExecutorOutput{}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return eo, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := protojson.Unmarshal(b, eo); err != nil {
+		return nil, fmt.Errorf("Failed to parse executor output %q: %v", path, err)
+	}
+	return eo, nil
+}
+
+// outputArtifactFromExecutorOutput returns the artifact the component reported
+// for the named output, or nil if it reported none.
+func outputArtifactFromExecutorOutput(eo *pipeline_spec.ExecutorOutput, name string) (*pipeline_spec.RuntimeArtifact, error) {
+	list, ok := eo.GetArtifacts()[name]
+	if !ok || len(list.GetArtifacts()) == 0 {
+		return nil, nil
+	}
+	if len(list.GetArtifacts()) > 1 {
+		return nil, fmt.Errorf("Executor output reported %d artifacts for output %q, expected one", len(list.GetArtifacts()), name)
+	}
+	return list.GetArtifacts()[0], nil
+}
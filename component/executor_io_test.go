@@ -0,0 +1,131 @@
+package component
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	pb "github.com/neuromage/kfp-launcher/third_party/ml_metadata"
+	"github.com/neuromage/kfp-launcher/third_party/pipeline_spec"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/testing/protocmp"
+)
+
+func TestLauncher_executorInput(t *testing.T) {
+	l := &Launcher{
+		options: &LauncherOptions{LocalDir: "/work"},
+		runtimeInfo: &runtimeInfo{
+			InputParameters: map[string]*inputParameter{
+				"lr":     {ParameterType: "DOUBLE", ParameterValue: proto.String("0.5")},
+				"epochs": {ParameterType: "INT", ParameterValue: proto.String("10")},
+			},
+			InputArtifacts: map[string]*inputArtifact{
+				"dataset": {Artifact: &pb.Artifact{
+					Id:   proto.Int64(7),
+					Type: proto.String("kfp.Dataset"),
+					Uri:  proto.String("gs://my-bucket/dataset"),
+				}, LocalArtifactFilePath: "/tmp/inputs/dataset/data"},
+			},
+			OutputParameters: map[string]*outputParameter{
+				"accuracy": {ParameterType: "DOUBLE", FileOutputPath: "/tmp/outputs/accuracy/data"},
+			},
+			OutputArtifacts: map[string]*outputArtifact{
+				"model": {ArtifactSchema: "title: kfp.Model\n", URIOutputPath: "gs://my-bucket/model", LocalArtifactFilePath: "/tmp/outputs/model/data"},
+			},
+		},
+	}
+
+	got, err := l.executorInput()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &pipeline_spec.ExecutorInput{
+		Inputs: &pipeline_spec.ExecutorInput_Inputs{
+			Parameters: map[string]*pipeline_spec.Value{
+				"lr":     {Value: &pipeline_spec.Value_DoubleValue{DoubleValue: 0.5}},
+				"epochs": {Value: &pipeline_spec.Value_IntValue{IntValue: 10}},
+			},
+			Artifacts: map[string]*pipeline_spec.ArtifactList{
+				"dataset": {Artifacts: []*pipeline_spec.RuntimeArtifact{{
+					Name: "7",
+					Uri:  "gs://my-bucket/dataset",
+					Type: &pipeline_spec.ArtifactTypeSchema{
+						Kind: &pipeline_spec.ArtifactTypeSchema_SchemaTitle{SchemaTitle: "kfp.Dataset"},
+					},
+					CustomProperties: map[string]*pipeline_spec.Value{
+						localPathProperty: {Value: &pipeline_spec.Value_StringValue{StringValue: "/tmp/inputs/dataset/data"}},
+					},
+				}}},
+			},
+		},
+		Outputs: &pipeline_spec.ExecutorInput_Outputs{
+			Parameters: map[string]*pipeline_spec.ExecutorInput_OutputParameter{
+				"accuracy": {OutputFile: "/tmp/outputs/accuracy/data"},
+			},
+			Artifacts: map[string]*pipeline_spec.ArtifactList{
+				"model": {Artifacts: []*pipeline_spec.RuntimeArtifact{{
+					Name: "model",
+					Uri:  "gs://my-bucket/model",
+					Type: &pipeline_spec.ArtifactTypeSchema{
+						Kind: &pipeline_spec.ArtifactTypeSchema_InstanceSchema{InstanceSchema: "title: kfp.Model\n"},
+					},
+					CustomProperties: map[string]*pipeline_spec.Value{
+						localPathProperty: {Value: &pipeline_spec.Value_StringValue{StringValue: "/tmp/outputs/model/data"}},
+					},
+				}}},
+			},
+			OutputFile: "/work/kfp_launcher_outputs/executor_output.json",
+		},
+	}
+	if diff := cmp.Diff(want, got, protocmp.Transform()); diff != "" {
+		t.Errorf("executorInput() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func Test_readExecutorOutput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kfp-launcher-executor-output")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A missing file means the component does not use the executor protocol.
+	got, err := readExecutorOutput(filepath.Join(dir, "missing.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.GetParameters()) != 0 || len(got.GetArtifacts()) != 0 {
+		t.Errorf("readExecutorOutput() of missing file = %v, want empty", got)
+	}
+
+	p := filepath.Join(dir, "executor_output.json")
+	eo := `{
+		"parameters": {"accuracy": {"doubleValue": 0.9}},
+		"artifacts": {"model": {"artifacts": [{
+			"uri": "gs://other-bucket/model",
+			"customProperties": {"framework": {"stringValue": "tensorflow"}}
+		}]}}
+	}`
+	if err := ioutil.WriteFile(p, []byte(eo), 0644); err != nil {
+		t.Fatal(err)
+	}
+	got, err = readExecutorOutput(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text := pipelineValueText(got.GetParameters()["accuracy"]); text != "0.9" {
+		t.Errorf("accuracy = %q, want %q", text, "0.9")
+	}
+	a, err := outputArtifactFromExecutorOutput(got, "model")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.GetUri() != "gs://other-bucket/model" {
+		t.Errorf("model URI = %q, want %q", a.GetUri(), "gs://other-bucket/model")
+	}
+	if v := toMLMDValue(a.GetCustomProperties()["framework"]); v.GetStringValue() != "tensorflow" {
+		t.Errorf("framework = %v, want %q", v, "tensorflow")
+	}
+}
@@ -0,0 +1,193 @@
+package component
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+
+	"github.com/golang/glog"
+	"github.com/neuromage/kfp-launcher/metadata"
+	pb "github.com/neuromage/kfp-launcher/third_party/ml_metadata"
+	"github.com/neuromage/kfp-launcher/third_party/pipeline_spec"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// RunImporter registers the existing artifact described by spec in MLMD
+// instead of running a user command, so that external data enters lineage
+// the same way as produced artifacts. The artifact is recorded as the OUTPUT
+// of an importer execution under the pipeline run contexts, and written to
+// the output file of the single output artifact in runtime info.
+//
+// Runtime parameters in spec refer to input parameters in runtime info. If the
+// launcher is asked to terminate, the returned error satisfies IsCanceled.
+func (l *Launcher) RunImporter(ctx context.Context, spec *pipeline_spec.PipelineDeploymentConfig_ImporterSpec) error {
+	ctx, stop := notifyTermination(ctx)
+	defer stop()
+
+	err := l.runImporter(ctx, spec)
+	if err != nil && ctx.Err() != nil && !IsCanceled(err) {
+		err = &canceledError{sig: terminationSignal(ctx), err: err}
+	}
+	return err
+}
+
+func (l *Launcher) runImporter(ctx context.Context, spec *pipeline_spec.PipelineDeploymentConfig_ImporterSpec) error {
+	outputName, output, err := l.importerOutput()
+	if err != nil {
+		return err
+	}
+	if err := l.prepareInputs(ctx); err != nil {
+		return err
+	}
+	schema, artifact, err := l.importerArtifact(spec, output)
+	if err != nil {
+		return err
+	}
+
+	pipeline, err := l.metadata.GetPipeline(ctx, l.options.PipelineName, l.options.PipelineRunID)
+	if err != nil {
+		return err
+	}
+	ecfg := &metadata.ExecutionConfig{
+		InputParameters: &metadata.Parameters{
+			IntParameters:    make(map[string]int64),
+			StringParameters: make(map[string]string),
+			DoubleParameters: make(map[string]float64),
+		},
+		RetryAttempt: l.options.RetryAttempt,
+		Type:         metadata.ImporterExecution,
+	}
+	for n, ip := range l.runtimeInfo.InputParameters {
+		v, err := parseParameter(ip.ParameterType, ip.value())
+		if err != nil {
+			return fmt.Errorf("Failed to parse input parameter %q: %v", n, err)
+		}
+		addParameter(ecfg.InputParameters, n, v)
+	}
+	execution, err := l.metadata.CreateExecution(ctx, pipeline, l.options.TaskName, l.options.PipelineTaskID, l.options.ContainerImage, ecfg)
+	if err != nil {
+		return err
+	}
+
+	if err := l.importArtifact(ctx, execution, outputName, output, schema, artifact, spec.GetReimport()); err != nil {
+		if ctx.Err() != nil {
+			l.cancelExecution(execution, err)
+		} else if ferr := l.metadata.FailExecution(ctx, execution, executionFailure(err)); ferr != nil {
+			glog.Errorf("Failed to record execution failure in MLMD: %v", ferr)
+		}
+		return err
+	}
+	return nil
+}
+
+func (l *Launcher) importArtifact(ctx context.Context, execution *metadata.Execution, outputName string, output *outputArtifact, schema string, artifact *pb.Artifact, reimport bool) error {
+	artifact, err := l.metadata.ImportArtifact(ctx, schema, artifact, reimport)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(path.Dir(output.FileOutputPath), 0755); err != nil {
+		return err
+	}
+	b, err := protojson.Marshal(artifact)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(output.FileOutputPath, b, 0644); err != nil {
+		return err
+	}
+
+	outputParameters := &metadata.Parameters{
+		IntParameters:    make(map[string]int64),
+		StringParameters: make(map[string]string),
+		DoubleParameters: make(map[string]float64),
+	}
+	outputArtifacts := []*metadata.OutputArtifact{{Name: outputName, Artifact: artifact, Schema: schema}}
+	return l.metadata.PublishExecution(ctx, execution, outputParameters, outputArtifacts)
+}
+
+// importerOutput returns the single output artifact of an importer.
+func (l *Launcher) importerOutput() (string, *outputArtifact, error) {
+	if len(l.runtimeInfo.OutputArtifacts) != 1 || len(l.runtimeInfo.OutputParameters) != 0 {
+		return "", nil, fmt.Errorf("An importer must have exactly one output artifact and no output parameters, got %d and %d",
+			len(l.runtimeInfo.OutputArtifacts), len(l.runtimeInfo.OutputParameters))
+	}
+	var (
+		name   string
+		output *outputArtifact
+	)
+	for name, output = range l.runtimeInfo.OutputArtifacts {
+	}
+	return name, output, nil
+}
+
+// importerArtifact returns the artifact described by spec and its schema. The
+// schema comes from the type schema in spec, or from output if spec has none.
+// It must be called after prepareInputs.
+func (l *Launcher) importerArtifact(spec *pipeline_spec.PipelineDeploymentConfig_ImporterSpec, output *outputArtifact) (string, *pb.Artifact, error) {
+	var schema string
+	switch ts := spec.GetTypeSchema(); {
+	case len(ts.GetInstanceSchema()) > 0:
+		schema = ts.GetInstanceSchema()
+	case len(ts.GetSchemaTitle()) > 0:
+		schema = fmt.Sprintf("title: %s\n", ts.GetSchemaTitle())
+	case len(ts.GetSchemaUri()) > 0:
+		return "", nil, fmt.Errorf("Importer type schema URI %q is not supported, use an instance schema or schema title", ts.GetSchemaUri())
+	default:
+		schema = output.ArtifactSchema
+	}
+
+	uri, err := l.importerValue(spec.GetArtifactUri())
+	if err != nil {
+		return "", nil, fmt.Errorf("Invalid importer artifact URI: %v", err)
+	}
+	if len(uri.GetStringValue()) == 0 {
+		return "", nil, fmt.Errorf("Importer artifact URI must be a non-empty string, got %v", uri)
+	}
+
+	artifact := &pb.Artifact{
+		Uri:              proto.String(uri.GetStringValue()),
+		CustomProperties: make(map[string]*pb.Value),
+	}
+	for n, p := range spec.GetProperties() {
+		v, err := l.importerValue(p)
+		if err != nil {
+			return "", nil, fmt.Errorf("Invalid importer property %q: %v", n, err)
+		}
+		if artifact.Properties == nil {
+			artifact.Properties = make(map[string]*pb.Value)
+		}
+		artifact.Properties[n] = v
+	}
+	for n, p := range spec.GetCustomProperties() {
+		v, err := l.importerValue(p)
+		if err != nil {
+			return "", nil, fmt.Errorf("Invalid importer custom property %q: %v", n, err)
+		}
+		artifact.CustomProperties[n] = v
+	}
+	return schema, artifact, nil
+}
+
+// importerValue resolves a constant, or a runtime parameter naming an input
+// parameter, to an MLMD value.
+func (l *Launcher) importerValue(v *pipeline_spec.ValueOrRuntimeParameter) (*pb.Value, error) {
+	if v.GetConstantValue() != nil {
+		if mv := toMLMDValue(v.GetConstantValue()); mv != nil {
+			return mv, nil
+		}
+		return nil, fmt.Errorf("unsupported constant value %v", v.GetConstantValue())
+	}
+	name := v.GetRuntimeParameter()
+	if len(name) == 0 {
+		return nil, fmt.Errorf("missing value")
+	}
+	ip, ok := l.runtimeInfo.InputParameters[name]
+	if !ok {
+		return nil, fmt.Errorf("runtime parameter %q is not an input parameter", name)
+	}
+	return parseParameter(ip.ParameterType, ip.value())
+}
@@ -0,0 +1,97 @@
+package component
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	pb "github.com/neuromage/kfp-launcher/third_party/ml_metadata"
+	"github.com/neuromage/kfp-launcher/third_party/pipeline_spec"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/testing/protocmp"
+)
+
+func TestImporterArtifact(t *testing.T) {
+	constant := func(v *pipeline_spec.Value) *pipeline_spec.ValueOrRuntimeParameter {
+		return &pipeline_spec.ValueOrRuntimeParameter{Value: &pipeline_spec.ValueOrRuntimeParameter_ConstantValue{ConstantValue: v}}
+	}
+	runtimeParameter := func(name string) *pipeline_spec.ValueOrRuntimeParameter {
+		return &pipeline_spec.ValueOrRuntimeParameter{Value: &pipeline_spec.ValueOrRuntimeParameter_RuntimeParameter{RuntimeParameter: name}}
+	}
+	output := &outputArtifact{ArtifactSchema: "title: kfp.Artifact\n"}
+
+	tests := []struct {
+		name       string
+		spec       *pipeline_spec.PipelineDeploymentConfig_ImporterSpec
+		wantSchema string
+		want       *pb.Artifact
+		wantErr    bool
+	}{
+		{
+			name: "Runtime parameters",
+			spec: &pipeline_spec.PipelineDeploymentConfig_ImporterSpec{
+				ArtifactUri: runtimeParameter("uri"),
+				TypeSchema:  &pipeline_spec.ArtifactTypeSchema{Kind: &pipeline_spec.ArtifactTypeSchema_SchemaTitle{SchemaTitle: "kfp.Dataset"}},
+				Properties: map[string]*pipeline_spec.ValueOrRuntimeParameter{
+					"rows": runtimeParameter("rows"),
+				},
+				CustomProperties: map[string]*pipeline_spec.ValueOrRuntimeParameter{
+					"source": constant(&pipeline_spec.Value{Value: &pipeline_spec.Value_StringValue{StringValue: "census"}}),
+				},
+			},
+			wantSchema: "title: kfp.Dataset\n",
+			want: &pb.Artifact{
+				Uri:              proto.String("gs://bucket/census.csv"),
+				Properties:       map[string]*pb.Value{"rows": {Value: &pb.Value_IntValue{IntValue: 1000}}},
+				CustomProperties: map[string]*pb.Value{"source": {Value: &pb.Value_StringValue{StringValue: "census"}}},
+			},
+		},
+		{
+			name: "Schema from runtime info",
+			spec: &pipeline_spec.PipelineDeploymentConfig_ImporterSpec{
+				ArtifactUri: constant(&pipeline_spec.Value{Value: &pipeline_spec.Value_StringValue{StringValue: "gs://bucket/data"}}),
+			},
+			wantSchema: "title: kfp.Artifact\n",
+			want: &pb.Artifact{
+				Uri:              proto.String("gs://bucket/data"),
+				CustomProperties: map[string]*pb.Value{},
+			},
+		},
+		{
+			name: "Unknown runtime parameter",
+			spec: &pipeline_spec.PipelineDeploymentConfig_ImporterSpec{
+				ArtifactUri: runtimeParameter("missing"),
+			},
+			wantErr: true,
+		},
+		{
+			name: "URI is not a string",
+			spec: &pipeline_spec.PipelineDeploymentConfig_ImporterSpec{
+				ArtifactUri: runtimeParameter("rows"),
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := &Launcher{runtimeInfo: &runtimeInfo{
+				InputParameters: map[string]*inputParameter{
+					"uri":  {ParameterType: "STRING", ParameterValue: proto.String("gs://bucket/census.csv")},
+					"rows": {ParameterType: "INT", ParameterValue: proto.String("1000")},
+				},
+			}}
+			schema, got, err := l.importerArtifact(tt.spec, output)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("importerArtifact() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if schema != tt.wantSchema {
+				t.Errorf("importerArtifact() schema = %q, want %q", schema, tt.wantSchema)
+			}
+			if diff := cmp.Diff(tt.want, got, protocmp.Transform()); diff != "" {
+				t.Errorf("importerArtifact() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
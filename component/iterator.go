@@ -0,0 +1,324 @@
+package component
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/neuromage/kfp-launcher/metadata"
+	pb "github.com/neuromage/kfp-launcher/third_party/ml_metadata"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// iteration identifies one run of a task with an iterator.
+type iteration struct {
+	index int
+	// pipeline includes the iterator context of the task.
+	pipeline *metadata.Pipeline
+}
+
+// runIterations runs the task once per item of its iterator, in order, each
+// as its own execution in the iterator context of the task. The first failed
+// iteration fails the task. Once all iterations succeed, the output files hold
+// JSON arrays of the outputs of every iteration.
+func (l *Launcher) runIterations(ctx context.Context, cmd string, args []string) error {
+	var (
+		artifacts []*pb.Artifact
+		items     []string
+		err       error
+	)
+	if it := l.runtimeInfo.ArtifactIterator; it != nil {
+		artifacts, err = l.iteratorArtifacts(it)
+	} else {
+		items, err = l.iteratorParameters(l.runtimeInfo.ParameterIterator)
+	}
+	if err != nil {
+		return err
+	}
+
+	pipeline, err := l.metadata.GetPipeline(ctx, l.options.PipelineName, l.options.PipelineRunID)
+	if err != nil {
+		return err
+	}
+	pipeline, err = l.metadata.GetIterator(ctx, pipeline, l.options.PipelineTaskID)
+	if err != nil {
+		return err
+	}
+
+	n := len(artifacts) + len(items)
+	iterations := make([]*runtimeInfo, n)
+	for i := 0; i < n; i++ {
+		it := l.iterationLauncher(i, pipeline)
+		if artifacts != nil {
+			err = it.setArtifactItem(l.runtimeInfo.ArtifactIterator, artifacts[i])
+		} else {
+			err = it.setParameterItem(l.runtimeInfo.ParameterIterator, items[i])
+		}
+		if err != nil {
+			return fmt.Errorf("Iteration %d failed: %w", i, err)
+		}
+		if err := it.runComponent(ctx, cmd, args); err != nil {
+			return fmt.Errorf("Iteration %d failed: %w", i, err)
+		}
+		iterations[i] = it.runtimeInfo
+	}
+
+	return l.collectIterationOutputs(iterations)
+}
+
+// iteratorArtifacts returns the items of an artifact iterator. An absent
+// optional input has no items.
+func (l *Launcher) iteratorArtifacts(it *artifactIterator) ([]*pb.Artifact, error) {
+	name := it.Items.InputArtifact
+	ia := l.runtimeInfo.InputArtifacts[name]
+	if len(ia.FileInputPath) == 0 {
+		if !ia.Optional {
+			return nil, fmt.Errorf("Missing input artifact metadata file for input: %q", name)
+		}
+		return []*pb.Artifact{}, nil
+	}
+	artifacts, err := readArtifactList(ia.FileInputPath)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read iterator items from input artifact %q: %v", name, err)
+	}
+	return artifacts, nil
+}
+
+// readArtifactList reads a JSON array of MLMD artifacts from p. A single
+// artifact is read as a list of one.
+func readArtifactList(p string) ([]*pb.Artifact, error) {
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	b = bytes.TrimSpace(b)
+	if !bytes.HasPrefix(b, []byte("[")) {
+		a := &pb.Artifact{}
+		if err := protojson.Unmarshal(b, a); err != nil {
+			return nil, err
+		}
+		return []*pb.Artifact{a}, nil
+	}
+
+	var raw []json.RawMessage
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return nil, err
+	}
+	artifacts := make([]*pb.Artifact, len(raw))
+	for i, r := range raw {
+		artifacts[i] = &pb.Artifact{}
+		if err := protojson.Unmarshal(r, artifacts[i]); err != nil {
+			return nil, fmt.Errorf("item %d: %v", i, err)
+		}
+	}
+	return artifacts, nil
+}
+
+// iteratorParameters returns the items of a parameter iterator, each as
+// compact JSON.
+func (l *Launcher) iteratorParameters(it *parameterIterator) ([]string, error) {
+	var text string
+	if it.Items.Raw != nil {
+		text = *it.Items.Raw
+	} else {
+		ip := l.runtimeInfo.InputParameters[it.Items.InputParameter]
+		v := ip.ParameterValue
+		if v == nil {
+			v = ip.DefaultValue
+		}
+		if v == nil {
+			return nil, fmt.Errorf("Missing value for input parameter: %q", it.Items.InputParameter)
+		}
+		text = *v
+	}
+
+	var raw []json.RawMessage
+	if err := json.Unmarshal([]byte(text), &raw); err != nil || raw == nil {
+		return nil, fmt.Errorf("Iterator items must be a JSON array, got %q", text)
+	}
+	items := make([]string, len(raw))
+	for i, r := range raw {
+		var b bytes.Buffer
+		if err := json.Compact(&b, r); err != nil {
+			return nil, err
+		}
+		items[i] = b.String()
+	}
+	return items, nil
+}
+
+// iterationLauncher returns a launcher running iteration i of the task. Its
+// runtime info has no iterator, and its output files are private to the
+// iteration.
+func (l *Launcher) iterationLauncher(i int, pipeline *metadata.Pipeline) *Launcher {
+	rt := &runtimeInfo{
+		InputParameters:  make(map[string]*inputParameter),
+		InputArtifacts:   make(map[string]*inputArtifact),
+		OutputParameters: make(map[string]*outputParameter),
+		OutputArtifacts:  make(map[string]*outputArtifact),
+	}
+	for n, v := range l.runtimeInfo.InputParameters {
+		c := *v
+		rt.InputParameters[n] = &c
+	}
+	for n, v := range l.runtimeInfo.InputArtifacts {
+		c := *v
+		rt.InputArtifacts[n] = &c
+	}
+	dir := path.Join(l.iterationsDir(), strconv.Itoa(i), "outputs")
+	for n, v := range l.runtimeInfo.OutputParameters {
+		c := *v
+		c.FileOutputPath = path.Join(dir, "parameters", n)
+		rt.OutputParameters[n] = &c
+	}
+	for n, v := range l.runtimeInfo.OutputArtifacts {
+		c := *v
+		c.FileOutputPath = path.Join(dir, "artifacts", n)
+		rt.OutputArtifacts[n] = &c
+	}
+
+	if it := l.runtimeInfo.ArtifactIterator; it != nil && len(it.IndexInput) > 0 {
+		rt.InputParameters[it.IndexInput] = indexParameter(i)
+	}
+	if it := l.runtimeInfo.ParameterIterator; it != nil && len(it.IndexInput) > 0 {
+		rt.InputParameters[it.IndexInput] = indexParameter(i)
+	}
+
+	return &Launcher{
+		options:                 l.options,
+		runtimeInfo:             rt,
+		placeholderReplacements: make(map[string]string),
+		metadata:                l.metadata,
+		bucketConfig:            l.bucketConfig,
+		iteration:               &iteration{index: i, pipeline: pipeline},
+	}
+}
+
+func indexParameter(i int) *inputParameter {
+	v := strconv.Itoa(i)
+	return &inputParameter{ParameterType: parameterTypeInt, ParameterValue: &v}
+}
+
+// setArtifactItem passes a as the item input of it, in place of the artifact
+// list.
+func (l *Launcher) setArtifactItem(it *artifactIterator, a *pb.Artifact) error {
+	delete(l.runtimeInfo.InputArtifacts, it.Items.InputArtifact)
+	// Do not mix the item with files downloaded for an earlier iteration.
+	if err := os.RemoveAll(l.localInputDir(it.ItemInput)); err != nil {
+		return err
+	}
+	l.runtimeInfo.InputArtifacts[it.ItemInput] = &inputArtifact{Artifact: a}
+	return nil
+}
+
+// setParameterItem passes item, as compact JSON, as the item input of it. Its
+// type follows the JSON type of the item.
+func (l *Launcher) setParameterItem(it *parameterIterator, item string) error {
+	t, text, err := parameterItem(item)
+	if err != nil {
+		return err
+	}
+	l.runtimeInfo.InputParameters[it.ItemInput] = &inputParameter{ParameterType: t, ParameterValue: &text}
+	return nil
+}
+
+// parameterItem returns the parameter type and text of item, a JSON value.
+func parameterItem(item string) (string, string, error) {
+	d := json.NewDecoder(strings.NewReader(item))
+	d.UseNumber()
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		return "", "", err
+	}
+	switch v := v.(type) {
+	case string:
+		return parameterTypeString, v, nil
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			return parameterTypeInt, v.String(), nil
+		}
+		return parameterTypeDouble, v.String(), nil
+	case bool:
+		return parameterTypeBoolean, item, nil
+	case []interface{}:
+		return parameterTypeList, item, nil
+	case map[string]interface{}:
+		return parameterTypeStruct, item, nil
+	}
+	return "", "", fmt.Errorf("Unsupported iterator item %s", item)
+}
+
+// collectIterationOutputs writes the outputs of iterations, in order, as JSON
+// arrays to the task's output files.
+func (l *Launcher) collectIterationOutputs(iterations []*runtimeInfo) error {
+	for n, op := range l.runtimeInfo.OutputParameters {
+		values := make([]string, len(iterations))
+		for i, rt := range iterations {
+			b, err := ioutil.ReadFile(rt.OutputParameters[n].FileOutputPath)
+			if err != nil {
+				return err
+			}
+			v, err := parseParameter(op.ParameterType, string(b))
+			if err != nil {
+				return fmt.Errorf("Failed to parse output parameter %q of iteration %d: %v", n, i, err)
+			}
+			if values[i], err = parameterJSON(op.ParameterType, v); err != nil {
+				return err
+			}
+		}
+		if err := writeOutputFile(op.FileOutputPath, []byte("["+strings.Join(values, ",")+"]")); err != nil {
+			return err
+		}
+	}
+
+	for n, oa := range l.runtimeInfo.OutputArtifacts {
+		artifacts := make([]*pb.Artifact, len(iterations))
+		for i, rt := range iterations {
+			b, err := ioutil.ReadFile(rt.OutputArtifacts[n].FileOutputPath)
+			if err != nil {
+				return err
+			}
+			artifacts[i] = &pb.Artifact{}
+			if err := protojson.Unmarshal(b, artifacts[i]); err != nil {
+				return fmt.Errorf("Failed to read output artifact %q of iteration %d: %v", n, i, err)
+			}
+		}
+		b, err := marshalArtifactList(artifacts)
+		if err != nil {
+			return err
+		}
+		if err := writeOutputFile(oa.FileOutputPath, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parameterJSON returns v, an MLMD value of parameter type t, as JSON.
+func parameterJSON(t string, v *pb.Value) (string, error) {
+	text, err := parameterText(t, v)
+	if err != nil {
+		return "", err
+	}
+	if t != parameterTypeString {
+		return text, nil
+	}
+	b, err := json.Marshal(text)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func writeOutputFile(p string, b []byte) error {
+	if err := os.MkdirAll(path.Dir(p), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(p, b, 0644)
+}
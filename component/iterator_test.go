@@ -0,0 +1,207 @@
+package component
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	pb "github.com/neuromage/kfp-launcher/third_party/ml_metadata"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/testing/protocmp"
+)
+
+func Test_parameterItem(t *testing.T) {
+	tests := []struct {
+		item     string
+		wantType string
+		wantText string
+		wantErr  bool
+	}{
+		{item: `"a b"`, wantType: parameterTypeString, wantText: "a b"},
+		{item: `42`, wantType: parameterTypeInt, wantText: "42"},
+		{item: `0.5`, wantType: parameterTypeDouble, wantText: "0.5"},
+		{item: `true`, wantType: parameterTypeBoolean, wantText: "true"},
+		{item: `[1,"a"]`, wantType: parameterTypeList, wantText: `[1,"a"]`},
+		{item: `{"lr":0.1}`, wantType: parameterTypeStruct, wantText: `{"lr":0.1}`},
+		{item: `null`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.item, func(t *testing.T) {
+			gotType, gotText, err := parameterItem(tt.item)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parameterItem() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if gotType != tt.wantType || gotText != tt.wantText {
+				t.Errorf("parameterItem() = %q, %q, want %q, %q", gotType, gotText, tt.wantType, tt.wantText)
+			}
+		})
+	}
+}
+
+func TestIteratorParameters(t *testing.T) {
+	items := `[ "a", 1, {"b": [true]} ]`
+	l := &Launcher{runtimeInfo: &runtimeInfo{
+		InputParameters: map[string]*inputParameter{
+			"items": {ParameterType: parameterTypeList, DefaultValue: &items},
+		},
+	}}
+	it := &parameterIterator{}
+	it.Items.InputParameter = "items"
+
+	got, err := l.iteratorParameters(it)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{`"a"`, `1`, `{"b":[true]}`}, got); diff != "" {
+		t.Errorf("iteratorParameters() mismatch (-want +got):\n%s", diff)
+	}
+
+	raw := `{"a": 1}`
+	it.Items.Raw = &raw
+	if _, err := l.iteratorParameters(it); err == nil {
+		t.Errorf("iteratorParameters(%s) succeeded, want an error", raw)
+	}
+}
+
+func TestReadArtifactList(t *testing.T) {
+	dir := t.TempDir()
+	want := []*pb.Artifact{
+		{Id: proto.Int64(1), Uri: proto.String("gs://b/1")},
+		{Id: proto.Int64(2), Uri: proto.String("gs://b/2")},
+	}
+	b, err := marshalArtifactList(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	list := filepath.Join(dir, "list")
+	single := filepath.Join(dir, "single")
+	if err := ioutil.WriteFile(list, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(single, []byte(`{"id": "1", "uri": "gs://b/1"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := readArtifactList(list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, got, protocmp.Transform()); diff != "" {
+		t.Errorf("readArtifactList() mismatch (-want +got):\n%s", diff)
+	}
+
+	got, err = readArtifactList(single)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want[:1], got, protocmp.Transform()); diff != "" {
+		t.Errorf("readArtifactList() of a single artifact mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestIterationLauncher(t *testing.T) {
+	l := &Launcher{
+		options: &LauncherOptions{LocalDir: "/work"},
+		runtimeInfo: &runtimeInfo{
+			InputArtifacts: map[string]*inputArtifact{
+				"models": {FileInputPath: "/tmp/inputs/models"},
+			},
+			OutputParameters: map[string]*outputParameter{
+				"accuracy": {ParameterType: parameterTypeDouble, FileOutputPath: "/tmp/outputs/accuracy"},
+			},
+			ArtifactIterator: &artifactIterator{ItemInput: "model", IndexInput: "index"},
+		},
+	}
+	l.runtimeInfo.ArtifactIterator.Items.InputArtifact = "models"
+
+	it := l.iterationLauncher(3, nil)
+	if err := it.setArtifactItem(l.runtimeInfo.ArtifactIterator, &pb.Artifact{Uri: proto.String("gs://b/3")}); err != nil {
+		t.Fatal(err)
+	}
+
+	rt := it.runtimeInfo
+	if rt.ArtifactIterator != nil {
+		t.Errorf("Iteration runtime info has an iterator")
+	}
+	if _, ok := rt.InputArtifacts["models"]; ok {
+		t.Errorf("Iteration has the artifact list as input")
+	}
+	if got := rt.InputArtifacts["model"].Artifact.GetUri(); got != "gs://b/3" {
+		t.Errorf("Item input URI = %q, want %q", got, "gs://b/3")
+	}
+	if got := rt.InputParameters["index"].value(); got != "3" {
+		t.Errorf("Index input = %q, want %q", got, "3")
+	}
+	if got, want := rt.OutputParameters["accuracy"].FileOutputPath, "/work/kfp_launcher_iterations/3/outputs/parameters/accuracy"; got != want {
+		t.Errorf("Iteration output file = %q, want %q", got, want)
+	}
+	if got := l.runtimeInfo.OutputParameters["accuracy"].FileOutputPath; got != "/tmp/outputs/accuracy" {
+		t.Errorf("Task output file changed to %q", got)
+	}
+}
+
+func TestCollectIterationOutputs(t *testing.T) {
+	dir := t.TempDir()
+	l := &Launcher{runtimeInfo: &runtimeInfo{
+		OutputParameters: map[string]*outputParameter{
+			"name":  {ParameterType: parameterTypeString, FileOutputPath: filepath.Join(dir, "name")},
+			"score": {ParameterType: parameterTypeDouble, FileOutputPath: filepath.Join(dir, "score")},
+		},
+		OutputArtifacts: map[string]*outputArtifact{
+			"model": {FileOutputPath: filepath.Join(dir, "model")},
+		},
+	}}
+
+	var iterations []*runtimeInfo
+	for i, v := range []struct{ name, score, model string }{
+		{`a "b"`, "0.5", `{"uri": "gs://b/0"}`},
+		{"c", "1", `{"uri": "gs://b/1"}`},
+	} {
+		rt := &runtimeInfo{
+			OutputParameters: map[string]*outputParameter{
+				"name":  {FileOutputPath: filepath.Join(dir, "iterations", "name", string(rune('0'+i)))},
+				"score": {FileOutputPath: filepath.Join(dir, "iterations", "score", string(rune('0'+i)))},
+			},
+			OutputArtifacts: map[string]*outputArtifact{
+				"model": {FileOutputPath: filepath.Join(dir, "iterations", "model", string(rune('0'+i)))},
+			},
+		}
+		for p, text := range map[string]string{
+			rt.OutputParameters["name"].FileOutputPath:  v.name,
+			rt.OutputParameters["score"].FileOutputPath: v.score,
+			rt.OutputArtifacts["model"].FileOutputPath:  v.model,
+		} {
+			if err := writeOutputFile(p, []byte(text)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		iterations = append(iterations, rt)
+	}
+
+	if err := l.collectIterationOutputs(iterations); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]string{
+		"name":  `["a \"b\"","c"]`,
+		"score": `[0.5,1]`,
+	} {
+		b, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(b); got != want {
+			t.Errorf("Output %q = %s, want %s", name, got, want)
+		}
+	}
+
+	got, err := readArtifactList(filepath.Join(dir, "model"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*pb.Artifact{{Uri: proto.String("gs://b/0")}, {Uri: proto.String("gs://b/1")}}
+	if diff := cmp.Diff(want, got, protocmp.Transform()); diff != "" {
+		t.Errorf("Output \"model\" mismatch (-want +got):\n%s", diff)
+	}
+}
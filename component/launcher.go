@@ -2,24 +2,26 @@ package component
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"path"
+	"path/filepath"
 	"regexp"
-	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/davecgh/go-spew/spew"
 	"github.com/golang/glog"
 	"github.com/neuromage/kfp-launcher/metadata"
+	"github.com/neuromage/kfp-launcher/storage"
 	pb "github.com/neuromage/kfp-launcher/third_party/ml_metadata"
+	"github.com/neuromage/kfp-launcher/third_party/pipeline_spec"
 	"google.golang.org/protobuf/encoding/protojson"
-
-	"gocloud.dev/blob"
-	_ "gocloud.dev/blob/gcsblob"
+	"google.golang.org/protobuf/proto"
 )
 
 // Launcher ...
@@ -29,6 +31,8 @@ type Launcher struct {
 	placeholderReplacements map[string]string
 	metadata                *metadata.Client
 	bucketConfig            *bucketConfig
+	// iteration is set on the launchers running the iterations of a task.
+	iteration *iteration
 }
 
 // LauncherOptions ...
@@ -41,21 +45,99 @@ type LauncherOptions struct {
 	ContainerImage    string
 	MLMDServerAddress string
 	MLMDServerPort    string
+
+	// RetryAttempt is the zero-based attempt number of the task, e.g. Argo's
+	// {{retries}}. Each attempt is recorded as its own execution, superseding
+	// the executions of earlier attempts.
+	RetryAttempt int
+
+	// TerminationGracePeriod is how long the user command may take to exit
+	// after the launcher forwarded SIGTERM or SIGINT to it, before it is
+	// killed. Defaults to DefaultTerminationGracePeriod.
+	TerminationGracePeriod time.Duration
+
+	// KeepPartialOutputs uploads and records whatever output artifacts the
+	// user command wrote when it is terminated, e.g. checkpoints.
+	KeepPartialOutputs bool
+
+	// TransferConcurrency is the maximum number of objects, or parts of
+	// objects, copied between the pipeline root and local storage at once.
+	// Defaults to DefaultTransferConcurrency.
+	TransferConcurrency int
+	// TransferPartSize is the size in bytes of the parts large objects are
+	// uploaded and downloaded in. Defaults to DefaultTransferPartSize.
+	TransferPartSize int
+
+	// Options for secure connections to the metadata store. See
+	// metadata.ClientOptions for details.
+	MLMDUseTLS             bool
+	MLMDCACertFile         string
+	MLMDClientCertFile     string
+	MLMDClientKeyFile      string
+	MLMDServerNameOverride string
+	MLMDTokenFile          string
+
+	// MLMDRPCTimeout and MLMDMaxAttempts override the deadline and number of
+	// attempts of each metadata store RPC. Zero values use
+	// metadata.DefaultRetryOptions.
+	MLMDRPCTimeout  time.Duration
+	MLMDMaxAttempts int
+
+	// CachingOptions are the task's caching options from the pipeline spec.
+	// Caching is enabled when nil.
+	CachingOptions *pipeline_spec.PipelineTaskSpec_CachingOptions
+
+	// LocalDir is the absolute path of the directory holding the local copies
+	// of artifacts. Input artifacts are downloaded under kfp_launcher_inputs,
+	// and the user command writes output artifacts and its executor output
+	// under kfp_launcher_outputs. Defaults to DefaultLocalDir.
+	LocalDir string
+
+	// OutputURITemplate controls where output artifacts are stored, relative
+	// to PipelineRoot. It may reference {{pipeline}}, {{run}}, {{task}},
+	// {{task_name}} and {{output_name}}, and must include {{output_name}} so
+	// that every output gets its own key. Defaults to
+	// DefaultOutputURITemplate.
+	OutputURITemplate string
+
+	// S3 options, only used when PipelineRoot is an s3:// URI. These allow
+	// pointing the launcher at S3-compatible stores such as MinIO. When the
+	// access key is empty, the default AWS credential chain is used.
+	S3Endpoint        string
+	S3Region          string
+	S3ForcePathStyle  bool
+	S3DisableSSL      bool
+	S3AccessKeyID     string
+	S3SecretAccessKey string
 }
 
+// DefaultLocalDir is the LocalDir used when none is set.
+const DefaultLocalDir = "/tmp"
+
+// DefaultOutputURITemplate is the OutputURITemplate used when none is set.
+const DefaultOutputURITemplate = "{{pipeline}}/{{run}}/{{task}}/{{output_name}}"
+
 type bucketConfig struct {
 	scheme     string
 	bucketName string
 	prefix     string
 }
 
-func (b *bucketConfig) bucketURL() string {
-	u := b.scheme + b.bucketName
-
-	if len(b.prefix) > 0 {
-		u = fmt.Sprintf("%s?prefix=%s", u, b.prefix)
+func (b *bucketConfig) storageConfig(options *LauncherOptions) *storage.Config {
+	return &storage.Config{
+		Scheme:     strings.TrimSuffix(b.scheme, "://"),
+		BucketName: b.bucketName,
+		Prefix:     b.prefix,
+		PartSize:   options.TransferPartSize,
+		S3: storage.S3Options{
+			Endpoint:        options.S3Endpoint,
+			Region:          options.S3Region,
+			ForcePathStyle:  options.S3ForcePathStyle,
+			DisableSSL:      options.S3DisableSSL,
+			AccessKeyID:     options.S3AccessKeyID,
+			SecretAccessKey: options.S3SecretAccessKey,
+		},
 	}
-	return u
 }
 
 func (b *bucketConfig) keyFromURI(uri string) (string, error) {
@@ -78,13 +160,29 @@ func (b *bucketConfig) uriFromKey(blobKey string) string {
 var bucketPattern = regexp.MustCompile(`^([a-z][a-z0-9]+:///?)([^/]+)(/[^ ?]*)?$`)
 
 func parseBucketConfig(path string) (*bucketConfig, error) {
+	// Local directories have no bucket name, so the whole directory is used as
+	// the bucket instead.
+	if strings.HasPrefix(path, "file://") {
+		dir := strings.TrimPrefix(path, "file://")
+		if !strings.HasPrefix(dir, "/") || strings.ContainsAny(dir, " ?") {
+			return nil, fmt.Errorf("Unrecognized pipeline root format: %q", path)
+		}
+		dir = strings.TrimRight(dir, "/")
+		if len(dir) == 0 {
+			dir = "/"
+		}
+		return &bucketConfig{
+			scheme:     "file://",
+			bucketName: dir,
+		}, nil
+	}
+
 	ms := bucketPattern.FindStringSubmatch(path)
 	if ms == nil || len(ms) != 4 {
 		return nil, fmt.Errorf("Unrecognized pipeline root format: %q", path)
 	}
 
-	// TODO: Verify/add support for s3:// and file:///.
-	if ms[1] != "gs://" {
+	if !storage.IsRegistered(strings.TrimSuffix(ms[1], "://")) {
 		return nil, fmt.Errorf("Unsupported Cloud bucket: %q", path)
 	}
 
@@ -125,9 +223,96 @@ func (o *LauncherOptions) validate() error {
 	if empty(o.MLMDServerPort) {
 		return err("MLMDServerPort")
 	}
+	if o.RetryAttempt < 0 {
+		return fmt.Errorf("RetryAttempt must not be negative, got %d", o.RetryAttempt)
+	}
+	if o.TerminationGracePeriod < 0 {
+		return fmt.Errorf("TerminationGracePeriod must not be negative, got %v", o.TerminationGracePeriod)
+	}
+	if o.TerminationGracePeriod == 0 {
+		o.TerminationGracePeriod = DefaultTerminationGracePeriod
+	}
+	if o.TransferConcurrency < 0 {
+		return fmt.Errorf("TransferConcurrency must not be negative, got %d", o.TransferConcurrency)
+	}
+	if o.TransferConcurrency == 0 {
+		o.TransferConcurrency = DefaultTransferConcurrency
+	}
+	if o.TransferPartSize < 0 {
+		return fmt.Errorf("TransferPartSize must not be negative, got %d", o.TransferPartSize)
+	}
+	if o.TransferPartSize == 0 {
+		o.TransferPartSize = DefaultTransferPartSize
+	}
+	if empty(o.LocalDir) {
+		o.LocalDir = DefaultLocalDir
+	}
+	if !filepath.IsAbs(o.LocalDir) {
+		return fmt.Errorf("LocalDir must be an absolute path, got %q", o.LocalDir)
+	}
+	if empty(o.OutputURITemplate) {
+		o.OutputURITemplate = DefaultOutputURITemplate
+	}
+	if !strings.Contains(o.OutputURITemplate, "{{output_name}}") {
+		return fmt.Errorf("OutputURITemplate %q must contain {{output_name}}", o.OutputURITemplate)
+	}
+	if _, err := o.outputKey("output"); err != nil {
+		return err
+	}
+	if empty(o.S3AccessKeyID) != empty(o.S3SecretAccessKey) {
+		return fmt.Errorf("S3AccessKeyID and S3SecretAccessKey must be specified together")
+	}
+	return nil
+}
+
+var templateVariablePattern = regexp.MustCompile(`{{[^{}]*}}`)
+
+// outputKey expands OutputURITemplate into the blob key for the named output.
+func (o *LauncherOptions) outputKey(outputName string) (string, error) {
+	vars := map[string]string{
+		"{{pipeline}}":    o.PipelineName,
+		"{{run}}":         o.PipelineRunID,
+		"{{task}}":        o.PipelineTaskID,
+		"{{task_name}}":   o.TaskName,
+		"{{output_name}}": outputName,
+	}
+
+	var unknown []string
+	key := templateVariablePattern.ReplaceAllStringFunc(o.OutputURITemplate, func(v string) string {
+		r, ok := vars[v]
+		if !ok {
+			unknown = append(unknown, v)
+		}
+		return r
+	})
+	if len(unknown) > 0 {
+		return "", fmt.Errorf("Unknown variables %v in OutputURITemplate %q", unknown, o.OutputURITemplate)
+	}
+
+	key = strings.Trim(path.Clean("/"+key), "/")
+	if len(key) == 0 {
+		return "", fmt.Errorf("OutputURITemplate %q expands to an empty key", o.OutputURITemplate)
+	}
+	return key, nil
+}
+
+// validateOutputName makes sure an output name can safely be used as a single
+// path component, both locally and in blob keys.
+func validateOutputName(name string) error {
+	if len(name) == 0 || name == "." || name == ".." || strings.ContainsAny(name, "/\\") {
+		return fmt.Errorf("Invalid output name %q", name)
+	}
 	return nil
 }
 
+func openStore(ctx context.Context, bc *bucketConfig, options *LauncherOptions) (storage.ArtifactStore, error) {
+	store, err := storage.Open(ctx, bc.storageConfig(options))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open bucket %q: %v", bc.bucketName, err)
+	}
+	return store, nil
+}
+
 // NewLauncher ...
 func NewLauncher(runtimeInfo string, options *LauncherOptions) (*Launcher, error) {
 	if err := options.validate(); err != nil {
@@ -147,7 +332,23 @@ func NewLauncher(runtimeInfo string, options *LauncherOptions) (*Launcher, error
 	// Placeholder replacements.
 	pr := make(map[string]string)
 
-	metadata, err := metadata.NewClient(options.MLMDServerAddress, options.MLMDServerPort)
+	retry := metadata.DefaultRetryOptions
+	if options.MLMDRPCTimeout > 0 {
+		retry.RPCTimeout = options.MLMDRPCTimeout
+	}
+	if options.MLMDMaxAttempts > 0 {
+		retry.MaxAttempts = options.MLMDMaxAttempts
+	}
+
+	metadata, err := metadata.NewClient(options.MLMDServerAddress, options.MLMDServerPort, &metadata.ClientOptions{
+		UseTLS:             options.MLMDUseTLS,
+		CACertFile:         options.MLMDCACertFile,
+		ClientCertFile:     options.MLMDClientCertFile,
+		ClientKeyFile:      options.MLMDClientKeyFile,
+		ServerNameOverride: options.MLMDServerNameOverride,
+		TokenFile:          options.MLMDTokenFile,
+		Retry:              &retry,
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -162,74 +363,150 @@ func NewLauncher(runtimeInfo string, options *LauncherOptions) (*Launcher, error
 }
 
 func (l *Launcher) prepareInputs(ctx context.Context) error {
-	bucket, err := blob.OpenBucket(context.Background(), l.bucketConfig.bucketURL())
-	if err != nil {
-		return fmt.Errorf("Failed to open bucket %q: %v", l.bucketConfig.bucketName, err)
-	}
-	defer bucket.Close()
-
 	// Read input artifact metadata.
 	for k, v := range l.runtimeInfo.InputArtifacts {
-		if len(v.FileInputPath) == 0 {
-			return fmt.Errorf("Missing input artifact metadata file for input: %q", k)
-		}
+		if v.Artifact != nil {
+			// Provided by the launcher, e.g. the item of an artifact iterator.
+		} else if len(v.FileInputPath) == 0 && v.DefaultValue != nil {
+			// Register the default like an importer does, so that it is
+			// recorded as an input of the execution.
+			a, err := l.metadata.ImportArtifact(ctx, v.DefaultValue.ArtifactSchema, &pb.Artifact{Uri: proto.String(v.DefaultValue.URI)}, false)
+			if err != nil {
+				return fmt.Errorf("Failed to register the default artifact of input %q: %v", k, err)
+			}
+			v.Artifact = a
+		} else if len(v.FileInputPath) == 0 {
+			if !v.Optional {
+				return fmt.Errorf("Missing input artifact metadata file for input: %q", k)
+			}
+			// Absent optional inputs are dropped, so that they are neither
+			// downloaded nor recorded.
+			l.placeholderReplacements[fmt.Sprintf(`{{$.inputs.artifacts['%s'].uri}}`, k)] = ""
+			l.placeholderReplacements[fmt.Sprintf(`{{$.inputs.artifacts['%s'].path}}`, k)] = ""
+			delete(l.runtimeInfo.InputArtifacts, k)
+			continue
+		} else {
+			b, err := ioutil.ReadFile(v.FileInputPath)
+			if err != nil {
+				return fmt.Errorf("Failed to read input artifact metadata file for %q: %v", k, err)
+			}
 
-		b, err := ioutil.ReadFile(v.FileInputPath)
-		if err != nil {
-			return fmt.Errorf("Failed to read input artifact metadata file for %q: %v", k, err)
-		}
+			a := &pb.Artifact{}
+			if err := protojson.Unmarshal(b, a); err != nil {
+				return fmt.Errorf("Failed to unmarshall input artifact metadata for %q: %v", k, err)
+			}
 
-		a := &pb.Artifact{}
-		if err := protojson.Unmarshal(b, a); err != nil {
-			return fmt.Errorf("Failed to unmarshall input artifact metadata for %q: %v", k, err)
+			v.Artifact = a
 		}
 
-		v.Artifact = a
-
 		// Prepare input uri placeholder.
 		key := fmt.Sprintf(`{{$.inputs.artifacts['%s'].uri}}`, k)
 		l.placeholderReplacements[key] = v.Artifact.GetUri()
 
 		// Prepare input path placeholder.
-		v.LocalArtifactFilePath = path.Join("/tmp/kfp_launcher_inputs", k, "data")
+		v.LocalArtifactFilePath = path.Join(l.localInputDir(k), "data")
 		key = fmt.Sprintf(`{{$.inputs.artifacts['%s'].path}}`, k)
 		l.placeholderReplacements[key] = v.LocalArtifactFilePath
+	}
 
-		// Copy artifact to local storage.
-		// TODO: Selectively copy artifacts for which .path was actually specified
-		// on the command line.
-
-		blobKey, err := l.bucketConfig.keyFromURI(v.Artifact.GetUri())
-		if err != nil {
-			return err
-		}
-		r, err := bucket.NewReader(ctx, blobKey, nil)
-		if err != nil {
-			return err
+	// Prepare input parameter placeholders.
+	for k, v := range l.runtimeInfo.InputParameters {
+		if v.ParameterValue == nil {
+			v.ParameterValue = v.DefaultValue
 		}
-		defer r.Close()
-
-		if err := os.MkdirAll(path.Dir(v.LocalArtifactFilePath), 0644); err != nil {
-			return err
+		if v.ParameterValue == nil && !v.Optional {
+			return fmt.Errorf("Missing value for input parameter: %q", k)
 		}
-		w, err := os.Create(v.LocalArtifactFilePath)
-		if err != nil {
-			return err
+		if v.ParameterValue != nil {
+			text, err := validatedParameterText(v.ParameterType, *v.ParameterValue)
+			if err != nil {
+				return fmt.Errorf("Invalid value for input parameter %q: %v", k, err)
+			}
+			v.ParameterValue = &text
 		}
-		if _, err := io.Copy(w, r); err != nil {
-			return err
+		key := fmt.Sprintf(`{{$.inputs.parameters['%s']}}`, k)
+		l.placeholderReplacements[key] = v.value()
+		if v.ParameterValue == nil {
+			delete(l.runtimeInfo.InputParameters, k)
 		}
 	}
 
-	// Prepare input parameter placeholders.
-	for k, v := range l.runtimeInfo.InputParameters {
-		key := fmt.Sprintf(`{{$.inputs.parameters['%s']}}`, k)
-		l.placeholderReplacements[key] = v.ParameterValue
+	return nil
+}
+
+// inputPresent reports whether the named input has a value. It must be called
+// after prepareInputs, which drops absent optional inputs.
+func (l *Launcher) inputPresent(name string) bool {
+	if _, ok := l.runtimeInfo.InputParameters[name]; ok {
+		return true
+	}
+	_, ok := l.runtimeInfo.InputArtifacts[name]
+	return ok
+}
+
+// downloadInputs copies input artifacts to local storage. It must be called
+// after prepareInputs.
+func (l *Launcher) downloadInputs(ctx context.Context) error {
+	store, err := openStore(ctx, l.bucketConfig, l.options)
+	if err != nil {
+		return err
 	}
+	defer store.Close()
 
+	// TODO: Selectively copy artifacts for which .path was actually specified
+	// on the command line.
+	var artifacts []artifactTransfer
+	for k, v := range l.runtimeInfo.InputArtifacts {
+		blobKey, err := l.bucketConfig.keyFromURI(v.Artifact.GetUri())
+		if err != nil {
+			return fmt.Errorf("Failed to download input artifact %q: %v", k, err)
+		}
+		artifacts = append(artifacts, artifactTransfer{key: blobKey, localPath: v.LocalArtifactFilePath})
+	}
+	if err := downloadArtifacts(ctx, store, artifacts, l.transferOptions()); err != nil {
+		return fmt.Errorf("Failed to download input artifacts: %w", err)
+	}
 	return nil
 }
 
+// localDir is the LocalDir of the options, falling back to DefaultLocalDir so
+// that local files never resolve relative to the working directory.
+func (l *Launcher) localDir() string {
+	if len(l.options.LocalDir) == 0 {
+		return DefaultLocalDir
+	}
+	return l.options.LocalDir
+}
+
+// localInputDir is where input artifact name is downloaded to.
+func (l *Launcher) localInputDir(name string) string {
+	return path.Join(l.localDir(), "kfp_launcher_inputs", name)
+}
+
+// localOutputDir is where the user command writes its outputs.
+func (l *Launcher) localOutputDir() string {
+	return path.Join(l.localDir(), "kfp_launcher_outputs")
+}
+
+// iterationsDir holds the output files of each iteration until they are
+// collected into the task's output files.
+func (l *Launcher) iterationsDir() string {
+	return path.Join(l.localDir(), "kfp_launcher_iterations")
+}
+
+// executorOutputPath is where components using the executor protocol write
+// their ExecutorOutput.
+func (l *Launcher) executorOutputPath() string {
+	return path.Join(l.localOutputDir(), "executor_output.json")
+}
+
+func (l *Launcher) transferOptions() transferOptions {
+	return transferOptions{
+		concurrency: l.options.TransferConcurrency,
+		partSize:    int64(l.options.TransferPartSize),
+	}
+}
+
 func (l *Launcher) prepareOutputs(ctx context.Context) error {
 	for k, v := range l.runtimeInfo.OutputParameters {
 		key := fmt.Sprintf(`{{$.outputs.parameters['%s'].output_file}}`, k)
@@ -237,14 +514,31 @@ func (l *Launcher) prepareOutputs(ctx context.Context) error {
 	}
 
 	for k, v := range l.runtimeInfo.OutputArtifacts {
-		// TODO: sanitize k
-		v.LocalArtifactFilePath = path.Join("/tmp/kfp_launcher_outputs", k, "data")
+		if err := validateOutputName(k); err != nil {
+			return err
+		}
+		v.LocalArtifactFilePath = path.Join(l.localOutputDir(), k, "data")
+		v.LocalMetadataFilePath = path.Join(l.localOutputDir(), k, artifactMetadataFileName)
 
-		if err := os.MkdirAll(path.Dir(v.LocalArtifactFilePath), 0644); err != nil {
+		if err := os.MkdirAll(path.Dir(v.LocalArtifactFilePath), 0755); err != nil {
+			return err
+		}
+		// Make sure stale data and metadata, e.g. of an earlier iteration, are
+		// not attributed to this run's artifact.
+		if err := os.RemoveAll(v.LocalArtifactFilePath); err != nil {
+			return err
+		}
+		if err := os.Remove(v.LocalMetadataFilePath); err != nil && !os.IsNotExist(err) {
 			return err
 		}
 
-		blobKey := path.Join(l.options.PipelineName, l.options.PipelineRunID, l.options.PipelineTaskID, "data")
+		blobKey, err := l.options.outputKey(k)
+		if err != nil {
+			return err
+		}
+		if l.iteration != nil {
+			blobKey = path.Join(blobKey, fmt.Sprintf("iteration-%d", l.iteration.index))
+		}
 		v.URIOutputPath = l.bucketConfig.uriFromKey(blobKey)
 
 		key := fmt.Sprintf(`{{$.outputs.artifacts['%s'].path}}`, k)
@@ -252,13 +546,55 @@ func (l *Launcher) prepareOutputs(ctx context.Context) error {
 
 		key = fmt.Sprintf(`{{$.outputs.artifacts['%s'].uri}}`, k)
 		l.placeholderReplacements[key] = v.URIOutputPath
+
+		key = fmt.Sprintf(`{{$.outputs.artifacts['%s'].metadata_file}}`, k)
+		l.placeholderReplacements[key] = v.LocalMetadataFilePath
 	}
 
 	return nil
 }
 
+func (l *Launcher) prepareExecutorInput() error {
+	ei, err := l.executorInput()
+	if err != nil {
+		return err
+	}
+	b, err := protojson.Marshal(ei)
+	if err != nil {
+		return err
+	}
+	l.placeholderReplacements[executorInputPlaceholder] = string(b)
+
+	// Make sure a stale executor output is not mistaken for this run's.
+	if err := os.MkdirAll(l.localOutputDir(), 0755); err != nil {
+		return err
+	}
+	if err := os.Remove(l.executorOutputPath()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
 // RunComponent ..
+//
+// If the launcher is asked to terminate, the returned error satisfies
+// IsCanceled. If the user command fails, its exit status is available through
+// UserCommandExitCode.
 func (l *Launcher) RunComponent(ctx context.Context, cmd string, args ...string) error {
+	ctx, stop := notifyTermination(ctx)
+	defer stop()
+
+	err := l.runComponent(ctx, cmd, args)
+	if err != nil && ctx.Err() != nil && !IsCanceled(err) {
+		err = &canceledError{sig: terminationSignal(ctx), err: err}
+	}
+	return err
+}
+
+func (l *Launcher) runComponent(ctx context.Context, cmd string, args []string) error {
+	if l.runtimeInfo.ArtifactIterator != nil || l.runtimeInfo.ParameterIterator != nil {
+		return l.runIterations(ctx, cmd, args)
+	}
 
 	if err := l.prepareInputs(ctx); err != nil {
 		return err
@@ -268,47 +604,63 @@ func (l *Launcher) RunComponent(ctx context.Context, cmd string, args ...string)
 		return err
 	}
 
-	// Update command.
-	for i, v := range args {
-		if _, ok := l.placeholderReplacements[v]; ok {
-			args[i] = l.placeholderReplacements[v]
-		}
+	if err := l.prepareExecutorInput(); err != nil {
+		return err
 	}
 
-	// Record Execution in MLMD.
-	pipeline, err := l.metadata.GetPipeline(ctx, l.options.PipelineName, l.options.PipelineRunID)
+	fingerprint, err := l.cacheFingerprint(cmd, args)
 	if err != nil {
 		return err
 	}
 
+	// Update command.
+	args, err = expandConditionals(args, l.inputPresent)
+	if err != nil {
+		return err
+	}
+	cmd, args, err = resolveCommand(cmd, args, l.placeholderReplacements)
+	if err != nil {
+		return err
+	}
+
+	// Record Execution in MLMD.
+	var pipeline *metadata.Pipeline
+	if l.iteration != nil {
+		pipeline = l.iteration.pipeline
+	} else {
+		pipeline, err = l.metadata.GetPipeline(ctx, l.options.PipelineName, l.options.PipelineRunID)
+		if err != nil {
+			return err
+		}
+	}
+
 	ecfg := &metadata.ExecutionConfig{
 		InputParameters: &metadata.Parameters{
 			IntParameters:    make(map[string]int64),
 			StringParameters: make(map[string]string),
 			DoubleParameters: make(map[string]float64),
 		},
+		RetryAttempt: l.options.RetryAttempt,
 	}
-	for _, ia := range l.runtimeInfo.InputArtifacts {
-		ecfg.InputArtifacts = append(ecfg.InputArtifacts, &metadata.InputArtifact{Artifact: ia.Artifact})
+	if l.iteration != nil {
+		ecfg.Iteration = &l.iteration.index
+	}
+	for k, ia := range l.runtimeInfo.InputArtifacts {
+		ecfg.InputArtifacts = append(ecfg.InputArtifacts, &metadata.InputArtifact{Name: k, Artifact: ia.Artifact})
+	}
+
+	var cached *metadata.CachedExecution
+	if l.cachingEnabled() {
+		ecfg.CacheFingerprint = fingerprint
+		cached = l.lookupCache(ctx, fingerprint)
 	}
 
 	for n, ip := range l.runtimeInfo.InputParameters {
-		switch ip.ParameterType {
-		case "STRING":
-			ecfg.InputParameters.StringParameters[n] = ip.ParameterValue
-		case "INT":
-			i, err := strconv.ParseInt(ip.ParameterValue, 10, 0)
-			if err != nil {
-				return err
-			}
-			ecfg.InputParameters.IntParameters[n] = i
-		case "DOUBLE":
-			f, err := strconv.ParseFloat(ip.ParameterValue, 0)
-			if err != nil {
-				return err
-			}
-			ecfg.InputParameters.DoubleParameters[n] = f
+		v, err := parseParameter(ip.ParameterType, ip.value())
+		if err != nil {
+			return fmt.Errorf("Failed to parse input parameter %q: %v", n, err)
 		}
+		addParameter(ecfg.InputParameters, n, v)
 	}
 
 	execution, err := l.metadata.CreateExecution(ctx, pipeline, l.options.TaskName, l.options.PipelineTaskID, l.options.ContainerImage, ecfg)
@@ -316,6 +668,125 @@ func (l *Launcher) RunComponent(ctx context.Context, cmd string, args ...string)
 		return err
 	}
 
+	// From here on, any failure must be recorded on the execution so that it
+	// is not left RUNNING.
+	if cached != nil {
+		err = l.reuseCachedExecution(ctx, execution, cached)
+	} else {
+		err = l.runExecution(ctx, execution, cmd, args)
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			l.cancelExecution(execution, err)
+		} else if ferr := l.metadata.FailExecution(ctx, execution, executionFailure(err)); ferr != nil {
+			glog.Errorf("Failed to record execution failure in MLMD: %v", ferr)
+		}
+		return err
+	}
+	return nil
+}
+
+// cancellationTimeout bounds the time spent recording a canceled execution.
+const cancellationTimeout = 30 * time.Second
+
+// cancelExecution records execution as CANCELED, flushing partial outputs if
+// the user opted to keep them. The launcher's context is done by then, so a
+// fresh one is used.
+func (l *Launcher) cancelExecution(execution *metadata.Execution, cause error) {
+	ctx, cancel := context.WithTimeout(context.Background(), cancellationTimeout)
+	defer cancel()
+
+	var partialOutputs []*metadata.OutputArtifact
+	if l.options.KeepPartialOutputs {
+		var err error
+		partialOutputs, err = l.flushPartialOutputs(ctx)
+		if err != nil {
+			glog.Errorf("Failed to flush partial outputs: %v", err)
+		}
+	}
+	if err := l.metadata.CancelExecution(ctx, execution, executionFailure(cause), partialOutputs); err != nil {
+		glog.Errorf("Failed to record execution cancellation in MLMD: %v", err)
+	}
+}
+
+// flushPartialOutputs uploads the output artifacts the user command wrote
+// before it was terminated, and records them in MLMD with a "partial" custom
+// property. Outputs that were not written are skipped. It returns the
+// artifacts recorded so far, even on error.
+func (l *Launcher) flushPartialOutputs(ctx context.Context) ([]*metadata.OutputArtifact, error) {
+	store, err := openStore(ctx, l.bucketConfig, l.options)
+	if err != nil {
+		return nil, err
+	}
+	defer store.Close()
+
+	var (
+		written   []string
+		artifacts []artifactTransfer
+	)
+	for k, v := range l.runtimeInfo.OutputArtifacts {
+		if _, err := os.Stat(v.LocalArtifactFilePath); os.IsNotExist(err) {
+			continue
+		}
+		blobKey, err := l.bucketConfig.keyFromURI(v.URIOutputPath)
+		if err != nil {
+			return nil, err
+		}
+		written = append(written, k)
+		artifacts = append(artifacts, artifactTransfer{key: blobKey, localPath: v.LocalArtifactFilePath})
+	}
+	if err := uploadArtifacts(ctx, store, artifacts, l.transferOptions()); err != nil {
+		return nil, err
+	}
+
+	var flushed []*metadata.OutputArtifact
+	for _, k := range written {
+		v := l.runtimeInfo.OutputArtifacts[k]
+		properties, err := readArtifactMetadata(v.LocalMetadataFilePath)
+		if err != nil {
+			return flushed, err
+		}
+		artifact := &pb.Artifact{
+			Uri:              proto.String(v.URIOutputPath),
+			CustomProperties: make(map[string]*pb.Value),
+		}
+		for n, p := range properties {
+			artifact.CustomProperties[n] = p
+		}
+		artifact.CustomProperties["partial"] = &pb.Value{Value: &pb.Value_StringValue{StringValue: "true"}}
+		artifact, err = l.metadata.RecordArtifact(ctx, v.ArtifactSchema, artifact)
+		if err != nil {
+			return flushed, err
+		}
+		flushed = append(flushed, &metadata.OutputArtifact{Name: k, Artifact: artifact, Schema: v.ArtifactSchema})
+	}
+	return flushed, nil
+}
+
+// executionFailure summarizes err for recording in MLMD.
+func executionFailure(err error) *metadata.ExecutionFailure {
+	f := &metadata.ExecutionFailure{
+		ExitCode: -1,
+		Message:  err.Error(),
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		f.ExitCode = exitErr.ExitCode()
+		if ws, ok := exitErr.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+			f.Signal = ws.Signal().String()
+		}
+	}
+	return f
+}
+
+// runExecution downloads inputs, runs the user command, uploads outputs and
+// publishes the execution.
+func (l *Launcher) runExecution(ctx context.Context, execution *metadata.Execution, cmd string, args []string) error {
+	if err := l.downloadInputs(ctx); err != nil {
+		return err
+	}
+
 	executor := exec.Command(cmd, args...)
 
 	fmt.Println("Running command: ")
@@ -324,65 +795,126 @@ func (l *Launcher) RunComponent(ctx context.Context, cmd string, args ...string)
 	executor.Stdout = os.Stdout
 	executor.Stderr = os.Stderr
 	defer glog.Flush()
-	if err := executor.Run(); err != nil {
-		return err
+	if err := runCommand(ctx, executor, l.options.TerminationGracePeriod); err != nil {
+		return fmt.Errorf("User command failed: %w", err)
 	}
 
-	bucket, err := blob.OpenBucket(context.Background(), l.bucketConfig.bucketURL())
+	executorOutput, err := readExecutorOutput(l.executorOutputPath())
 	if err != nil {
-		return fmt.Errorf("Failed to open bucket %q: %v", l.bucketConfig.bucketName, err)
+		return err
 	}
-	defer bucket.Close()
 
-	// Register artifacts with MLMD.
-	outputArtifacts := make([]*metadata.OutputArtifact, 0, len(l.runtimeInfo.OutputArtifacts))
-	for _, v := range l.runtimeInfo.OutputArtifacts {
-		var err error
-		artifact := &pb.Artifact{
-			Uri: &v.URIOutputPath,
-		}
+	store, err := openStore(ctx, l.bucketConfig, l.options)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
 
-		artifact, err = l.metadata.RecordArtifact(ctx, v.ArtifactSchema, artifact)
+	// Components using the executor protocol may report a different URI for
+	// an output, or write nothing to the local path of an output and write
+	// the artifact to its URI instead. In both cases the artifact is already
+	// stored, and a reported URI must be under the pipeline root. Every other
+	// output is uploaded, in parallel, before any is recorded.
+	reportedArtifacts := make(map[string]*pipeline_spec.RuntimeArtifact)
+	var uploads []artifactTransfer
+	for k, v := range l.runtimeInfo.OutputArtifacts {
+		reported, err := outputArtifactFromExecutorOutput(executorOutput, k)
 		if err != nil {
 			return err
 		}
-		outputArtifacts = append(outputArtifacts, &metadata.OutputArtifact{Artifact: artifact, Schema: v.ArtifactSchema})
-
-		if err := os.MkdirAll(path.Dir(v.FileOutputPath), 0644); err != nil {
-			return err
+		reportedArtifacts[k] = reported
+		if len(reported.GetUri()) > 0 && reported.GetUri() != v.URIOutputPath {
+			blobKey, err := l.bucketConfig.keyFromURI(reported.GetUri())
+			if err != nil {
+				return fmt.Errorf("Invalid URI reported for output artifact %q: %v", k, err)
+			}
+			stored, err := artifactExists(ctx, store, blobKey)
+			if err != nil {
+				return err
+			}
+			if !stored {
+				return fmt.Errorf("Output artifact %q was reported at %q, which does not exist", k, reported.GetUri())
+			}
+			v.URIOutputPath = reported.GetUri()
+			continue
 		}
 
-		b, err := protojson.Marshal(artifact)
+		blobKey, err := l.bucketConfig.keyFromURI(v.URIOutputPath)
 		if err != nil {
 			return err
 		}
+		if _, err := os.Stat(v.LocalArtifactFilePath); os.IsNotExist(err) {
+			stored, err := artifactExists(ctx, store, blobKey)
+			if err != nil {
+				return err
+			}
+			if stored {
+				continue
+			}
+			return fmt.Errorf("Output artifact %q was written neither to %q nor to %q", k, v.LocalArtifactFilePath, v.URIOutputPath)
+		}
+		uploads = append(uploads, artifactTransfer{key: blobKey, localPath: v.LocalArtifactFilePath})
+	}
+	if err := uploadArtifacts(ctx, store, uploads, l.transferOptions()); err != nil {
+		return fmt.Errorf("Failed to upload output artifacts: %w", err)
+	}
 
-		if err := ioutil.WriteFile(v.FileOutputPath, b, 0644); err != nil {
-			return err
+	// Register artifacts with MLMD.
+	outputArtifacts := make([]*metadata.OutputArtifact, 0, len(l.runtimeInfo.OutputArtifacts))
+	for k, v := range l.runtimeInfo.OutputArtifacts {
+		var err error
+		artifact := &pb.Artifact{
+			Uri:              &v.URIOutputPath,
+			CustomProperties: make(map[string]*pb.Value),
 		}
 
-		// copy Artifacts out to remote storage.
-		blobKey, err := l.bucketConfig.keyFromURI(v.URIOutputPath)
+		properties, err := readArtifactMetadata(v.LocalMetadataFilePath)
 		if err != nil {
 			return err
 		}
+		for n, p := range properties {
+			artifact.CustomProperties[n] = p
+		}
+
+		// Custom properties reported through the executor output take
+		// precedence over the metadata file.
+		if reported := reportedArtifacts[k]; reported != nil {
+			for n, p := range reported.GetCustomProperties() {
+				if n == localPathProperty {
+					// Only meaningful inside this task.
+					continue
+				}
+				if mv := toMLMDValue(p); mv != nil {
+					artifact.CustomProperties[n] = mv
+				}
+			}
+			// Properties are validated against ArtifactSchema when recorded.
+			for n, p := range reported.GetProperties() {
+				if mv := toMLMDValue(p); mv != nil {
+					if artifact.Properties == nil {
+						artifact.Properties = make(map[string]*pb.Value)
+					}
+					artifact.Properties[n] = mv
+				}
+			}
+		}
 
-		w, err := bucket.NewWriter(ctx, blobKey, nil)
+		artifact, err = l.metadata.RecordArtifact(ctx, v.ArtifactSchema, artifact)
 		if err != nil {
 			return err
 		}
+		outputArtifacts = append(outputArtifacts, &metadata.OutputArtifact{Name: k, Artifact: artifact, Schema: v.ArtifactSchema})
 
-		r, err := os.Open(v.LocalArtifactFilePath)
-		if err != nil {
+		if err := os.MkdirAll(path.Dir(v.FileOutputPath), 0755); err != nil {
 			return err
 		}
-		defer r.Close()
 
-		if _, err = io.Copy(w, r); err != nil {
+		b, err := protojson.Marshal(artifact)
+		if err != nil {
 			return err
 		}
 
-		if err = w.Close(); err != nil {
+		if err := ioutil.WriteFile(v.FileOutputPath, b, 0644); err != nil {
 			return err
 		}
 	}
@@ -395,27 +927,28 @@ func (l *Launcher) RunComponent(ctx context.Context, cmd string, args ...string)
 	}
 
 	for n, op := range l.runtimeInfo.OutputParameters {
-		b, err := ioutil.ReadFile(op.FileOutputPath)
-		if err != nil {
-			return err
-		}
-		switch op.ParameterType {
-		case "STRING":
-			outputParameters.StringParameters[n] = string(b)
-		case "INT":
-			i, err := strconv.ParseInt(string(b), 10, 0)
-			if err != nil {
+		var b []byte
+		if v, ok := executorOutput.GetParameters()[n]; ok {
+			// Mirror the reported value into the output file, which is what
+			// downstream tasks consume.
+			b = []byte(pipelineValueText(v))
+			if err := os.MkdirAll(path.Dir(op.FileOutputPath), 0755); err != nil {
+				return err
+			}
+			if err := ioutil.WriteFile(op.FileOutputPath, b, 0644); err != nil {
 				return err
 			}
-			outputParameters.IntParameters[n] = i
-		case "DOUBLE":
-			f, err := strconv.ParseFloat(string(b), 0)
+		} else {
+			b, err = ioutil.ReadFile(op.FileOutputPath)
 			if err != nil {
 				return err
 			}
-			outputParameters.DoubleParameters[n] = f
 		}
-
+		v, err := parseParameter(op.ParameterType, string(b))
+		if err != nil {
+			return fmt.Errorf("Failed to parse output parameter %q: %v", n, err)
+		}
+		addParameter(outputParameters, n, v)
 	}
 
 	return l.metadata.PublishExecution(ctx, execution, outputParameters, outputArtifacts)
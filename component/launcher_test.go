@@ -3,63 +3,231 @@ package component
 import (
 	"context"
 	"fmt"
-	"io"
+	"io/ioutil"
+	"net/http/httptest"
 	"os"
+	"os/exec"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 
-	"gocloud.dev/blob"
-	_ "gocloud.dev/blob/gcsblob"
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+	"github.com/neuromage/kfp-launcher/metadata/embedded"
+	pb "github.com/neuromage/kfp-launcher/third_party/ml_metadata"
+	"github.com/neuromage/kfp-launcher/third_party/pipeline_spec"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
 )
 
 func TestOpenBucket(t *testing.T) {
-	// blob.OpenBucket creates a *blob.Bucket from a URL.
-	// This URL will open the bucket "my-bucket" using default credentials.
-	ctx := context.Background()
-	bucket, err := blob.OpenBucket(ctx, "gs://ml-pipeline-artifacts?prefix=custom_dir/")
+	root, err := ioutil.TempDir("", "kfp-launcher-root")
 	if err != nil {
 		t.Fatal(err)
 	}
-	defer bucket.Close()
-	// Open the key "foo.txt" for writing with the default options.
-	w, err := bucket.NewWriter(ctx, "foo.txt", nil)
+	defer os.RemoveAll(root)
+
+	bc, err := parseBucketConfig("file://" + root)
 	if err != nil {
 		t.Fatal(err)
 	}
-	_, writeErr := fmt.Fprintln(w, "Hello, World!")
-	// Always check the return value of Close when writing.
-	closeErr := w.Close()
-	if writeErr != nil {
-		t.Fatal(writeErr)
+
+	ctx := context.Background()
+	store, err := openStore(ctx, bc, &LauncherOptions{})
+	if err != nil {
+		t.Fatal(err)
 	}
-	if closeErr != nil {
-		t.Fatal(closeErr)
+	defer store.Close()
+	if err := store.Upload(ctx, "custom_dir/foo.txt", strings.NewReader("Hello, World!\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Blob keys map directly onto the local directory tree.
+	got, err := ioutil.ReadFile(filepath.Join(root, "custom_dir", "foo.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "Hello, World!\n" {
+		t.Errorf("Got file contents %q, want %q", got, "Hello, World!\n")
 	}
 }
 
 func TestCopyToLocal(t *testing.T) {
-	// blob.OpenBucket creates a *blob.Bucket from a URL.
-	// This URL will open the bucket "my-bucket" using default credentials.
+	root, err := ioutil.TempDir("", "kfp-launcher-root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	bc, err := parseBucketConfig("file://" + root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
 	ctx := context.Background()
-	bucket, err := blob.OpenBucket(ctx, "gs://ml-pipeline-artifacts?prefix=custom_dir/")
+	store, err := openStore(ctx, bc, &LauncherOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if err := store.Upload(ctx, "foo.txt", strings.NewReader("Hello, World!")); err != nil {
+		t.Fatal(err)
+	}
+
+	localPath := filepath.Join(root, "dir1", "dir2", "foo.txt")
+	if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	w, err := os.Create(localPath)
 	if err != nil {
 		t.Fatal(err)
 	}
-	defer bucket.Close()
-	// Open the key "foo.txt" for writing with the default options.
-	r, err := bucket.NewReader(ctx, "foo.txt", nil)
+	defer w.Close()
+	if err := store.Download(ctx, "foo.txt", w); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPrepareInputs_OptionalInputs(t *testing.T) {
+	l := &Launcher{
+		options:                 &LauncherOptions{},
+		placeholderReplacements: make(map[string]string),
+		runtimeInfo: &runtimeInfo{
+			InputParameters: map[string]*inputParameter{
+				"lr":     {ParameterType: "DOUBLE", ParameterValue: proto.String("0.5"), DefaultValue: proto.String("0.1")},
+				"epochs": {ParameterType: "INT", Optional: true, DefaultValue: proto.String("10")},
+				"seed":   {ParameterType: "INT", Optional: true},
+			},
+			InputArtifacts: map[string]*inputArtifact{
+				"vocab": {Optional: true},
+			},
+		},
+	}
+	if err := l.prepareInputs(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	wantReplacements := map[string]string{
+		`{{$.inputs.parameters['lr']}}`:        "0.5",
+		`{{$.inputs.parameters['epochs']}}`:    "10",
+		`{{$.inputs.parameters['seed']}}`:      "",
+		`{{$.inputs.artifacts['vocab'].uri}}`:  "",
+		`{{$.inputs.artifacts['vocab'].path}}`: "",
+	}
+	if !reflect.DeepEqual(l.placeholderReplacements, wantReplacements) {
+		t.Errorf("Got placeholder replacements %v, want %v", l.placeholderReplacements, wantReplacements)
+	}
+	for name, want := range map[string]bool{"lr": true, "epochs": true, "seed": false, "vocab": false} {
+		if got := l.inputPresent(name); got != want {
+			t.Errorf("inputPresent(%q) = %v, want %v", name, got, want)
+		}
+	}
+
+	required := &Launcher{
+		options:                 &LauncherOptions{},
+		placeholderReplacements: make(map[string]string),
+		runtimeInfo: &runtimeInfo{
+			InputParameters: map[string]*inputParameter{"lr": {ParameterType: "DOUBLE"}},
+		},
+	}
+	if err := required.prepareInputs(context.Background()); err == nil {
+		t.Error("prepareInputs() succeeded for a required parameter without value")
+	}
+}
+
+func TestPrepareInputs_DefaultArtifact(t *testing.T) {
+	env := newTestEnv(t)
+	writeFiles(t, env.root, map[string]string{"external/vocab.txt": "words"})
+	uri := "file://" + filepath.Join(env.root, "external/vocab.txt")
+	runtimeInfo := fmt.Sprintf(`{
+		"inputArtifacts": {
+			"vocab": {"defaultValue": {"uri": %q, "artifactSchema": "title: kfp.Dataset\n"}}
+		}
+	}`, uri)
+	l := env.launcher(t, "task-1", runtimeInfo)
+
+	ctx := context.Background()
+	if err := l.prepareInputs(ctx); err != nil {
+		t.Fatal(err)
+	}
+	a := l.runtimeInfo.InputArtifacts["vocab"].Artifact
+	if a.GetId() == 0 || a.GetUri() != uri {
+		t.Errorf("Default artifact = %v, want a registered artifact at %q", a, uri)
+	}
+	if got := l.placeholderReplacements[`{{$.inputs.artifacts['vocab'].uri}}`]; got != uri {
+		t.Errorf("URI placeholder = %q, want %q", got, uri)
+	}
+	if err := l.downloadInputs(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ioutil.ReadFile(l.runtimeInfo.InputArtifacts["vocab"].LocalArtifactFilePath); err != nil || string(b) != "words" {
+		t.Errorf("Downloaded default artifact = %q, %v, want %q", b, err, "words")
+	}
+
+	// The default is registered once, however many tasks use it.
+	other := env.launcher(t, "task-2", runtimeInfo)
+	if err := other.prepareInputs(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := other.runtimeInfo.InputArtifacts["vocab"].Artifact.GetId(); got != a.GetId() {
+		t.Errorf("Second task got default artifact %d, want %d", got, a.GetId())
+	}
+}
+
+func TestPrepareAndDownloadInputs_LocalRoot(t *testing.T) {
+	root, err := ioutil.TempDir("", "kfp-launcher-root")
 	if err != nil {
 		t.Fatal(err)
 	}
-	defer r.Close()
+	defer os.RemoveAll(root)
 
-	w, err := os.Create("dir1/dir2/foo.txt")
+	bc, err := parseBucketConfig("file://" + root)
 	if err != nil {
 		t.Fatal(err)
 	}
-	if _, err := io.Copy(w, r); err != nil {
+	key := "my-pipeline/my-run/my-task/data"
+	if err := os.MkdirAll(filepath.Join(root, filepath.Dir(key)), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, key), []byte("my dataset"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	metadataFile := filepath.Join(root, "input_artifact.json")
+	artifactJSON := fmt.Sprintf(`{"id": "1", "uri": %q}`, bc.uriFromKey(key))
+	if err := ioutil.WriteFile(metadataFile, []byte(artifactJSON), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	l := &Launcher{
+		options:                 &LauncherOptions{LocalDir: t.TempDir()},
+		bucketConfig:            bc,
+		placeholderReplacements: make(map[string]string),
+		runtimeInfo: &runtimeInfo{
+			InputArtifacts: map[string]*inputArtifact{
+				"dataset": {FileInputPath: metadataFile},
+			},
+		},
+	}
+	if err := l.prepareInputs(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.downloadInputs(context.Background()); err != nil {
 		t.Fatal(err)
 	}
+
+	localPath := l.runtimeInfo.InputArtifacts["dataset"].LocalArtifactFilePath
+	got, err := ioutil.ReadFile(localPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "my dataset" {
+		t.Errorf("Got local artifact contents %q, want %q", got, "my dataset")
+	}
+	if want := "file://" + root + "/" + key; l.placeholderReplacements[`{{$.inputs.artifacts['dataset'].uri}}`] != want {
+		t.Errorf("Got uri placeholder %q, want %q", l.placeholderReplacements[`{{$.inputs.artifacts['dataset'].uri}}`], want)
+	}
 }
 
 func Test_parseCloudBucket(t *testing.T) {
@@ -129,6 +297,38 @@ func Test_parseCloudBucket(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "Parses S3 - Bucket with prefix",
+			path: "s3://my-bucket/my-path",
+			want: &bucketConfig{
+				scheme:     "s3://",
+				bucketName: "my-bucket",
+				prefix:     "my-path/",
+			},
+			wantErr: false,
+		},
+		{
+			name: "Parses local directory",
+			path: "file:///tmp/my-path/",
+			want: &bucketConfig{
+				scheme:     "file://",
+				bucketName: "/tmp/my-path",
+				prefix:     "",
+			},
+			wantErr: false,
+		},
+		{
+			name:    "Rejects relative local directory",
+			path:    "file://my-path",
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name:    "Rejects unsupported scheme",
+			path:    "ftp://my-bucket/my-path",
+			want:    nil,
+			wantErr: true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -172,6 +372,13 @@ func Test_bucketConfig_keyFromURI(t *testing.T) {
 			want:         "path1/path2",
 			wantErr:      false,
 		},
+		{
+			name:         "Local directory",
+			bucketConfig: &bucketConfig{scheme: "file://", bucketName: "/tmp/root", prefix: ""},
+			uri:          "file:///tmp/root/path1/path2",
+			want:         "path1/path2",
+			wantErr:      false,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -186,3 +393,529 @@ func Test_bucketConfig_keyFromURI(t *testing.T) {
 		})
 	}
 }
+
+func Test_openStore_S3(t *testing.T) {
+	backend := s3mem.New()
+	if err := backend.CreateBucket("my-bucket"); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(gofakes3.New(backend).Server())
+	defer server.Close()
+
+	options := &LauncherOptions{
+		S3Endpoint:        server.URL,
+		S3Region:          "us-west-2",
+		S3ForcePathStyle:  true,
+		S3DisableSSL:      true,
+		S3AccessKeyID:     "minio",
+		S3SecretAccessKey: "minio123",
+	}
+	bc, err := parseBucketConfig("s3://my-bucket/my-path")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	store, err := openStore(ctx, bc, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	if err := store.Upload(ctx, "foo.txt", strings.NewReader("Hello, World!")); err != nil {
+		t.Fatal(err)
+	}
+
+	// The object should land under the pipeline root prefix.
+	obj, err := backend.GetObject("my-bucket", "my-path/foo.txt", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer obj.Contents.Close()
+	got, err := ioutil.ReadAll(obj.Contents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "Hello, World!" {
+		t.Errorf("Got object contents %q, want %q", got, "Hello, World!")
+	}
+}
+
+func TestLauncherOptions_outputKey(t *testing.T) {
+	tests := []struct {
+		name       string
+		template   string
+		outputName string
+		want       string
+		wantErr    bool
+	}{
+		{
+			name:       "Default template",
+			template:   DefaultOutputURITemplate,
+			outputName: "model",
+			want:       "my-pipeline/my-run/my-task-id/model",
+		},
+		{
+			name:       "Custom template",
+			template:   "runs/{{run}}/{{task_name}}/{{output_name}}/",
+			outputName: "model",
+			want:       "runs/my-run/my-task/model",
+		},
+		{
+			name:       "Unknown variable",
+			template:   "{{pipeline}}/{{unknown}}/{{output_name}}",
+			outputName: "model",
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := &LauncherOptions{
+				PipelineName:      "my-pipeline",
+				PipelineRunID:     "my-run",
+				PipelineTaskID:    "my-task-id",
+				TaskName:          "my-task",
+				OutputURITemplate: tt.template,
+			}
+			got, err := o.outputKey(tt.outputName)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("outputKey() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("outputKey() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrepareOutputs_UniqueURIs(t *testing.T) {
+	bc, err := parseBucketConfig("gs://my-bucket/root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := &Launcher{
+		options: &LauncherOptions{
+			PipelineName:      "my-pipeline",
+			PipelineRunID:     "my-run",
+			PipelineTaskID:    "my-task-id",
+			OutputURITemplate: DefaultOutputURITemplate,
+			LocalDir:          t.TempDir(),
+		},
+		bucketConfig:            bc,
+		placeholderReplacements: make(map[string]string),
+		runtimeInfo: &runtimeInfo{
+			OutputArtifacts: map[string]*outputArtifact{
+				"model":   {},
+				"metrics": {},
+			},
+		},
+	}
+	if err := l.prepareOutputs(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"model":   "gs://my-bucket/root/my-pipeline/my-run/my-task-id/model",
+		"metrics": "gs://my-bucket/root/my-pipeline/my-run/my-task-id/metrics",
+	}
+	for name, uri := range want {
+		if got := l.runtimeInfo.OutputArtifacts[name].URIOutputPath; got != uri {
+			t.Errorf("URIOutputPath for %q = %q, want %q", name, got, uri)
+		}
+		if got := l.placeholderReplacements[fmt.Sprintf(`{{$.outputs.artifacts['%s'].uri}}`, name)]; got != uri {
+			t.Errorf("uri placeholder for %q = %q, want %q", name, got, uri)
+		}
+	}
+}
+
+func Test_executionFailure(t *testing.T) {
+	tests := []struct {
+		name         string
+		script       string
+		wantExitCode int
+		wantSignal   string
+	}{
+		{
+			name:         "Non-zero exit",
+			script:       "exit 3",
+			wantExitCode: 3,
+		},
+		{
+			name:         "Killed by signal",
+			script:       "kill -TERM $$",
+			wantExitCode: -1,
+			wantSignal:   "terminated",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runErr := exec.Command("sh", "-c", tt.script).Run()
+			if runErr == nil {
+				t.Fatal("Expected command to fail")
+			}
+			got := executionFailure(fmt.Errorf("User command failed: %w", runErr))
+			if got.ExitCode != tt.wantExitCode || got.Signal != tt.wantSignal {
+				t.Errorf("executionFailure() = {ExitCode: %d, Signal: %q}, want {ExitCode: %d, Signal: %q}",
+					got.ExitCode, got.Signal, tt.wantExitCode, tt.wantSignal)
+			}
+		})
+	}
+
+	got := executionFailure(fmt.Errorf("Failed to download input artifact"))
+	if got.ExitCode != -1 || got.Signal != "" || got.Message != "Failed to download input artifact" {
+		t.Errorf("executionFailure() for launcher error = %+v", got)
+	}
+}
+
+// testEnv runs tasks end to end, against an embedded metadata store and a
+// file:// pipeline root.
+type testEnv struct {
+	// root is the directory of the pipeline root.
+	root     string
+	mlmd     *embedded.Server
+	endpoint *embedded.Endpoint
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	t.Helper()
+	s, err := embedded.NewServer("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	endpoint, err := embedded.Serve(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(endpoint.Stop)
+	return &testEnv{root: t.TempDir(), mlmd: s, endpoint: endpoint}
+}
+
+// launcher returns a launcher for the task of run "my-run" of "my-pipeline"
+// with the given ID.
+func (e *testEnv) launcher(t *testing.T, taskID, runtimeInfo string) *Launcher {
+	t.Helper()
+	l, err := NewLauncher(runtimeInfo, &LauncherOptions{
+		PipelineName:      "my-pipeline",
+		PipelineRunID:     "my-run",
+		PipelineTaskID:    taskID,
+		PipelineRoot:      "file://" + e.root,
+		TaskName:          taskID,
+		MLMDServerAddress: e.endpoint.Address,
+		MLMDServerPort:    e.endpoint.Port,
+		MLMDMaxAttempts:   1,
+		LocalDir:          t.TempDir(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+// executions returns the recorded executions, in the order they were created.
+func (e *testEnv) executions(t *testing.T) []*pb.Execution {
+	t.Helper()
+	res, err := e.mlmd.GetExecutions(context.Background(), &pb.GetExecutionsRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res.GetExecutions()
+}
+
+// readArtifact reads the MLMD artifact written to an output artifact file.
+func readArtifact(t *testing.T, path string) *pb.Artifact {
+	t.Helper()
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &pb.Artifact{}
+	if err := protojson.Unmarshal(b, a); err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+// helperComponentEnv selects how TestHelperComponent writes its output.
+const helperComponentEnv = "KFP_LAUNCHER_TEST_HELPER_COMPONENT"
+
+// helperReportedURIEnv, if set, is the URI TestHelperComponent reports for
+// its "model" output instead of the URI it was given.
+const helperReportedURIEnv = "KFP_LAUNCHER_TEST_HELPER_REPORTED_URI"
+
+// helperComponent returns the user command running TestHelperComponent with
+// args, which writes its "model" output to the local path of the artifact,
+// or to its URI if toURI is set.
+func helperComponent(t *testing.T, toURI bool, args ...string) (string, []string) {
+	mode := "local"
+	if toURI {
+		mode = "uri"
+	}
+	t.Setenv(helperComponentEnv, mode)
+	return os.Args[0], append([]string{"-test.run=^TestHelperComponent$", "--"}, args...)
+}
+
+// TestHelperComponent is not a test, but the user command of the tests of the
+// executor protocol. Its last argument is the ExecutorInput. It writes the
+// "model" output artifact and reports the "rows" output parameter and a
+// custom property of the model in its ExecutorOutput.
+func TestHelperComponent(t *testing.T) {
+	mode := os.Getenv(helperComponentEnv)
+	if len(mode) == 0 {
+		return
+	}
+	fail := func(err error) {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	ei := &pipeline_spec.ExecutorInput{}
+	if err := protojson.Unmarshal([]byte(os.Args[len(os.Args)-1]), ei); err != nil {
+		fail(err)
+	}
+	model := ei.GetOutputs().GetArtifacts()["model"].GetArtifacts()[0]
+	p := model.GetCustomProperties()[localPathProperty].GetStringValue()
+	if mode == "uri" {
+		p = strings.TrimPrefix(model.GetUri(), "file://")
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		fail(err)
+	}
+	if err := ioutil.WriteFile(p, []byte("weights"), 0644); err != nil {
+		fail(err)
+	}
+
+	if uri := os.Getenv(helperReportedURIEnv); len(uri) > 0 {
+		model.Uri = uri
+	}
+	model.CustomProperties["accuracy"] = &pipeline_spec.Value{Value: &pipeline_spec.Value_DoubleValue{DoubleValue: 0.9}}
+	eo := &pipeline_spec.ExecutorOutput{
+		Parameters: map[string]*pipeline_spec.Value{
+			"rows": {Value: &pipeline_spec.Value_IntValue{IntValue: 7}},
+		},
+		Artifacts: map[string]*pipeline_spec.ArtifactList{
+			"model": {Artifacts: []*pipeline_spec.RuntimeArtifact{model}},
+		},
+	}
+	b, err := protojson.Marshal(eo)
+	if err != nil {
+		fail(err)
+	}
+	if err := ioutil.WriteFile(ei.GetOutputs().GetOutputFile(), b, 0644); err != nil {
+		fail(err)
+	}
+	os.Exit(0)
+}
+
+func TestRunComponent_ExecutorProtocol(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		toURI bool
+	}{
+		{"LocalPath", false},
+		{"URI", true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t)
+			outputs := t.TempDir()
+			rt := fmt.Sprintf(`{
+  "OutputParameters": {"rows": {"ParameterType": "INT", "FileOutputPath": %q}},
+  "OutputArtifacts": {"model": {"ArtifactSchema": "title: kfp.Model\n", "FileOutputPath": %q}}
+}`, filepath.Join(outputs, "rows"), filepath.Join(outputs, "model"))
+			l := env.launcher(t, "train", rt)
+			cmd, args := helperComponent(t, tt.toURI, "{{$}}")
+			if err := l.RunComponent(context.Background(), cmd, args...); err != nil {
+				t.Fatal(err)
+			}
+
+			key := "my-pipeline/my-run/train/model"
+			b, err := ioutil.ReadFile(filepath.Join(env.root, key))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != "weights" {
+				t.Errorf("Stored model = %q, want %q", b, "weights")
+			}
+
+			model := readArtifact(t, filepath.Join(outputs, "model"))
+			if want := "file://" + env.root + "/" + key; model.GetUri() != want {
+				t.Errorf("Recorded model URI = %q, want %q", model.GetUri(), want)
+			}
+			if got := model.GetCustomProperties()["accuracy"].GetDoubleValue(); got != 0.9 {
+				t.Errorf("Recorded model accuracy = %v, want 0.9", got)
+			}
+			if _, ok := model.GetCustomProperties()[localPathProperty]; ok {
+				t.Errorf("Recorded model has the local path property: %v", model)
+			}
+			if b, err := ioutil.ReadFile(filepath.Join(outputs, "rows")); err != nil || string(b) != "7" {
+				t.Errorf("Output parameter file = %q, %v, want %q", b, err, "7")
+			}
+
+			executions := env.executions(t)
+			if len(executions) != 1 {
+				t.Fatalf("Got executions %v, want one", executions)
+			}
+			e := executions[0]
+			if e.GetLastKnownState() != pb.Execution_COMPLETE || e.GetCustomProperties()["output:rows"].GetIntValue() != 7 {
+				t.Errorf("Got execution %v, want COMPLETE with output rows 7", e)
+			}
+		})
+	}
+}
+
+func TestRunComponent_ReportedURI(t *testing.T) {
+	outside := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(outside, "model"), []byte("weights"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name    string
+		key     string
+		uri     func(root string) string
+		wantErr string
+	}{
+		{
+			name: "Stored under the pipeline root",
+			key:  "elsewhere/model",
+			uri:  func(root string) string { return "file://" + root + "/elsewhere/model" },
+		},
+		{
+			name:    "Nonexistent",
+			uri:     func(root string) string { return "file://" + root + "/missing/model" },
+			wantErr: "does not exist",
+		},
+		{
+			name:    "Outside the pipeline root",
+			uri:     func(string) string { return "file://" + outside + "/model" },
+			wantErr: "does not have expected bucket prefix",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t)
+			if len(tt.key) > 0 {
+				p := filepath.Join(env.root, tt.key)
+				if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := ioutil.WriteFile(p, []byte("weights"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			outputs := t.TempDir()
+			l := env.launcher(t, "train", shellTaskRuntimeInfo(outputs))
+			t.Setenv(helperReportedURIEnv, tt.uri(env.root))
+			cmd, args := helperComponent(t, false, "{{$}}")
+			err := l.RunComponent(context.Background(), cmd, args...)
+
+			executions := env.executions(t)
+			if len(executions) != 1 {
+				t.Fatalf("Got executions %v, want one", executions)
+			}
+			if len(tt.wantErr) > 0 {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("RunComponent() error = %v, want error containing %q", err, tt.wantErr)
+				}
+				if got := executions[0].GetLastKnownState(); got != pb.Execution_FAILED {
+					t.Errorf("Execution state = %v, want FAILED", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := readArtifact(t, filepath.Join(outputs, "model")).GetUri(), tt.uri(env.root); got != want {
+				t.Errorf("Recorded model URI = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+// shellTaskRuntimeInfo declares the "rows" output parameter and "model" output
+// artifact of a task, with output files in dir.
+func shellTaskRuntimeInfo(dir string) string {
+	return fmt.Sprintf(`{
+  "OutputParameters": {"rows": {"ParameterType": "INT", "FileOutputPath": %q}},
+  "OutputArtifacts": {"model": {"ArtifactSchema": "title: kfp.Model\n", "FileOutputPath": %q}}
+}`, filepath.Join(dir, "rows"), filepath.Join(dir, "model"))
+}
+
+// shellTaskArgs run script with the output parameter file and the output
+// artifact path as $0 and $1.
+func shellTaskArgs(script string) []string {
+	return []string{"-c", script, "{{$.outputs.parameters['rows'].output_file}}", "{{$.outputs.artifacts['model'].path}}"}
+}
+
+func TestRunComponent_Success(t *testing.T) {
+	env := newTestEnv(t)
+	outputs := t.TempDir()
+	l := env.launcher(t, "train", shellTaskRuntimeInfo(outputs))
+	if err := l.RunComponent(context.Background(), "sh", shellTaskArgs(`printf '42\n' > "$0" && printf weights > "$1"`)...); err != nil {
+		t.Fatal(err)
+	}
+
+	model := readArtifact(t, filepath.Join(outputs, "model"))
+	if b, err := ioutil.ReadFile(strings.TrimPrefix(model.GetUri(), "file://")); err != nil || string(b) != "weights" {
+		t.Errorf("Stored model at %q = %q, %v, want %q", model.GetUri(), b, err, "weights")
+	}
+	executions := env.executions(t)
+	if len(executions) != 1 {
+		t.Fatalf("Got executions %v, want one", executions)
+	}
+	e := executions[0]
+	if e.GetLastKnownState() != pb.Execution_COMPLETE || e.GetCustomProperties()["output:rows"].GetIntValue() != 42 {
+		t.Errorf("Got execution %v, want COMPLETE with output rows 42", e)
+	}
+}
+
+func TestRunComponent_FailedCommand(t *testing.T) {
+	env := newTestEnv(t)
+	l := env.launcher(t, "train", shellTaskRuntimeInfo(t.TempDir()))
+	err := l.RunComponent(context.Background(), "sh", shellTaskArgs(`echo failing >&2; exit 3`)...)
+	if code, ok := UserCommandExitCode(err); !ok || code != 3 {
+		t.Fatalf("RunComponent() error = %v, want user command exit code 3", err)
+	}
+
+	executions := env.executions(t)
+	if len(executions) != 1 {
+		t.Fatalf("Got executions %v, want one", executions)
+	}
+	e := executions[0]
+	if e.GetLastKnownState() != pb.Execution_FAILED || e.GetCustomProperties()["exit_code"].GetIntValue() != 3 {
+		t.Errorf("Got execution %v, want FAILED with exit code 3", e)
+	}
+}
+
+func TestRunComponent_CacheHit(t *testing.T) {
+	env := newTestEnv(t)
+	// The command counts its runs, so that a cache hit can be told apart
+	// from a second run.
+	runs := filepath.Join(t.TempDir(), "runs")
+	args := shellTaskArgs(`printf x >> ` + runs + ` && printf 42 > "$0" && printf weights > "$1"`)
+
+	first := t.TempDir()
+	if err := env.launcher(t, "train-1", shellTaskRuntimeInfo(first)).RunComponent(context.Background(), "sh", args...); err != nil {
+		t.Fatal(err)
+	}
+	second := t.TempDir()
+	if err := env.launcher(t, "train-2", shellTaskRuntimeInfo(second)).RunComponent(context.Background(), "sh", args...); err != nil {
+		t.Fatal(err)
+	}
+
+	if b, err := ioutil.ReadFile(runs); err != nil || string(b) != "x" {
+		t.Errorf("Command ran %q times, %v, want once", b, err)
+	}
+	if got, want := readArtifact(t, filepath.Join(second, "model")), readArtifact(t, filepath.Join(first, "model")); got.GetId() != want.GetId() {
+		t.Errorf("Cached task output artifact %d, want the artifact %d of the first task", got.GetId(), want.GetId())
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(second, "rows")); err != nil || string(b) != "42" {
+		t.Errorf("Cached task output parameter = %q, %v, want %q", b, err, "42")
+	}
+
+	executions := env.executions(t)
+	if len(executions) != 2 {
+		t.Fatalf("Got executions %v, want two", executions)
+	}
+	if executions[0].GetLastKnownState() != pb.Execution_COMPLETE || executions[1].GetLastKnownState() != pb.Execution_CACHED {
+		t.Errorf("Got execution states %v and %v, want COMPLETE and CACHED", executions[0].GetLastKnownState(), executions[1].GetLastKnownState())
+	}
+}
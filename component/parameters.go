@@ -0,0 +1,159 @@
+package component
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/neuromage/kfp-launcher/metadata"
+	pb "github.com/neuromage/kfp-launcher/third_party/ml_metadata"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// Parameter types supported in runtime info.
+const (
+	parameterTypeString  = "STRING"
+	parameterTypeInt     = "INT"
+	parameterTypeDouble  = "DOUBLE"
+	parameterTypeBoolean = "BOOLEAN"
+	parameterTypeList    = "LIST"
+	parameterTypeStruct  = "STRUCT"
+)
+
+// wrappedValueKey is the field holding BOOLEAN and LIST values, which MLMD can
+// only store inside a struct.
+const wrappedValueKey = "value"
+
+// parseParameter parses text, a value of parameter type t as passed in
+// runtime info or written to an output file, into an MLMD value.
+//
+// Surrounding whitespace, such as the trailing newline of an output file, is
+// ignored in INT, DOUBLE and BOOLEAN values. BOOLEAN values are "true" or "false" (or any other form strconv.ParseBool
+// accepts), LIST values JSON arrays and STRUCT values JSON objects. MLMD has
+// no boolean or list values, so those are stored as structs of the form
+// {"value": ...}.
+func parseParameter(t, text string) (*pb.Value, error) {
+	switch t {
+	case parameterTypeString:
+		return &pb.Value{Value: &pb.Value_StringValue{StringValue: text}}, nil
+	case parameterTypeInt:
+		i, err := strconv.ParseInt(strings.TrimSpace(text), 10, 0)
+		if err != nil {
+			return nil, err
+		}
+		return &pb.Value{Value: &pb.Value_IntValue{IntValue: i}}, nil
+	case parameterTypeDouble:
+		f, err := strconv.ParseFloat(strings.TrimSpace(text), 0)
+		if err != nil {
+			return nil, err
+		}
+		return &pb.Value{Value: &pb.Value_DoubleValue{DoubleValue: f}}, nil
+	case parameterTypeBoolean:
+		b, err := strconv.ParseBool(strings.TrimSpace(text))
+		if err != nil {
+			return nil, err
+		}
+		return wrappedStructValue(b)
+	case parameterTypeList:
+		var l []interface{}
+		if err := json.Unmarshal([]byte(text), &l); err != nil || l == nil {
+			return nil, fmt.Errorf("LIST value must be a JSON array, got %q", text)
+		}
+		return wrappedStructValue(l)
+	case parameterTypeStruct:
+		var m map[string]interface{}
+		if err := json.Unmarshal([]byte(text), &m); err != nil || m == nil {
+			return nil, fmt.Errorf("STRUCT value must be a JSON object, got %q", text)
+		}
+		s, err := structpb.NewStruct(m)
+		if err != nil {
+			return nil, err
+		}
+		return &pb.Value{Value: &pb.Value_StructValue{StructValue: s}}, nil
+	}
+	return nil, fmt.Errorf("Unknown parameter type %q", t)
+}
+
+func wrappedStructValue(v interface{}) (*pb.Value, error) {
+	s, err := structpb.NewStruct(map[string]interface{}{wrappedValueKey: v})
+	if err != nil {
+		return nil, err
+	}
+	return &pb.Value{Value: &pb.Value_StructValue{StructValue: s}}, nil
+}
+
+// parameterText returns the text representation of v, an MLMD value of
+// parameter type t, as substituted into placeholders and written to output
+// files. Lists and structs are rendered as compact JSON with sorted keys.
+func parameterText(t string, v *pb.Value) (string, error) {
+	switch t {
+	case parameterTypeString, parameterTypeInt, parameterTypeDouble:
+		if pv := toPipelineValueFromMLMD(v); pv != nil {
+			return pipelineValueText(pv), nil
+		}
+	case parameterTypeBoolean, parameterTypeList:
+		s := v.GetStructValue()
+		if s == nil {
+			break
+		}
+		field, ok := s.AsMap()[wrappedValueKey]
+		if !ok {
+			break
+		}
+		b, err := json.Marshal(field)
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	case parameterTypeStruct:
+		s := v.GetStructValue()
+		if s == nil {
+			break
+		}
+		b, err := json.Marshal(s.AsMap())
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	default:
+		return "", fmt.Errorf("Unknown parameter type %q", t)
+	}
+	return "", fmt.Errorf("Value %v is not a valid %s parameter", v, t)
+}
+
+// validatedParameterText validates text as a value of parameter type t, and
+// returns the text to substitute for it: BOOLEAN values in canonical form, INT
+// and DOUBLE values without surrounding whitespace, and other values
+// unchanged. LIST and STRUCT values keep their original text, as re-encoding
+// them would reorder keys and round integers beyond 2^53.
+func validatedParameterText(t, text string) (string, error) {
+	v, err := parseParameter(t, text)
+	if err != nil {
+		return "", err
+	}
+	switch t {
+	case parameterTypeBoolean:
+		return parameterText(t, v)
+	case parameterTypeInt, parameterTypeDouble:
+		return strings.TrimSpace(text), nil
+	}
+	return text, nil
+}
+
+// addParameter records v, as returned by parseParameter, in params.
+func addParameter(params *metadata.Parameters, name string, v *pb.Value) {
+	switch t := v.GetValue().(type) {
+	case *pb.Value_StringValue:
+		params.StringParameters[name] = t.StringValue
+	case *pb.Value_IntValue:
+		params.IntParameters[name] = t.IntValue
+	case *pb.Value_DoubleValue:
+		params.DoubleParameters[name] = t.DoubleValue
+	case *pb.Value_StructValue:
+		if params.StructParameters == nil {
+			params.StructParameters = make(map[string]*structpb.Struct)
+		}
+		params.StructParameters[name] = t.StructValue
+	}
+}
@@ -0,0 +1,182 @@
+package component
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/neuromage/kfp-launcher/metadata"
+	pb "github.com/neuromage/kfp-launcher/third_party/ml_metadata"
+	"google.golang.org/protobuf/testing/protocmp"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func Test_parseParameter(t *testing.T) {
+	structValue := func(m map[string]interface{}) *pb.Value {
+		s, err := structpb.NewStruct(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &pb.Value{Value: &pb.Value_StructValue{StructValue: s}}
+	}
+
+	tests := []struct {
+		name          string
+		parameterType string
+		text          string
+		want          *pb.Value
+		wantText      string
+		wantErr       bool
+	}{
+		{
+			name:          "String",
+			parameterType: "STRING",
+			text:          "hello",
+			want:          &pb.Value{Value: &pb.Value_StringValue{StringValue: "hello"}},
+			wantText:      "hello",
+		},
+		{
+			name:          "Int",
+			parameterType: "INT",
+			text:          "42",
+			want:          &pb.Value{Value: &pb.Value_IntValue{IntValue: 42}},
+			wantText:      "42",
+		},
+		{
+			name:          "Int with trailing newline",
+			parameterType: "INT",
+			text:          "42\n",
+			want:          &pb.Value{Value: &pb.Value_IntValue{IntValue: 42}},
+			wantText:      "42",
+		},
+		{
+			name:          "Double with surrounding whitespace",
+			parameterType: "DOUBLE",
+			text:          " 0.5\n",
+			want:          &pb.Value{Value: &pb.Value_DoubleValue{DoubleValue: 0.5}},
+			wantText:      "0.5",
+		},
+		{
+			name:          "Boolean",
+			parameterType: "BOOLEAN",
+			text:          "True\n",
+			want:          structValue(map[string]interface{}{"value": true}),
+			wantText:      "true",
+		},
+		{
+			name:          "List",
+			parameterType: "LIST",
+			text:          `[1, "two", {"three": 3}]`,
+			want:          structValue(map[string]interface{}{"value": []interface{}{1, "two", map[string]interface{}{"three": 3}}}),
+			wantText:      `[1,"two",{"three":3}]`,
+		},
+		{
+			name:          "Struct",
+			parameterType: "STRUCT",
+			text:          `{"b": [true], "a": "x"}`,
+			want:          structValue(map[string]interface{}{"a": "x", "b": []interface{}{true}}),
+			wantText:      `{"a":"x","b":[true]}`,
+		},
+		{
+			name:          "Invalid boolean",
+			parameterType: "BOOLEAN",
+			text:          "yes",
+			wantErr:       true,
+		},
+		{
+			name:          "List that is not an array",
+			parameterType: "LIST",
+			text:          `{"a": 1}`,
+			wantErr:       true,
+		},
+		{
+			name:          "Struct that is not an object",
+			parameterType: "STRUCT",
+			text:          `null`,
+			wantErr:       true,
+		},
+		{
+			name:          "Unknown type",
+			parameterType: "BYTES",
+			text:          "abc",
+			wantErr:       true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseParameter(tt.parameterType, tt.text)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseParameter() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if diff := cmp.Diff(tt.want, got, protocmp.Transform()); diff != "" {
+				t.Errorf("parseParameter() mismatch (-want +got):\n%s", diff)
+			}
+
+			text, err := parameterText(tt.parameterType, got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if text != tt.wantText {
+				t.Errorf("parameterText() = %q, want %q", text, tt.wantText)
+			}
+		})
+	}
+}
+
+func Test_validatedParameterText(t *testing.T) {
+	tests := []struct {
+		name          string
+		parameterType string
+		text          string
+		want          string
+		wantErr       bool
+	}{
+		{name: "String", parameterType: "STRING", text: " hello\n", want: " hello\n"},
+		{name: "Int", parameterType: "INT", text: "42\n", want: "42"},
+		{name: "Double", parameterType: "DOUBLE", text: " 1e3 ", want: "1e3"},
+		{name: "Boolean", parameterType: "BOOLEAN", text: "True\n", want: "true"},
+		{name: "List with large integer", parameterType: "LIST", text: `[9007199254740993, 1]`, want: `[9007199254740993, 1]`},
+		{name: "Struct keeps key order", parameterType: "STRUCT", text: `{"b": 1, "a": 2}`, want: `{"b": 1, "a": 2}`},
+		{name: "Invalid int", parameterType: "INT", text: "4 2", wantErr: true},
+		{name: "Invalid struct", parameterType: "STRUCT", text: `{"a": }`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := validatedParameterText(tt.parameterType, tt.text)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("validatedParameterText() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("validatedParameterText() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_addParameter(t *testing.T) {
+	params := &metadata.Parameters{
+		IntParameters:    make(map[string]int64),
+		StringParameters: make(map[string]string),
+		DoubleParameters: make(map[string]float64),
+	}
+	for name, p := range map[string]struct{ parameterType, text string }{
+		"epochs":  {"INT", "10"},
+		"shuffle": {"BOOLEAN", "false"},
+		"config":  {"STRUCT", `{"lr": 0.1}`},
+	} {
+		v, err := parseParameter(p.parameterType, p.text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		addParameter(params, name, v)
+	}
+
+	if params.IntParameters["epochs"] != 10 {
+		t.Errorf("IntParameters = %v, want epochs: 10", params.IntParameters)
+	}
+	if len(params.StructParameters) != 2 {
+		t.Errorf("StructParameters = %v, want shuffle and config", params.StructParameters)
+	}
+}
@@ -0,0 +1,174 @@
+package component
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const (
+	placeholderStart = "{{$"
+	placeholderEnd   = "}}"
+)
+
+// resolvePlaceholders replaces every placeholder of the form {{$...}} in s
+// with its value from replacements. A single string may contain any number of
+// placeholders mixed with literal text, e.g. "--lr={{$.inputs.parameters['lr']}}".
+// Placeholders without a replacement result in an error.
+func resolvePlaceholders(s string, replacements map[string]string) (string, error) {
+	var b strings.Builder
+	var unresolved []string
+	for {
+		start := strings.Index(s, placeholderStart)
+		if start < 0 {
+			b.WriteString(s)
+			break
+		}
+		end := strings.Index(s[start:], placeholderEnd)
+		if end < 0 {
+			return "", fmt.Errorf("Unterminated placeholder %q", s[start:])
+		}
+		end += start + len(placeholderEnd)
+
+		placeholder := s[start:end]
+		value, ok := replacements[placeholder]
+		if !ok {
+			unresolved = append(unresolved, placeholder)
+		}
+		b.WriteString(s[:start])
+		b.WriteString(value)
+		s = s[end:]
+	}
+
+	if len(unresolved) > 0 {
+		return "", fmt.Errorf("Unresolved placeholders: %s", strings.Join(unresolved, ", "))
+	}
+	return b.String(), nil
+}
+
+// resolveCommand resolves placeholders in cmd and each of args. All
+// unresolved placeholders are reported together.
+func resolveCommand(cmd string, args []string, replacements map[string]string) (string, []string, error) {
+	var errs []string
+	resolve := func(s string) string {
+		r, err := resolvePlaceholders(s, replacements)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%q: %v", s, err))
+		}
+		return r
+	}
+
+	resolvedCmd := resolve(cmd)
+	resolvedArgs := make([]string, 0, len(args))
+	for _, a := range args {
+		resolvedArgs = append(resolvedArgs, resolve(a))
+	}
+
+	if len(errs) > 0 {
+		return "", nil, fmt.Errorf("Failed to resolve command line:\n\t%s", strings.Join(errs, "\n\t"))
+	}
+	return resolvedCmd, resolvedArgs, nil
+}
+
+// ifPresent is KFP's conditional argument construct. An argument of the form
+//
+//	{"IfPresent": {"InputName": "x", "Then": [...], "Else": [...]}}
+//
+// expands to the Then arguments if input x is present, and to the Else
+// arguments otherwise. Then and Else may be a single string or a list whose
+// elements are strings or nested IfPresent objects.
+type ifPresent struct {
+	InputName string
+	Then      json.RawMessage
+	Else      json.RawMessage
+}
+
+// parseIfPresent returns the IfPresent construct in arg, or nil if arg is an
+// ordinary argument.
+func parseIfPresent(arg string) (*ifPresent, error) {
+	if !strings.HasPrefix(strings.TrimSpace(arg), "{") {
+		return nil, nil
+	}
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(arg), &obj); err != nil {
+		// Not a construct, just an argument that happens to start with "{".
+		return nil, nil
+	}
+	raw, ok := obj["IfPresent"]
+	if !ok || len(obj) != 1 {
+		return nil, nil
+	}
+
+	c := &ifPresent{}
+	d := json.NewDecoder(strings.NewReader(string(raw)))
+	d.DisallowUnknownFields()
+	if err := d.Decode(c); err != nil {
+		return nil, fmt.Errorf("Invalid IfPresent argument %s: %v", arg, err)
+	}
+	if len(c.InputName) == 0 {
+		return nil, fmt.Errorf("Invalid IfPresent argument %s: missing InputName", arg)
+	}
+	return c, nil
+}
+
+// expandConditionals expands every IfPresent construct in args. present
+// reports whether the named input has a value.
+func expandConditionals(args []string, present func(inputName string) bool) ([]string, error) {
+	expanded := make([]string, 0, len(args))
+	for _, a := range args {
+		c, err := parseIfPresent(a)
+		if err != nil {
+			return nil, err
+		}
+		if c == nil {
+			expanded = append(expanded, a)
+			continue
+		}
+
+		branch := c.Else
+		if present(c.InputName) {
+			branch = c.Then
+		}
+		branchArgs, err := conditionalBranchArgs(branch)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid IfPresent argument %s: %v", a, err)
+		}
+		branchArgs, err = expandConditionals(branchArgs, present)
+		if err != nil {
+			return nil, err
+		}
+		expanded = append(expanded, branchArgs...)
+	}
+	return expanded, nil
+}
+
+// conditionalBranchArgs returns the arguments of a Then or Else branch. Nested
+// constructs are returned in their JSON form, to be expanded by the caller.
+func conditionalBranchArgs(branch json.RawMessage) ([]string, error) {
+	if len(branch) == 0 || string(branch) == "null" {
+		return nil, nil
+	}
+
+	var single string
+	if err := json.Unmarshal(branch, &single); err == nil {
+		return []string{single}, nil
+	}
+
+	var items []json.RawMessage
+	if err := json.Unmarshal(branch, &items); err != nil {
+		return nil, fmt.Errorf("branch must be a string or a list, got %s", branch)
+	}
+	args := make([]string, 0, len(items))
+	for _, item := range items {
+		var s string
+		if err := json.Unmarshal(item, &s); err == nil {
+			args = append(args, s)
+			continue
+		}
+		if !strings.HasPrefix(strings.TrimSpace(string(item)), "{") {
+			return nil, fmt.Errorf("branch items must be strings or IfPresent objects, got %s", item)
+		}
+		args = append(args, string(item))
+	}
+	return args, nil
+}
@@ -0,0 +1,158 @@
+package component
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_resolvePlaceholders(t *testing.T) {
+	replacements := map[string]string{
+		`{{$.inputs.parameters['lr']}}`:   "0.01",
+		`{{$.inputs.parameters['name']}}`: "my-model",
+		`{{$.inputs.artifacts['d'].uri}}`: "my-bucket/dataset",
+	}
+
+	tests := []struct {
+		name    string
+		s       string
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "No placeholders",
+			s:    "--verbose",
+			want: "--verbose",
+		},
+		{
+			name: "Whole argument",
+			s:    `{{$.inputs.parameters['lr']}}`,
+			want: "0.01",
+		},
+		{
+			name: "Embedded in flag",
+			s:    `--lr={{$.inputs.parameters['lr']}}`,
+			want: "--lr=0.01",
+		},
+		{
+			name: "Embedded in URI",
+			s:    `gs://{{$.inputs.artifacts['d'].uri}}/part`,
+			want: "gs://my-bucket/dataset/part",
+		},
+		{
+			name: "Multiple placeholders",
+			s:    `{{$.inputs.parameters['name']}}-{{$.inputs.parameters['lr']}}.bin`,
+			want: "my-model-0.01.bin",
+		},
+		{
+			name: "Other braces are left alone",
+			s:    `{"lr": {{$.inputs.parameters['lr']}}}`,
+			want: `{"lr": 0.01}`,
+		},
+		{
+			name:    "Unresolved placeholder",
+			s:       `--epochs={{$.inputs.parameters['epochs']}}`,
+			wantErr: true,
+		},
+		{
+			name:    "Unterminated placeholder",
+			s:       `--lr={{$.inputs.parameters['lr']`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolvePlaceholders(tt.s, replacements)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("resolvePlaceholders() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("resolvePlaceholders() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_resolveCommand(t *testing.T) {
+	replacements := map[string]string{
+		`{{$.inputs.parameters['script']}}`: "train.py",
+		`{{$.inputs.parameters['lr']}}`:     "0.01",
+	}
+
+	cmd, args, err := resolveCommand(
+		"/bin/{{$.inputs.parameters['script']}}",
+		[]string{"--lr={{$.inputs.parameters['lr']}}", "--out", "/tmp/out"},
+		replacements)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cmd != "/bin/train.py" {
+		t.Errorf("resolveCommand() cmd = %q, want %q", cmd, "/bin/train.py")
+	}
+	if diff := cmp.Diff([]string{"--lr=0.01", "--out", "/tmp/out"}, args); diff != "" {
+		t.Errorf("resolveCommand() args mismatch (-want +got):\n%s", diff)
+	}
+
+	if _, _, err := resolveCommand("python", []string{"{{$.inputs.parameters['a']}}", "{{$.inputs.parameters['b']}}"}, replacements); err == nil {
+		t.Errorf("resolveCommand() with unresolved placeholders succeeded, want error")
+	}
+}
+
+func Test_expandConditionals(t *testing.T) {
+	present := func(name string) bool { return name == "lr" }
+
+	tests := []struct {
+		name    string
+		args    []string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "Present input uses Then",
+			args: []string{"train", `{"IfPresent": {"InputName": "lr", "Then": ["--lr", "{{$.inputs.parameters['lr']}}"], "Else": ["--default-lr"]}}`},
+			want: []string{"train", "--lr", "{{$.inputs.parameters['lr']}}"},
+		},
+		{
+			name: "Absent input uses Else",
+			args: []string{`{"IfPresent": {"InputName": "seed", "Then": ["--seed", "{{$.inputs.parameters['seed']}}"], "Else": "--random-seed"}}`},
+			want: []string{"--random-seed"},
+		},
+		{
+			name: "Absent input without Else drops the group",
+			args: []string{"train", `{"IfPresent": {"InputName": "seed", "Then": ["--seed", "1"]}}`, "--verbose"},
+			want: []string{"train", "--verbose"},
+		},
+		{
+			name: "Nested constructs",
+			args: []string{`{"IfPresent": {"InputName": "lr", "Then": ["--lr", {"IfPresent": {"InputName": "seed", "Then": ["--seed"]}}]}}`},
+			want: []string{"--lr"},
+		},
+		{
+			name: "Ordinary JSON arguments are kept",
+			args: []string{`{"learning_rate": 0.1}`},
+			want: []string{`{"learning_rate": 0.1}`},
+		},
+		{
+			name:    "Missing InputName",
+			args:    []string{`{"IfPresent": {"Then": ["--lr"]}}`},
+			wantErr: true,
+		},
+		{
+			name:    "Unknown field",
+			args:    []string{`{"IfPresent": {"InputName": "lr", "Than": ["--lr"]}}`},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := expandConditionals(tt.args, present)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expandConditionals() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("expandConditionals() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
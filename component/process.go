@@ -0,0 +1,158 @@
+package component
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// DefaultTerminationGracePeriod is how long the user command may take to exit
+// after it was asked to terminate, before it is killed.
+const DefaultTerminationGracePeriod = 10 * time.Second
+
+// terminationSignals are the signals that make the launcher terminate the user
+// command.
+var terminationSignals = []os.Signal{syscall.SIGTERM, os.Interrupt}
+
+type receivedSignalKey struct{}
+
+type receivedSignal struct {
+	mu  sync.Mutex
+	sig os.Signal
+}
+
+// notifyTermination returns a copy of parent that is canceled when the
+// launcher receives one of terminationSignals. The signal is remembered so
+// that it can be forwarded to the user command. stop must be called to
+// restore the default signal handling.
+func notifyTermination(parent context.Context) (ctx context.Context, stop func()) {
+	rs := &receivedSignal{}
+	ctx, cancel := context.WithCancel(context.WithValue(parent, receivedSignalKey{}, rs))
+
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, terminationSignals...)
+	go func() {
+		select {
+		case sig := <-ch:
+			glog.Infof("Received %v, terminating", sig)
+			rs.mu.Lock()
+			rs.sig = sig
+			rs.mu.Unlock()
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, func() {
+		signal.Stop(ch)
+		cancel()
+	}
+}
+
+// terminationSignal returns the signal to forward to the user command once ctx
+// is done: the signal the launcher received, or SIGTERM otherwise.
+func terminationSignal(ctx context.Context) os.Signal {
+	if rs, ok := ctx.Value(receivedSignalKey{}).(*receivedSignal); ok {
+		rs.mu.Lock()
+		defer rs.mu.Unlock()
+		if rs.sig != nil {
+			return rs.sig
+		}
+	}
+	return syscall.SIGTERM
+}
+
+// canceledError is returned when the launcher stopped, and terminated the user
+// command if it was running, because ctx was done.
+type canceledError struct {
+	sig os.Signal
+	// err is the result of waiting for the command, if it failed.
+	err error
+}
+
+func (e *canceledError) Error() string {
+	if e.err == nil {
+		return fmt.Sprintf("Terminated by %v", e.sig)
+	}
+	return fmt.Sprintf("Terminated by %v: %v", e.sig, e.err)
+}
+
+func (e *canceledError) Unwrap() error {
+	return e.err
+}
+
+// IsCanceled reports whether err was returned because the launcher was asked
+// to terminate.
+func IsCanceled(err error) bool {
+	var ce *canceledError
+	return errors.As(err, &ce)
+}
+
+// UserCommandExitCode returns the status the user command exited with when err
+// is caused by the user command failing. As in shells, a command killed by
+// signal N has status 128+N. ok is false if the user command did not exit,
+// e.g. because it never ran.
+func UserCommandExitCode(err error) (code int, ok bool) {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return 0, false
+	}
+	if ws, ok := exitErr.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		return 128 + int(ws.Signal()), true
+	}
+	return exitErr.ExitCode(), true
+}
+
+// runCommand runs c in its own process group. When ctx is done, the
+// termination signal is forwarded to the whole group, so that subprocesses of
+// the user command are terminated too. If the command has not exited after
+// grace, the group is killed.
+func runCommand(ctx context.Context, c *exec.Cmd, grace time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return &canceledError{sig: terminationSignal(ctx), err: err}
+	}
+
+	setProcessGroup(c)
+	if err := c.Start(); err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Wait()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+	}
+
+	sig := terminationSignal(ctx)
+	glog.Infof("Forwarding %v to the user command, killing it after %v", sig, grace)
+	if err := signalProcessGroup(c, sig); err != nil {
+		glog.Warningf("Failed to signal the user command: %v", err)
+	}
+
+	timer := time.NewTimer(grace)
+	defer timer.Stop()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-timer.C:
+		glog.Warningf("User command did not exit within %v, killing it", grace)
+		if kerr := signalProcessGroup(c, os.Kill); kerr != nil {
+			glog.Warningf("Failed to kill the user command: %v", kerr)
+		}
+		err = <-done
+	}
+	return &canceledError{sig: sig, err: err}
+}
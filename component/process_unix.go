@@ -0,0 +1,26 @@
+//go:build !windows
+// +build !windows
+
+package component
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+func setProcessGroup(c *exec.Cmd) {
+	if c.SysProcAttr == nil {
+		c.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	c.SysProcAttr.Setpgid = true
+}
+
+// signalProcessGroup sends sig to the process group led by c.
+func signalProcessGroup(c *exec.Cmd, sig os.Signal) error {
+	s, ok := sig.(syscall.Signal)
+	if !ok {
+		return c.Process.Signal(sig)
+	}
+	return syscall.Kill(-c.Process.Pid, s)
+}
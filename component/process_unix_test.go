@@ -0,0 +1,109 @@
+//go:build !windows
+// +build !windows
+
+package component
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func Test_runCommand(t *testing.T) {
+	tests := []struct {
+		name         string
+		script       string
+		cancel       bool
+		wantCanceled bool
+		wantExitCode int
+	}{
+		{
+			name:         "Completes without cancellation",
+			script:       "exit 3",
+			wantExitCode: 3,
+		},
+		{
+			name: "Forwards SIGTERM to the process group",
+			// The background sleep is part of the group and must not keep the
+			// command alive.
+			script:       `trap "exit 7" TERM; sleep 30 & wait`,
+			cancel:       true,
+			wantCanceled: true,
+			wantExitCode: 7,
+		},
+		{
+			name:         "Kills commands that ignore SIGTERM",
+			script:       `trap "" TERM; sleep 30 & wait; sleep 30`,
+			cancel:       true,
+			wantCanceled: true,
+			wantExitCode: -1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.cancel {
+				time.AfterFunc(200*time.Millisecond, cancel)
+			}
+
+			start := time.Now()
+			err := runCommand(ctx, exec.Command("sh", "-c", tt.script), 500*time.Millisecond)
+			if elapsed := time.Since(start); elapsed > 10*time.Second {
+				t.Errorf("runCommand() took %v", elapsed)
+			}
+
+			var ce *canceledError
+			if got := errors.As(err, &ce); got != tt.wantCanceled {
+				t.Errorf("runCommand() error = %v, want canceled: %v", err, tt.wantCanceled)
+			}
+			if ce != nil && ce.sig != syscall.SIGTERM {
+				t.Errorf("Forwarded signal = %v, want %v", ce.sig, syscall.SIGTERM)
+			}
+			var exitErr *exec.ExitError
+			if !errors.As(err, &exitErr) {
+				t.Fatalf("runCommand() error = %v, want an exit error", err)
+			}
+			if got := exitErr.ExitCode(); got != tt.wantExitCode {
+				t.Errorf("Exit code = %d, want %d", got, tt.wantExitCode)
+			}
+		})
+	}
+}
+
+func TestUserCommandExitCode(t *testing.T) {
+	run := func(script string) error {
+		return fmt.Errorf("User command failed: %w", exec.Command("sh", "-c", script).Run())
+	}
+	tests := []struct {
+		name     string
+		err      error
+		wantCode int
+		wantOK   bool
+	}{
+		{name: "Exit status", err: run("exit 3"), wantCode: 3, wantOK: true},
+		{name: "Killed by signal", err: run("kill -KILL $$"), wantCode: 137, wantOK: true},
+		{name: "Canceled while running", err: &canceledError{sig: syscall.SIGTERM, err: run("kill -TERM $$")}, wantCode: 143, wantOK: true},
+		{name: "Canceled before running", err: &canceledError{sig: syscall.SIGTERM, err: context.Canceled}, wantOK: false},
+		{name: "Launcher error", err: errors.New("Failed to upload"), wantOK: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, ok := UserCommandExitCode(tt.err)
+			if code != tt.wantCode || ok != tt.wantOK {
+				t.Errorf("UserCommandExitCode(%v) = %d, %v, want %d, %v", tt.err, code, ok, tt.wantCode, tt.wantOK)
+			}
+		})
+	}
+
+	if !IsCanceled(fmt.Errorf("wrapped: %w", &canceledError{sig: syscall.SIGTERM})) {
+		t.Error("IsCanceled() = false for a wrapped canceledError")
+	}
+	if IsCanceled(run("exit 1")) {
+		t.Error("IsCanceled() = true for a failed user command")
+	}
+}
@@ -0,0 +1,17 @@
+//go:build windows
+// +build windows
+
+package component
+
+import (
+	"os"
+	"os/exec"
+)
+
+func setProcessGroup(c *exec.Cmd) {}
+
+// signalProcessGroup kills the process started by c. Windows has neither
+// process groups nor SIGTERM, so the command cannot exit gracefully.
+func signalProcessGroup(c *exec.Cmd, sig os.Signal) error {
+	return c.Process.Kill()
+}
@@ -0,0 +1,164 @@
+package component
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+
+	"github.com/golang/glog"
+	"github.com/neuromage/kfp-launcher/metadata"
+	pb "github.com/neuromage/kfp-launcher/third_party/ml_metadata"
+	"github.com/neuromage/kfp-launcher/third_party/pipeline_spec"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// RunResolver resolves the output artifacts of the task by querying MLMD
+// instead of running a user command, so that downstream tasks can consume
+// artifacts produced earlier, e.g. the latest LIVE kfp.Model of a pipeline.
+// See metadata.Client.QueryArtifacts for the query syntax.
+//
+// The resolved artifacts are recorded as INPUT events of a resolver execution
+// and written to the output files in runtime info: a single artifact as for
+// produced outputs, or a JSON array of artifacts if the query limit is
+// greater than one. If the launcher is asked to terminate, the returned error
+// satisfies IsCanceled.
+func (l *Launcher) RunResolver(ctx context.Context, spec *pipeline_spec.PipelineDeploymentConfig_ResolverSpec) error {
+	ctx, stop := notifyTermination(ctx)
+	defer stop()
+
+	err := l.runResolver(ctx, spec)
+	if err != nil && ctx.Err() != nil && !IsCanceled(err) {
+		err = &canceledError{sig: terminationSignal(ctx), err: err}
+	}
+	return err
+}
+
+func (l *Launcher) runResolver(ctx context.Context, spec *pipeline_spec.PipelineDeploymentConfig_ResolverSpec) error {
+	if err := l.validateResolverSpec(spec); err != nil {
+		return err
+	}
+
+	pipeline, err := l.metadata.GetPipeline(ctx, l.options.PipelineName, l.options.PipelineRunID)
+	if err != nil {
+		return err
+	}
+
+	ecfg := &metadata.ExecutionConfig{
+		InputParameters: &metadata.Parameters{
+			IntParameters:    make(map[string]int64),
+			StringParameters: make(map[string]string),
+			DoubleParameters: make(map[string]float64),
+		},
+		RetryAttempt: l.options.RetryAttempt,
+		Type:         metadata.ResolverExecution,
+	}
+	resolved := make(map[string][]*pb.Artifact)
+	for n, q := range spec.GetOutputArtifactQueries() {
+		artifacts, err := l.metadata.QueryArtifacts(ctx, pipeline, q.GetFilter(), int(q.GetLimit()))
+		if err != nil {
+			return fmt.Errorf("Failed to resolve output artifact %q: %v", n, err)
+		}
+		if len(artifacts) == 0 {
+			return fmt.Errorf("No artifacts match the query for output artifact %q: %q", n, q.GetFilter())
+		}
+		resolved[n] = artifacts
+		for _, a := range artifacts {
+			ecfg.InputArtifacts = append(ecfg.InputArtifacts, &metadata.InputArtifact{Name: n, Artifact: a})
+		}
+	}
+
+	execution, err := l.metadata.CreateExecution(ctx, pipeline, l.options.TaskName, l.options.PipelineTaskID, l.options.ContainerImage, ecfg)
+	if err != nil {
+		return err
+	}
+
+	err = l.writeResolvedArtifacts(spec, resolved)
+	if err == nil {
+		outputParameters := &metadata.Parameters{
+			IntParameters:    make(map[string]int64),
+			StringParameters: make(map[string]string),
+			DoubleParameters: make(map[string]float64),
+		}
+		err = l.metadata.PublishExecution(ctx, execution, outputParameters, nil)
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			l.cancelExecution(execution, err)
+		} else if ferr := l.metadata.FailExecution(ctx, execution, executionFailure(err)); ferr != nil {
+			glog.Errorf("Failed to record execution failure in MLMD: %v", ferr)
+		}
+		return err
+	}
+	return nil
+}
+
+// validateResolverSpec checks that spec has a query for exactly the output
+// artifacts in runtime info.
+func (l *Launcher) validateResolverSpec(spec *pipeline_spec.PipelineDeploymentConfig_ResolverSpec) error {
+	if len(spec.GetOutputArtifactQueries()) == 0 {
+		return fmt.Errorf("Resolver spec must have at least one output artifact query")
+	}
+	if len(l.runtimeInfo.OutputParameters) > 0 {
+		return fmt.Errorf("A resolver has no output parameters, got %d", len(l.runtimeInfo.OutputParameters))
+	}
+	var problems []string
+	for n := range spec.GetOutputArtifactQueries() {
+		if _, ok := l.runtimeInfo.OutputArtifacts[n]; !ok {
+			problems = append(problems, fmt.Sprintf("query for unknown output artifact %q", n))
+		}
+	}
+	for n := range l.runtimeInfo.OutputArtifacts {
+		if _, ok := spec.GetOutputArtifactQueries()[n]; !ok {
+			problems = append(problems, fmt.Sprintf("no query for output artifact %q", n))
+		}
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("Resolver spec does not match runtime info: %v", problems)
+	}
+	return nil
+}
+
+// writeResolvedArtifacts writes the resolved artifacts to their output files.
+func (l *Launcher) writeResolvedArtifacts(spec *pipeline_spec.PipelineDeploymentConfig_ResolverSpec, resolved map[string][]*pb.Artifact) error {
+	for n, artifacts := range resolved {
+		var b []byte
+		var err error
+		if spec.GetOutputArtifactQueries()[n].GetLimit() > 1 {
+			b, err = marshalArtifactList(artifacts)
+		} else {
+			b, err = protojson.Marshal(artifacts[0])
+		}
+		if err != nil {
+			return err
+		}
+
+		p := l.runtimeInfo.OutputArtifacts[n].FileOutputPath
+		if err := os.MkdirAll(path.Dir(p), 0755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(p, b, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// marshalArtifactList encodes artifacts as a JSON array of MLMD artifacts.
+func marshalArtifactList(artifacts []*pb.Artifact) ([]byte, error) {
+	b := []byte{'['}
+	for i, a := range artifacts {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		ab, err := protojson.Marshal(a)
+		if err != nil {
+			return nil, err
+		}
+		b = append(b, ab...)
+	}
+	return append(b, ']'), nil
+}
@@ -0,0 +1,123 @@
+package component
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	pb "github.com/neuromage/kfp-launcher/third_party/ml_metadata"
+	"github.com/neuromage/kfp-launcher/third_party/pipeline_spec"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestValidateResolverSpec(t *testing.T) {
+	query := &pipeline_spec.PipelineDeploymentConfig_ResolverSpec_ArtifactQuerySpec{Filter: "state=LIVE"}
+	l := &Launcher{runtimeInfo: &runtimeInfo{
+		OutputArtifacts: map[string]*outputArtifact{
+			"model":   {ArtifactSchema: "title: kfp.Model\n"},
+			"dataset": {ArtifactSchema: "title: kfp.Dataset\n"},
+		},
+	}}
+
+	spec := &pipeline_spec.PipelineDeploymentConfig_ResolverSpec{
+		OutputArtifactQueries: map[string]*pipeline_spec.PipelineDeploymentConfig_ResolverSpec_ArtifactQuerySpec{
+			"model":   query,
+			"dataset": query,
+		},
+	}
+	if err := l.validateResolverSpec(spec); err != nil {
+		t.Errorf("validateResolverSpec() error = %v", err)
+	}
+
+	spec.OutputArtifactQueries = map[string]*pipeline_spec.PipelineDeploymentConfig_ResolverSpec_ArtifactQuerySpec{
+		"model":   query,
+		"metrics": query,
+	}
+	err := l.validateResolverSpec(spec)
+	if err == nil || !strings.Contains(err.Error(), `no query for output artifact "dataset"`) || !strings.Contains(err.Error(), `query for unknown output artifact "metrics"`) {
+		t.Errorf("validateResolverSpec() error = %v, want both mismatches", err)
+	}
+}
+
+func TestMarshalArtifactList(t *testing.T) {
+	b, err := marshalArtifactList([]*pb.Artifact{
+		{Id: proto.Int64(1), Uri: proto.String("gs://b/1")},
+		{Id: proto.Int64(2), Uri: proto.String("gs://b/2")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []map[string]interface{}
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatalf("marshalArtifactList() = %s, not a JSON array: %v", b, err)
+	}
+	if len(got) != 2 || got[1]["uri"] != "gs://b/2" {
+		t.Errorf("marshalArtifactList() = %s", b)
+	}
+}
+
+func TestRunResolver_Canceled(t *testing.T) {
+	env := newTestEnv(t)
+	if err := env.launcher(t, "train", shellTaskRuntimeInfo(t.TempDir())).RunComponent(context.Background(), "sh", shellTaskArgs(`printf 42 > "$0" && printf weights > "$1"`)...); err != nil {
+		t.Fatal(err)
+	}
+
+	// Serve the store again, canceling the launcher when the resolver
+	// publishes its execution, i.e. after the execution was created.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := grpc.NewServer(grpc.UnaryInterceptor(func(rctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if r, ok := req.(*pb.PutExecutionRequest); ok && r.GetExecution().GetLastKnownState() == pb.Execution_COMPLETE {
+			cancel()
+			return nil, status.Error(codes.Canceled, "launcher terminated")
+		}
+		return handler(rctx, req)
+	}))
+	pb.RegisterMetadataStoreServiceServer(s, env.mlmd)
+	go s.Serve(lis)
+	defer s.Stop()
+
+	rt := fmt.Sprintf(`{"OutputArtifacts": {"model": {"ArtifactSchema": "title: kfp.Model\n", "FileOutputPath": %q}}}`, filepath.Join(t.TempDir(), "model"))
+	l, err := NewLauncher(rt, &LauncherOptions{
+		PipelineName:      "my-pipeline",
+		PipelineRunID:     "my-run",
+		PipelineTaskID:    "resolve",
+		PipelineRoot:      "file://" + env.root,
+		TaskName:          "resolve",
+		MLMDServerAddress: "127.0.0.1",
+		MLMDServerPort:    strconv.Itoa(lis.Addr().(*net.TCPAddr).Port),
+		MLMDMaxAttempts:   1,
+		LocalDir:          t.TempDir(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec := &pipeline_spec.PipelineDeploymentConfig_ResolverSpec{
+		OutputArtifactQueries: map[string]*pipeline_spec.PipelineDeploymentConfig_ResolverSpec_ArtifactQuerySpec{
+			"model": {Filter: "artifact_type='kfp.Model'"},
+		},
+	}
+	if err := l.RunResolver(ctx, spec); !IsCanceled(err) {
+		t.Fatalf("RunResolver() error = %v, want canceled", err)
+	}
+
+	executions := env.executions(t)
+	if len(executions) != 2 {
+		t.Fatalf("Got executions %v, want two", executions)
+	}
+	if got := executions[1].GetLastKnownState(); got != pb.Execution_CANCELED {
+		t.Errorf("Resolver execution state = %v, want CANCELED", got)
+	}
+}
@@ -4,54 +4,141 @@ package component
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
 
 	"github.com/davecgh/go-spew/spew"
 	pb "github.com/neuromage/kfp-launcher/third_party/ml_metadata"
 )
 
 type inputParameter struct {
-	ParameterType  string
-	ParameterValue string
+	// ParameterType is one of the types documented on outputParameter.
+	ParameterType string `validate:"required"`
+	// ParameterValue is nil if no value was passed for the input.
+	ParameterValue *string `json:",omitempty"`
+	// Optional inputs may be left without a value. Their placeholders resolve
+	// to DefaultValue, or to the empty string if there is no default.
+	Optional bool `json:",omitempty"`
+	// DefaultValue is used when ParameterValue is nil.
+	DefaultValue *string `json:",omitempty"`
+}
+
+// value returns the parameter value, or the empty string if it has none.
+func (p *inputParameter) value() string {
+	if p.ParameterValue == nil {
+		return ""
+	}
+	return *p.ParameterValue
 }
 
 type inputArtifact struct {
 	// Where to read MLMD artifact. File is passed using Argo artifacts.
-	FileInputPath string
+	// Empty if the artifact was not passed.
+	FileInputPath string `json:",omitempty"`
+	// Optional inputs may be left without an artifact. Their placeholders
+	// resolve to DefaultValue, or to the empty string if there is no default.
+	Optional bool `json:",omitempty"`
+	// DefaultValue is used when FileInputPath is empty.
+	DefaultValue *defaultArtifact `json:",omitempty"`
 
 	// The MLMD artifact.
 	Artifact *pb.Artifact `json:"-"`
 
-	// Generated by launcher.
-	// /tmp/launcher_component_inputs/<name>/data
+	// Generated by launcher. Either a file or, for directory-valued
+	// artifacts, a directory.
+	// <LocalDir>/kfp_launcher_inputs/<name>/data
 	LocalArtifactFilePath string `json:"-"`
 }
 
+// defaultArtifact is an existing artifact that an input artifact defaults to.
+// It is registered in MLMD like an imported artifact.
+type defaultArtifact struct {
+	// URI of the artifact, under the pipeline root.
+	URI string `validate:"required"`
+	// ArtifactSchema is the schema the artifact is registered with.
+	ArtifactSchema string `validate:"required"`
+}
+
 type outputParameter struct {
-	// ParameterType should be one of "INT", "STRING" or "DOUBLE".
-	ParameterType  string
-	FileOutputPath string
+	// ParameterType should be one of "STRING", "INT", "DOUBLE", "BOOLEAN",
+	// "LIST" or "STRUCT". LIST and STRUCT values are JSON.
+	ParameterType  string `validate:"required"`
+	FileOutputPath string `validate:"required"`
 }
 
 type outputArtifact struct {
-	ArtifactSchema string
+	ArtifactSchema string `validate:"required"`
 	// Where to write MLMD artifact.
-	FileOutputPath string
+	FileOutputPath string `validate:"required"`
 
-	// Generated by launcher.
-	// /tmp/launcher_component_outputs/<name>/data
+	// Generated by launcher. The component may write either a file or a
+	// directory here.
+	// <LocalDir>/kfp_launcher_outputs/<name>/data
 	LocalArtifactFilePath string `json:"-"`
-	// Final location of file.
-	// <pipeline_root>/<pipelineName>/<pipelineRunID>/<pipelineTaskID>/data
+	// Generated by launcher. The component may write a JSON object here,
+	// whose fields are recorded as custom properties of the artifact.
+	// <LocalDir>/kfp_launcher_outputs/<name>/metadata.json
+	LocalMetadataFilePath string `json:"-"`
+	// Final location of file, recorded as the artifact URI in MLMD.
+	// <pipeline_root>/<LauncherOptions.OutputURITemplate>, which defaults to
+	// <pipeline_root>/<pipelineName>/<pipelineRunID>/<pipelineTaskID>/<outputName>
 	URIOutputPath string `json:"-"`
 }
 
+// artifactIterator runs the task once per artifact of an input artifact list,
+// like ArtifactIteratorSpec. The input artifact metadata file holds a JSON
+// array of MLMD artifacts, as written by resolvers and iterators.
+type artifactIterator struct {
+	Items struct {
+		// InputArtifact is the name of the input artifact list.
+		InputArtifact string `validate:"required"`
+	} `validate:"required"`
+	// ItemInput is the name of the input artifact holding the current item.
+	// It is provided by the launcher and must not be declared as an input.
+	ItemInput string `validate:"required"`
+	// IndexInput optionally names an INT input parameter holding the
+	// zero-based index of the current item.
+	IndexInput string `json:",omitempty"`
+}
+
+// parameterIterator runs the task once per item of a JSON array, like
+// ParameterIteratorSpec.
+type parameterIterator struct {
+	Items struct {
+		// Raw is a JSON array of items.
+		Raw *string `json:",omitempty"`
+		// InputParameter is the name of a STRING or LIST input parameter
+		// holding a JSON array of items.
+		InputParameter string `json:",omitempty"`
+	} `validate:"required"`
+	// ItemInput is the name of the input parameter holding the current item.
+	// Its type follows the JSON type of the item: STRING, INT, DOUBLE,
+	// BOOLEAN, LIST or STRUCT. It is provided by the launcher and must not be
+	// declared as an input.
+	ItemInput string `validate:"required"`
+	// IndexInput optionally names an INT input parameter holding the
+	// zero-based index of the current item.
+	IndexInput string `json:",omitempty"`
+}
+
+// runtimeInfo describes the inputs and outputs of a task.
+//
+// A task with an iterator runs once per item. Each iteration writes its
+// outputs to their own URIs, and the output files then hold JSON arrays with
+// the outputs of every iteration, in order: MLMD artifacts for output
+// artifacts, and parameter values for output parameters.
 type runtimeInfo struct {
 	InputParameters  map[string]*inputParameter
 	InputArtifacts   map[string]*inputArtifact
 	OutputParameters map[string]*outputParameter
 	OutputArtifacts  map[string]*outputArtifact
+
+	// At most one iterator may be set.
+	ArtifactIterator  *artifactIterator  `json:",omitempty"`
+	ParameterIterator *parameterIterator `json:",omitempty"`
 }
 
+// parseRuntimeInfo validates and decodes runtime info. Every problem found is
+// reported at once, in a *RuntimeInfoError.
 func parseRuntimeInfo(jsonEncoded string) (*runtimeInfo, error) {
 	r := &runtimeInfo{
 		InputParameters:  make(map[string]*inputParameter),
@@ -60,7 +147,13 @@ func parseRuntimeInfo(jsonEncoded string) (*runtimeInfo, error) {
 		OutputArtifacts:  make(map[string]*outputArtifact),
 	}
 
-	if err := json.Unmarshal([]byte(jsonEncoded), r); err != nil {
+	if err := validateRuntimeInfo(jsonEncoded); err != nil {
+		return nil, err
+	}
+
+	d := json.NewDecoder(strings.NewReader(jsonEncoded))
+	d.DisallowUnknownFields()
+	if err := d.Decode(r); err != nil {
 		return nil, err
 	}
 
@@ -68,3 +161,83 @@ func parseRuntimeInfo(jsonEncoded string) (*runtimeInfo, error) {
 
 	return r, nil
 }
+
+// RuntimeInfoBuilder builds the runtime info passed to NewLauncher, for
+// callers that wire the inputs and outputs of tasks themselves, such as the
+// local pipeline runner.
+type RuntimeInfoBuilder struct {
+	rt runtimeInfo
+}
+
+// NewRuntimeInfoBuilder returns a builder of runtime info without inputs,
+// outputs or iterator.
+func NewRuntimeInfoBuilder() *RuntimeInfoBuilder {
+	return &RuntimeInfoBuilder{rt: runtimeInfo{
+		InputParameters:  make(map[string]*inputParameter),
+		InputArtifacts:   make(map[string]*inputArtifact),
+		OutputParameters: make(map[string]*outputParameter),
+		OutputArtifacts:  make(map[string]*outputArtifact),
+	}}
+}
+
+// AddInputParameter declares an input parameter of type parameterType, one
+// of "STRING", "INT", "DOUBLE", "BOOLEAN", "LIST" or "STRUCT", with value in
+// its text form.
+func (b *RuntimeInfoBuilder) AddInputParameter(name, parameterType, value string) {
+	b.rt.InputParameters[name] = &inputParameter{ParameterType: parameterType, ParameterValue: &value}
+}
+
+// AddInputArtifact declares an input artifact whose MLMD artifact is read
+// from the file fileInputPath, as written for an output artifact.
+func (b *RuntimeInfoBuilder) AddInputArtifact(name, fileInputPath string) {
+	b.rt.InputArtifacts[name] = &inputArtifact{FileInputPath: fileInputPath}
+}
+
+// AddOutputParameter declares an output parameter whose value is written to
+// fileOutputPath.
+func (b *RuntimeInfoBuilder) AddOutputParameter(name, parameterType, fileOutputPath string) {
+	b.rt.OutputParameters[name] = &outputParameter{ParameterType: parameterType, FileOutputPath: fileOutputPath}
+}
+
+// AddOutputArtifact declares an output artifact of the given schema, whose
+// MLMD artifact is written to fileOutputPath.
+func (b *RuntimeInfoBuilder) AddOutputArtifact(name, artifactSchema, fileOutputPath string) {
+	b.rt.OutputArtifacts[name] = &outputArtifact{ArtifactSchema: artifactSchema, FileOutputPath: fileOutputPath}
+}
+
+// SetArtifactIterator runs the task once per artifact of the input artifact
+// list items, passing each as the input artifact itemInput.
+func (b *RuntimeInfoBuilder) SetArtifactIterator(items, itemInput string) {
+	it := &artifactIterator{ItemInput: itemInput}
+	it.Items.InputArtifact = items
+	b.rt.ArtifactIterator = it
+}
+
+// SetParameterIterator runs the task once per item of the JSON array in the
+// input parameter items, passing each as the input parameter itemInput.
+func (b *RuntimeInfoBuilder) SetParameterIterator(items, itemInput string) {
+	it := &parameterIterator{ItemInput: itemInput}
+	it.Items.InputParameter = items
+	b.rt.ParameterIterator = it
+}
+
+// SetRawParameterIterator runs the task once per item of raw, a JSON array,
+// passing each as the input parameter itemInput.
+func (b *RuntimeInfoBuilder) SetRawParameterIterator(raw, itemInput string) {
+	it := &parameterIterator{ItemInput: itemInput}
+	it.Items.Raw = &raw
+	b.rt.ParameterIterator = it
+}
+
+// JSON returns the runtime info in the form NewLauncher accepts. Invalid
+// runtime info is reported in a *RuntimeInfoError.
+func (b *RuntimeInfoBuilder) JSON() (string, error) {
+	j, err := json.Marshal(&b.rt)
+	if err != nil {
+		return "", err
+	}
+	if err := validateRuntimeInfo(string(j)); err != nil {
+		return "", err
+	}
+	return string(j), nil
+}
@@ -3,11 +3,13 @@ package component
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
+	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/testing/protocmp"
 )
 
@@ -32,7 +34,7 @@ func Test_parseRuntimeInfo(t *testing.T) {
 				InputParameters: map[string]*inputParameter{
 					"my_param": {
 						ParameterType:  "INT",
-						ParameterValue: "123",
+						ParameterValue: proto.String("123"),
 					},
 				},
 			},
@@ -113,3 +115,39 @@ func Test_parseRuntimeInfo(t *testing.T) {
 	// 	t.Fatal(err)
 	// }
 }
+
+func TestRuntimeInfoBuilder(t *testing.T) {
+	b := NewRuntimeInfoBuilder()
+	b.AddInputParameter("epochs", "INT", "3")
+	b.AddInputArtifact("dataset", "/work/make-dataset/dataset")
+	b.AddOutputParameter("accuracy", "DOUBLE", "/work/train/accuracy")
+	b.AddOutputArtifact("model", "title: kfp.Model\n", "/work/train/model")
+	b.SetRawParameterIterator("[0.1, 0.01]", "learning_rate")
+
+	j, err := b.JSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := parseRuntimeInfo(j)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &runtimeInfo{
+		InputParameters:   map[string]*inputParameter{"epochs": {ParameterType: "INT", ParameterValue: proto.String("3")}},
+		InputArtifacts:    map[string]*inputArtifact{"dataset": {FileInputPath: "/work/make-dataset/dataset"}},
+		OutputParameters:  map[string]*outputParameter{"accuracy": {ParameterType: "DOUBLE", FileOutputPath: "/work/train/accuracy"}},
+		OutputArtifacts:   map[string]*outputArtifact{"model": {ArtifactSchema: "title: kfp.Model\n", FileOutputPath: "/work/train/model"}},
+		ParameterIterator: &parameterIterator{ItemInput: "learning_rate"},
+	}
+	want.ParameterIterator.Items.Raw = proto.String("[0.1, 0.01]")
+	if diff := cmp.Diff(want, got, protocmp.Transform()); diff != "" {
+		t.Errorf("Built runtime info mismatch (-want +got):\n%s", diff)
+	}
+
+	// Iterating over an undeclared input is reported like in parseRuntimeInfo.
+	b.SetParameterIterator("items", "item")
+	var rie *RuntimeInfoError
+	if _, err := b.JSON(); !errors.As(err, &rie) {
+		t.Errorf("JSON() of an iterator over an undeclared input error = %v, want a *RuntimeInfoError", err)
+	}
+}
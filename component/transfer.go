@@ -0,0 +1,410 @@
+package component
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/neuromage/kfp-launcher/storage"
+)
+
+// Defaults for LauncherOptions.TransferConcurrency and TransferPartSize.
+const (
+	DefaultTransferConcurrency = 8
+	DefaultTransferPartSize    = 64 << 20
+)
+
+// transferOptions bound artifact transfers.
+type transferOptions struct {
+	// concurrency is the maximum number of objects, or parts of objects,
+	// copied at once.
+	concurrency int
+	// partSize is the size of the ranges large objects are downloaded in, in
+	// parallel, when the store supports ranged downloads. It is also the part
+	// size of multipart uploads, which is configured on the store itself.
+	partSize int64
+}
+
+// emptyDirectoryMarker names the object recording an empty directory
+// artifact.
+const emptyDirectoryMarker = ".kfp_empty_directory"
+
+// artifactTransfer copies the artifact, or the single object, stored at key
+// from or to localPath.
+type artifactTransfer struct {
+	key       string
+	localPath string
+}
+
+// transferErrors are the failures of several transfers.
+type transferErrors []error
+
+func (e transferErrors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+	return fmt.Sprintf("%d transfers failed:\n\t%s", len(e), strings.Join(msgs, "\n\t"))
+}
+
+// forEachParallel calls fn for 0 <= i < n, running at most concurrency calls at
+// once. The first failure cancels the context passed to the other calls and
+// stops new ones from starting. It returns the error of ctx if ctx is done,
+// the only failure, or transferErrors listing every failure other than those
+// caused by the cancellation.
+func forEachParallel(ctx context.Context, n, concurrency int, fn func(ctx context.Context, i int) error) error {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	workCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+	)
+	sem := make(chan struct{}, concurrency)
+	for i := 0; i < n; i++ {
+		select {
+		case sem <- struct{}{}:
+		case <-workCtx.Done():
+		}
+		if workCtx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if err := fn(workCtx, i); err != nil {
+				mu.Lock()
+				errs = append(errs, err)
+				mu.Unlock()
+				cancel()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	var failures transferErrors
+	for _, err := range errs {
+		if !errors.Is(err, context.Canceled) {
+			failures = append(failures, err)
+		}
+	}
+	switch {
+	case len(failures) == 1:
+		return failures[0]
+	case len(failures) > 1:
+		return failures
+	case len(errs) > 0:
+		return errs[0]
+	}
+	return nil
+}
+
+// downloadArtifacts copies artifacts to local storage.
+//
+// If an object exists at the key of an artifact, the artifact is a single file
+// and is written to its localPath. Otherwise, every object under "<key>/" is
+// downloaded into the directory localPath, keeping paths relative to key.
+// Object stores have no notion of empty directories, so an empty directory is
+// only materialized if it was recorded with a marker object, see
+// uploadArtifacts. An artifact without any objects is an error wrapping
+// storage.ErrNotExist.
+//
+// Objects are downloaded in parallel, and objects larger than the part size
+// are downloaded in ranges if the store supports it. On failure, the local
+// files and directories created so far are removed.
+func downloadArtifacts(ctx context.Context, store storage.ArtifactStore, artifacts []artifactTransfer, opts transferOptions) error {
+	dirs := &createdDirs{}
+	var files []artifactTransfer
+	var err error
+	for _, a := range artifacts {
+		var f []artifactTransfer
+		if f, err = listArtifactObjects(ctx, store, a, dirs); err != nil {
+			break
+		}
+		files = append(files, f...)
+	}
+
+	// Create every file up front, so that ranges can be written concurrently
+	// and so that all of them can be removed on failure.
+	parts := make([][]downloadPart, len(files))
+	if err == nil {
+		err = forEachParallel(ctx, len(files), opts.concurrency, func(ctx context.Context, i int) error {
+			p, err := prepareDownload(ctx, store, files[i], opts.partSize, dirs)
+			parts[i] = p
+			return err
+		})
+	}
+	if err == nil {
+		var all []downloadPart
+		for _, p := range parts {
+			all = append(all, p...)
+		}
+		err = forEachParallel(ctx, len(all), opts.concurrency, func(ctx context.Context, i int) error {
+			return all[i].download(ctx, store)
+		})
+	}
+	if err != nil {
+		// Files that were not created yet are simply not found.
+		for _, f := range files {
+			os.Remove(f.localPath)
+		}
+		dirs.remove()
+		return err
+	}
+	return nil
+}
+
+// listArtifactObjects returns the objects making up artifact a, creating the
+// directory of directory artifacts.
+func listArtifactObjects(ctx context.Context, store storage.ArtifactStore, a artifactTransfer, dirs *createdDirs) ([]artifactTransfer, error) {
+	exists, err := store.Exists(ctx, a.key)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return []artifactTransfer{{key: a.key, localPath: a.localPath}}, nil
+	}
+
+	dirPrefix := strings.TrimSuffix(a.key, "/") + "/"
+	keys, err := store.List(ctx, dirPrefix)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("Artifact %q: %w", a.key, storage.ErrNotExist)
+	}
+	if err := dirs.mkdirAll(a.localPath); err != nil {
+		return nil, err
+	}
+	var files []artifactTransfer
+	for _, k := range keys {
+		rel := strings.TrimPrefix(k, dirPrefix)
+		if len(rel) == 0 || strings.HasSuffix(rel, "/") || rel == emptyDirectoryMarker {
+			// Directory marker objects, ours or those created by some tools.
+			continue
+		}
+		local := filepath.Join(a.localPath, filepath.FromSlash(rel))
+		if !strings.HasPrefix(local, filepath.Clean(a.localPath)+string(filepath.Separator)) {
+			return nil, fmt.Errorf("Object key %q escapes artifact directory %q", k, a.localPath)
+		}
+		files = append(files, artifactTransfer{key: k, localPath: local})
+	}
+	return files, nil
+}
+
+// createdDirs records the directories created by a download, so that they
+// can be removed if it fails.
+type createdDirs struct {
+	mu   sync.Mutex
+	dirs []string
+}
+
+// mkdirAll creates dir and its missing parents, like os.MkdirAll, and records
+// the directories it creates.
+func (c *createdDirs) mkdirAll(dir string) error {
+	var missing []string
+	for d := filepath.Clean(dir); ; d = filepath.Dir(d) {
+		if _, err := os.Stat(d); !os.IsNotExist(err) {
+			break
+		}
+		missing = append(missing, d)
+		if filepath.Dir(d) == d {
+			break
+		}
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.dirs = append(c.dirs, missing...)
+	return nil
+}
+
+// remove removes the recorded directories that are empty, children first.
+func (c *createdDirs) remove() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	sort.Sort(sort.Reverse(sort.StringSlice(c.dirs)))
+	for _, d := range c.dirs {
+		os.Remove(d)
+	}
+}
+
+// artifactExists reports whether an artifact, a single object or a
+// directory, is stored at key.
+func artifactExists(ctx context.Context, store storage.ArtifactStore, key string) (bool, error) {
+	exists, err := store.Exists(ctx, key)
+	if err != nil || exists {
+		return exists, err
+	}
+	keys, err := store.List(ctx, strings.TrimSuffix(key, "/")+"/")
+	return len(keys) > 0, err
+}
+
+// downloadPart is a range of an object to download into a local file. A
+// negative length downloads the whole object.
+type downloadPart struct {
+	file           artifactTransfer
+	offset, length int64
+}
+
+// prepareDownload creates the local file of f and splits f into parts.
+func prepareDownload(ctx context.Context, store storage.ArtifactStore, f artifactTransfer, partSize int64, dirs *createdDirs) ([]downloadPart, error) {
+	whole := []downloadPart{{file: f, length: -1}}
+	if err := dirs.mkdirAll(filepath.Dir(f.localPath)); err != nil {
+		return nil, err
+	}
+	w, err := os.Create(f.localPath)
+	if err != nil {
+		return nil, err
+	}
+	defer w.Close()
+
+	if _, ok := store.(storage.RangeDownloader); !ok || partSize <= 0 {
+		return whole, nil
+	}
+	attrs, err := store.Stat(ctx, f.key)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to download %q to %q: %w", f.key, f.localPath, err)
+	}
+	if attrs.Size <= partSize {
+		return whole, nil
+	}
+	if err := w.Truncate(attrs.Size); err != nil {
+		return nil, err
+	}
+	var parts []downloadPart
+	for offset := int64(0); offset < attrs.Size; offset += partSize {
+		length := partSize
+		if offset+length > attrs.Size {
+			length = attrs.Size - offset
+		}
+		parts = append(parts, downloadPart{file: f, offset: offset, length: length})
+	}
+	return parts, nil
+}
+
+func (p downloadPart) download(ctx context.Context, store storage.ArtifactStore) error {
+	w, err := os.OpenFile(p.file.localPath, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	if p.length < 0 {
+		err = store.Download(ctx, p.file.key, w)
+	} else if _, err = w.Seek(p.offset, io.SeekStart); err == nil {
+		err = store.(storage.RangeDownloader).DownloadRange(ctx, p.file.key, p.offset, p.length, w)
+	}
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to download %q to %q: %w", p.file.key, p.file.localPath, err)
+	}
+	return nil
+}
+
+// uploadArtifacts copies artifacts from local storage.
+//
+// A regular file is uploaded as the single object key. A directory is walked
+// in lexical order and each file below it is uploaded to "<key>/<relative
+// path>". Symlinks to regular files are uploaded with the contents of their
+// target. Symlinks to directories are rejected, since following them could
+// loop, and empty directories are skipped.
+//
+// A directory without any files is recorded as the single object
+// "<key>/<emptyDirectoryMarker>", so that downloads can tell it apart from a
+// missing artifact.
+//
+// Files are uploaded in parallel. Large files are split into parts by the
+// store, according to its configured part size.
+func uploadArtifacts(ctx context.Context, store storage.ArtifactStore, artifacts []artifactTransfer, opts transferOptions) error {
+	var files []artifactTransfer
+	var emptyDirs []string
+	for _, a := range artifacts {
+		f, err := listArtifactFiles(a)
+		if err != nil {
+			return err
+		}
+		if len(f) == 0 {
+			emptyDirs = append(emptyDirs, path.Join(a.key, emptyDirectoryMarker))
+		}
+		files = append(files, f...)
+	}
+	return forEachParallel(ctx, len(files)+len(emptyDirs), opts.concurrency, func(ctx context.Context, i int) error {
+		if i >= len(files) {
+			return store.Upload(ctx, emptyDirs[i-len(files)], strings.NewReader(""))
+		}
+		return uploadFile(ctx, store, files[i].localPath, files[i].key)
+	})
+}
+
+// listArtifactFiles returns the files making up artifact a.
+func listArtifactFiles(a artifactTransfer) ([]artifactTransfer, error) {
+	info, err := os.Stat(a.localPath)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []artifactTransfer{{key: a.key, localPath: a.localPath}}, nil
+	}
+
+	var files []artifactTransfer
+	err = filepath.Walk(a.localPath, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == a.localPath {
+			return nil
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			target, err := os.Stat(p)
+			if err != nil {
+				return fmt.Errorf("Failed to resolve symlink %q: %v", p, err)
+			}
+			if target.IsDir() {
+				return fmt.Errorf("Symlink %q points to a directory, which is not supported in artifacts", p)
+			}
+			info = target
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(a.localPath, p)
+		if err != nil {
+			return err
+		}
+		files = append(files, artifactTransfer{key: path.Join(a.key, filepath.ToSlash(rel)), localPath: p})
+		return nil
+	})
+	return files, err
+}
+
+func uploadFile(ctx context.Context, store storage.ArtifactStore, localPath, key string) error {
+	r, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	if err := store.Upload(ctx, key, r); err != nil {
+		return fmt.Errorf("Failed to upload %q to %q: %w", localPath, key, err)
+	}
+	return nil
+}
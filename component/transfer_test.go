@@ -0,0 +1,353 @@
+package component
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/neuromage/kfp-launcher/storage"
+)
+
+var defaultTransferOptions = transferOptions{
+	concurrency: DefaultTransferConcurrency,
+	partSize:    DefaultTransferPartSize,
+}
+
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, contents := range files {
+		p := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func readFiles(t *testing.T, root string) map[string]string {
+	t.Helper()
+	got := make(map[string]string)
+	err := filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		b, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(root, p)
+		got[filepath.ToSlash(rel)] = string(b)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return got
+}
+
+func TestArtifactTransfer_Directory(t *testing.T) {
+	ctx := context.Background()
+	tmp, err := ioutil.TempDir("", "kfp-launcher-transfer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	src := filepath.Join(tmp, "src")
+	writeFiles(t, src, map[string]string{
+		"saved_model.pb":                    "graph",
+		"variables/variables.index":         "index",
+		"variables/variables.data-00000-of": "data",
+	})
+	if err := os.MkdirAll(filepath.Join(src, "assets"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(src, "saved_model.pb"), filepath.Join(src, "link.pb")); err != nil {
+		t.Fatal(err)
+	}
+
+	store := storage.NewMemoryStore()
+	if err := uploadArtifacts(ctx, store, []artifactTransfer{{key: "p/r/t/model", localPath: src}}, defaultTransferOptions); err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := store.List(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantKeys := []string{
+		"p/r/t/model/link.pb",
+		"p/r/t/model/saved_model.pb",
+		"p/r/t/model/variables/variables.data-00000-of",
+		"p/r/t/model/variables/variables.index",
+	}
+	if diff := cmp.Diff(wantKeys, keys); diff != "" {
+		t.Errorf("Uploaded keys mismatch (-want +got):\n%s", diff)
+	}
+
+	dst := filepath.Join(tmp, "dst")
+	if err := downloadArtifacts(ctx, store, []artifactTransfer{{key: "p/r/t/model", localPath: dst}}, defaultTransferOptions); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"link.pb":                           "graph",
+		"saved_model.pb":                    "graph",
+		"variables/variables.index":         "index",
+		"variables/variables.data-00000-of": "data",
+	}
+	if diff := cmp.Diff(want, readFiles(t, dst)); diff != "" {
+		t.Errorf("Downloaded files mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestArtifactTransfer_File(t *testing.T) {
+	ctx := context.Background()
+	tmp, err := ioutil.TempDir("", "kfp-launcher-transfer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	writeFiles(t, tmp, map[string]string{"src/data": "contents"})
+	store := storage.NewMemoryStore()
+	if err := uploadArtifacts(ctx, store, []artifactTransfer{{key: "p/r/t/dataset", localPath: filepath.Join(tmp, "src", "data")}}, defaultTransferOptions); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := filepath.Join(tmp, "dst", "data")
+	if err := downloadArtifacts(ctx, store, []artifactTransfer{{key: "p/r/t/dataset", localPath: dst}}, defaultTransferOptions); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "contents" {
+		t.Errorf("Downloaded file = %q, want %q", b, "contents")
+	}
+}
+
+func TestArtifactTransfer_EmptyArtifact(t *testing.T) {
+	ctx := context.Background()
+	tmp, err := ioutil.TempDir("", "kfp-launcher-transfer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	store := storage.NewMemoryStore()
+	if err := os.MkdirAll(filepath.Join(tmp, "src", "empty_subdir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := uploadArtifacts(ctx, store, []artifactTransfer{{key: "p/r/t/empty", localPath: filepath.Join(tmp, "src")}}, defaultTransferOptions); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := filepath.Join(tmp, "dst")
+	if err := downloadArtifacts(ctx, store, []artifactTransfer{{key: "p/r/t/empty", localPath: dst}}, defaultTransferOptions); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.IsDir() {
+		t.Errorf("Expected empty artifact to be materialized as a directory")
+	}
+	if got := readFiles(t, dst); len(got) != 0 {
+		t.Errorf("Empty artifact materialized with files %v", got)
+	}
+}
+
+func TestArtifactTransfer_MissingArtifact(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "kfp-launcher-transfer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	dst := filepath.Join(tmp, "dst")
+	err = downloadArtifacts(context.Background(), storage.NewMemoryStore(), []artifactTransfer{{key: "p/r/t/missing", localPath: dst}}, defaultTransferOptions)
+	if !errors.Is(err, storage.ErrNotExist) {
+		t.Errorf("downloadArtifacts() of a missing artifact error = %v, want ErrNotExist", err)
+	}
+	if _, err := os.Stat(dst); !os.IsNotExist(err) {
+		t.Errorf("Missing artifact was materialized at %q", dst)
+	}
+}
+
+func TestUploadArtifacts_RejectsDirectorySymlink(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "kfp-launcher-transfer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	src := filepath.Join(tmp, "src")
+	writeFiles(t, src, map[string]string{"sub/file": "contents"})
+	if err := os.Symlink(filepath.Join(src, "sub"), filepath.Join(src, "loop")); err != nil {
+		t.Fatal(err)
+	}
+
+	err = uploadArtifacts(context.Background(), storage.NewMemoryStore(), []artifactTransfer{{key: "p/r/t/out", localPath: src}}, defaultTransferOptions)
+	if err == nil || !strings.Contains(err.Error(), "points to a directory") {
+		t.Errorf("uploadArtifacts() error = %v, want directory symlink error", err)
+	}
+}
+
+func TestDownloadArtifacts_Ranged(t *testing.T) {
+	ctx := context.Background()
+	tmp, err := ioutil.TempDir("", "kfp-launcher-transfer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	store := storage.NewMemoryStore()
+	contents := map[string]string{
+		"p/r/t/large":        strings.Repeat("0123456789", 10) + "tail",
+		"p/r/t/dir/small":    "ab",
+		"p/r/t/dir/sub/even": "abcdef",
+	}
+	for k, c := range contents {
+		if err := store.Upload(ctx, k, strings.NewReader(c)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = downloadArtifacts(ctx, store, []artifactTransfer{
+		{key: "p/r/t/large", localPath: filepath.Join(tmp, "large")},
+		{key: "p/r/t/dir", localPath: filepath.Join(tmp, "dir")},
+	}, transferOptions{concurrency: 4, partSize: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"large":        contents["p/r/t/large"],
+		"dir/small":    "ab",
+		"dir/sub/even": "abcdef",
+	}
+	if diff := cmp.Diff(want, readFiles(t, tmp)); diff != "" {
+		t.Errorf("Downloaded files mismatch (-want +got):\n%s", diff)
+	}
+}
+
+// blockingStore fails downloads of failKey, and blocks other downloads until
+// their context is done.
+type blockingStore struct {
+	*storage.MemoryStore
+	failKey string
+	started chan string
+}
+
+func (s *blockingStore) Download(ctx context.Context, key string, w io.Writer) error {
+	if key == s.failKey {
+		return errors.New("boom")
+	}
+	io.WriteString(w, "partial")
+	s.started <- key
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestDownloadArtifacts_FailureCancelsAndCleansUp(t *testing.T) {
+	tests := []struct {
+		name    string
+		failKey string
+		cancel  bool
+		wantErr func(error) bool
+	}{
+		{
+			name:    "Failure",
+			failKey: "dir/c",
+			wantErr: func(err error) bool {
+				return strings.Contains(err.Error(), "boom") && !errors.Is(err, context.Canceled)
+			},
+		},
+		{
+			name:    "Cancellation",
+			cancel:  true,
+			wantErr: func(err error) bool { return errors.Is(err, context.Canceled) },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			tmp, err := ioutil.TempDir("", "kfp-launcher-transfer")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(tmp)
+
+			store := &blockingStore{MemoryStore: storage.NewMemoryStore(), failKey: tt.failKey, started: make(chan string, 10)}
+			for _, k := range []string{"dir/a", "dir/b", "dir/c"} {
+				if err := store.Upload(ctx, k, strings.NewReader("data")); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.cancel {
+				go func() {
+					<-store.started
+					cancel()
+				}()
+			}
+
+			dst := filepath.Join(tmp, "dir")
+			err = downloadArtifacts(ctx, store, []artifactTransfer{{key: "dir", localPath: dst}}, transferOptions{concurrency: 3})
+			if err == nil || !tt.wantErr(err) {
+				t.Fatalf("downloadArtifacts() error = %v", err)
+			}
+			if _, err := os.Stat(dst); !os.IsNotExist(err) {
+				t.Errorf("Partially downloaded artifact %q was not removed: %v", dst, readFiles(t, dst))
+			}
+		})
+	}
+}
+
+func TestForEachParallel(t *testing.T) {
+	var running, maxRunning int32
+	err := forEachParallel(context.Background(), 20, 3, func(ctx context.Context, i int) error {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			m := atomic.LoadInt32(&maxRunning)
+			if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if maxRunning > 3 {
+		t.Errorf("forEachParallel() ran %d calls at once, want at most 3", maxRunning)
+	}
+
+	// Failures that happen together are all reported.
+	var barrier sync.WaitGroup
+	barrier.Add(2)
+	err = forEachParallel(context.Background(), 2, 2, func(ctx context.Context, i int) error {
+		barrier.Done()
+		barrier.Wait()
+		return fmt.Errorf("failure %d", i)
+	})
+	var errs transferErrors
+	if !errors.As(err, &errs) || len(errs) != 2 {
+		t.Errorf("forEachParallel() error = %v, want both failures", err)
+	}
+}
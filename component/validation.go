@@ -0,0 +1,465 @@
+package component
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// RuntimeInfoError lists every problem found in runtime info.
+type RuntimeInfoError struct {
+	// Problems are of the form "<JSON path>: <message>".
+	Problems []string
+}
+
+func (e *RuntimeInfoError) Error() string {
+	return fmt.Sprintf("Invalid runtime info, found %d problem(s):\n\t%s", len(e.Problems), strings.Join(e.Problems, "\n\t"))
+}
+
+// runtimeInfoValidator collects the problems in runtime info.
+type runtimeInfoValidator struct {
+	problems []string
+}
+
+func (v *runtimeInfoValidator) addf(path, format string, a ...interface{}) {
+	v.problems = append(v.problems, path+": "+fmt.Sprintf(format, a...))
+}
+
+// jsonField is a field of a JSON object, in document order.
+type jsonField struct {
+	name  string
+	value json.RawMessage
+}
+
+// objectFields returns the fields of the JSON object raw, or nil and a problem
+// if raw is not an object. Unlike encoding/json, duplicate fields are kept.
+func (v *runtimeInfoValidator) objectFields(path string, raw json.RawMessage) ([]jsonField, bool) {
+	d := json.NewDecoder(strings.NewReader(string(raw)))
+	if t, err := d.Token(); err != nil || t != json.Delim('{') {
+		v.addf(path, "must be a JSON object, got %s", raw)
+		return nil, false
+	}
+	var fields []jsonField
+	for d.More() {
+		t, err := d.Token()
+		if err != nil {
+			v.addf(path, "%v", err)
+			return nil, false
+		}
+		f := jsonField{name: t.(string)}
+		if err := d.Decode(&f.value); err != nil {
+			v.addf(path, "%v", err)
+			return nil, false
+		}
+		fields = append(fields, f)
+	}
+	return fields, true
+}
+
+// fieldSpec describes a field of a runtime info object.
+type fieldSpec struct {
+	name     string
+	required bool
+	// kind is "string", "bool" or "object".
+	kind string
+}
+
+// fieldSpecs derives the field specs of a runtime info object from v, the
+// struct it decodes into. Like encoding/json, it skips unexported fields and
+// fields tagged `json:"-"`, and takes names from json tags. Fields tagged
+// `validate:"required"` are required.
+func fieldSpecs(v interface{}) []fieldSpec {
+	t := reflect.TypeOf(v)
+	var specs []fieldSpec
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if len(f.PkgPath) > 0 || tag == "-" {
+			continue
+		}
+		spec := fieldSpec{name: f.Name, required: f.Tag.Get("validate") == "required"}
+		if name := strings.Split(tag, ",")[0]; len(name) > 0 {
+			spec.name = name
+		}
+
+		ft := f.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		switch ft.Kind() {
+		case reflect.String:
+			spec.kind = "string"
+		case reflect.Bool:
+			spec.kind = "bool"
+		case reflect.Struct, reflect.Map:
+			spec.kind = "object"
+		default:
+			panic(fmt.Sprintf("Runtime info field %s.%s has unsupported type %v", t.Name(), f.Name, f.Type))
+		}
+		specs = append(specs, spec)
+	}
+	return specs
+}
+
+var (
+	runtimeInfoFields       = fieldSpecs(runtimeInfo{})
+	inputParameterFields    = fieldSpecs(inputParameter{})
+	inputArtifactFields     = fieldSpecs(inputArtifact{})
+	defaultArtifactFields   = fieldSpecs(defaultArtifact{})
+	outputParameterFields   = fieldSpecs(outputParameter{})
+	outputArtifactFields    = fieldSpecs(outputArtifact{})
+	artifactIteratorFields  = fieldSpecs(artifactIterator{})
+	artifactItemsFields     = fieldSpecs(artifactIterator{}.Items)
+	parameterIteratorFields = fieldSpecs(parameterIterator{})
+	parameterItemsFields    = fieldSpecs(parameterIterator{}.Items)
+)
+
+// checkObject checks that raw is an object with only the fields in specs,
+// each of the right kind and at most once. Field names are matched
+// case-insensitively, like encoding/json does. It returns the fields by their
+// name in specs.
+func (v *runtimeInfoValidator) checkObject(path string, raw json.RawMessage, specs []fieldSpec) map[string]jsonField {
+	fields, ok := v.objectFields(path, raw)
+	if !ok {
+		return nil
+	}
+
+	found := make(map[string]jsonField)
+	for _, f := range fields {
+		fieldPath := path + "." + f.name
+		var spec *fieldSpec
+		for i := range specs {
+			if strings.EqualFold(specs[i].name, f.name) {
+				spec = &specs[i]
+			}
+		}
+		if spec == nil {
+			known := make([]string, 0, len(specs))
+			for _, s := range specs {
+				known = append(known, lowerFirst(s.name))
+			}
+			v.addf(fieldPath, "unknown field, expected one of %s", strings.Join(known, ", "))
+			continue
+		}
+		if _, dup := found[spec.name]; dup {
+			v.addf(fieldPath, "duplicate field")
+			continue
+		}
+
+		if string(f.value) == "null" {
+			// encoding/json leaves the field unset, as if it were absent.
+			if spec.required {
+				v.addf(fieldPath, "must not be null")
+			}
+			continue
+		}
+
+		var err error
+		switch spec.kind {
+		case "string":
+			var s string
+			err = json.Unmarshal(f.value, &s)
+		case "bool":
+			var b bool
+			err = json.Unmarshal(f.value, &b)
+		}
+		if err != nil {
+			v.addf(fieldPath, "must be a JSON %s, got %s", spec.kind, f.value)
+			continue
+		}
+		found[spec.name] = f
+	}
+
+	for _, s := range specs {
+		if _, ok := found[s.name]; s.required && !ok {
+			v.addf(path, "missing required field %s", lowerFirst(s.name))
+		}
+	}
+	return found
+}
+
+// checkNamedObjects checks that raw maps unique names to objects, and calls
+// check on each of them.
+func (v *runtimeInfoValidator) checkNamedObjects(path string, raw json.RawMessage, check func(path, name string, raw json.RawMessage)) []string {
+	if string(raw) == "null" {
+		return nil
+	}
+	fields, ok := v.objectFields(path, raw)
+	if !ok {
+		return nil
+	}
+	seen := make(map[string]bool)
+	var names []string
+	for _, f := range fields {
+		namePath := path + "." + f.name
+		if seen[f.name] {
+			v.addf(namePath, "duplicate name")
+			continue
+		}
+		seen[f.name] = true
+		names = append(names, f.name)
+		check(namePath, f.name, f.value)
+	}
+	return names
+}
+
+func stringField(f jsonField) string {
+	var s string
+	json.Unmarshal(f.value, &s)
+	return s
+}
+
+func boolField(f jsonField) bool {
+	var b bool
+	json.Unmarshal(f.value, &b)
+	return b
+}
+
+func lowerFirst(s string) string {
+	return strings.ToLower(s[:1]) + s[1:]
+}
+
+// checkParameterType checks the parameterType field and returns it if valid.
+func (v *runtimeInfoValidator) checkParameterType(path string, fields map[string]jsonField) (string, bool) {
+	f, ok := fields["ParameterType"]
+	if !ok {
+		return "", false
+	}
+	t := stringField(f)
+	switch t {
+	case parameterTypeString, parameterTypeInt, parameterTypeDouble, parameterTypeBoolean, parameterTypeList, parameterTypeStruct:
+		return t, true
+	}
+	v.addf(path+"."+f.name, "unknown parameter type %q, expected one of %s", t,
+		strings.Join([]string{parameterTypeString, parameterTypeInt, parameterTypeDouble, parameterTypeBoolean, parameterTypeList, parameterTypeStruct}, ", "))
+	return "", false
+}
+
+func (v *runtimeInfoValidator) checkInputParameter(path, name string, raw json.RawMessage) {
+	fields := v.checkObject(path, raw, inputParameterFields)
+	if fields == nil {
+		return
+	}
+
+	t, typeOK := v.checkParameterType(path, fields)
+	for _, n := range []string{"ParameterValue", "DefaultValue"} {
+		f, ok := fields[n]
+		if !ok || !typeOK {
+			continue
+		}
+		if _, err := parseParameter(t, stringField(f)); err != nil {
+			v.addf(path+"."+f.name, "invalid %s value: %v", t, err)
+		}
+	}
+
+	_, hasValue := fields["ParameterValue"]
+	_, hasDefault := fields["DefaultValue"]
+	optional, hasOptional := fields["Optional"]
+	if !hasValue && !hasDefault && !(hasOptional && boolField(optional)) {
+		v.addf(path, "missing parameterValue for a required input without defaultValue")
+	}
+}
+
+func (v *runtimeInfoValidator) checkInputArtifact(path, name string, raw json.RawMessage) {
+	fields := v.checkObject(path, raw, inputArtifactFields)
+	if fields == nil {
+		return
+	}
+	d, hasDefault := fields["DefaultValue"]
+	if hasDefault {
+		defaultPath := path + "." + d.name
+		artifact := v.checkObject(defaultPath, d.value, defaultArtifactFields)
+		for _, n := range []string{"URI", "ArtifactSchema"} {
+			f, ok := artifact[n]
+			if !ok {
+				continue
+			}
+			if len(stringField(f)) == 0 {
+				v.addf(defaultPath+"."+f.name, "must not be empty")
+			} else if n == "URI" && !strings.Contains(stringField(f), "://") {
+				v.addf(defaultPath+"."+f.name, "must be a URI, got %q", stringField(f))
+			}
+		}
+	}
+
+	f, hasPath := fields["FileInputPath"]
+	optional, hasOptional := fields["Optional"]
+	if (!hasPath || len(stringField(f)) == 0) && !hasDefault && !(hasOptional && boolField(optional)) {
+		v.addf(path, "missing fileInputPath for a required input artifact without defaultValue")
+	}
+}
+
+func (v *runtimeInfoValidator) checkOutputParameter(path, name string, raw json.RawMessage) {
+	fields := v.checkObject(path, raw, outputParameterFields)
+	if fields == nil {
+		return
+	}
+	v.checkParameterType(path, fields)
+	if f, ok := fields["FileOutputPath"]; ok && len(stringField(f)) == 0 {
+		v.addf(path+"."+f.name, "must not be empty")
+	}
+}
+
+func (v *runtimeInfoValidator) checkOutputArtifact(path, name string, raw json.RawMessage) {
+	if err := validateOutputName(name); err != nil {
+		v.addf(path, "%v", err)
+	}
+	fields := v.checkObject(path, raw, outputArtifactFields)
+	for _, n := range []string{"ArtifactSchema", "FileOutputPath"} {
+		if f, ok := fields[n]; ok && len(stringField(f)) == 0 {
+			v.addf(path+"."+f.name, "must not be empty")
+		}
+	}
+}
+
+// iteratorInputs are the inputs an iterator refers to or provides.
+type iteratorInputs struct {
+	path string
+	// items is the input holding the items, or empty if they are raw.
+	items      string
+	itemInput  string
+	indexInput string
+}
+
+// checkIterator checks an artifactIterator or parameterIterator section. The
+// items of artifact iterators come from an input artifact, while those of
+// parameter iterators come from an input parameter or raw JSON.
+func (v *runtimeInfoValidator) checkIterator(path string, raw json.RawMessage, artifact bool) *iteratorInputs {
+	specs := parameterIteratorFields
+	if artifact {
+		specs = artifactIteratorFields
+	}
+	fields := v.checkObject(path, raw, specs)
+	if fields == nil {
+		return nil
+	}
+	inputs := &iteratorInputs{path: path}
+	if f, ok := fields["ItemInput"]; ok {
+		inputs.itemInput = stringField(f)
+		if len(inputs.itemInput) == 0 {
+			v.addf(path+"."+f.name, "must not be empty")
+		}
+	}
+	if f, ok := fields["IndexInput"]; ok {
+		inputs.indexInput = stringField(f)
+	}
+
+	f, ok := fields["Items"]
+	if !ok {
+		return inputs
+	}
+	itemsPath := path + "." + f.name
+	if artifact {
+		items := v.checkObject(itemsPath, f.value, artifactItemsFields)
+		if f, ok := items["InputArtifact"]; ok {
+			inputs.items = stringField(f)
+		}
+		return inputs
+	}
+
+	items := v.checkObject(itemsPath, f.value, parameterItemsFields)
+	if items == nil {
+		return inputs
+	}
+	rawItems, hasRaw := items["Raw"]
+	parameter, hasParameter := items["InputParameter"]
+	switch {
+	case hasRaw == hasParameter:
+		v.addf(itemsPath, "must have exactly one of raw and inputParameter")
+	case hasRaw:
+		var l []json.RawMessage
+		if err := json.Unmarshal([]byte(stringField(rawItems)), &l); err != nil || l == nil {
+			v.addf(itemsPath+"."+rawItems.name, "must be a JSON array, got %s", rawItems.value)
+		}
+	default:
+		inputs.items = stringField(parameter)
+	}
+	return inputs
+}
+
+// checkIteratorInputs checks that the items of it are a declared input, and
+// that the inputs it provides are not.
+func (v *runtimeInfoValidator) checkIteratorInputs(it *iteratorInputs, itemsKind string, items []string, declared ...[]string) {
+	isItems := false
+	for _, n := range items {
+		isItems = isItems || n == it.items
+	}
+	if len(it.items) > 0 && !isItems {
+		v.addf(it.path, "items refer to undeclared input %s %q", itemsKind, it.items)
+	}
+
+	provided := []string{it.itemInput}
+	if len(it.indexInput) > 0 {
+		if it.indexInput == it.itemInput {
+			v.addf(it.path, "indexInput and itemInput must differ, both are %q", it.itemInput)
+		}
+		provided = append(provided, it.indexInput)
+	}
+	for _, p := range provided {
+		for _, names := range declared {
+			for _, n := range names {
+				if n == p && len(p) > 0 {
+					v.addf(it.path, "input %q is provided by the iterator and must not be declared", p)
+				}
+			}
+		}
+	}
+}
+
+// validateRuntimeInfo checks jsonEncoded runtime info without side effects.
+// It returns a *RuntimeInfoError listing every problem, or nil.
+func validateRuntimeInfo(jsonEncoded string) error {
+	v := &runtimeInfoValidator{}
+
+	sections := v.checkObject("$", json.RawMessage(jsonEncoded), runtimeInfoFields)
+	names := func(section string, check func(path, name string, raw json.RawMessage)) []string {
+		f, ok := sections[section]
+		if !ok {
+			return nil
+		}
+		return v.checkNamedObjects("$."+f.name, f.value, check)
+	}
+
+	inputParameters := names("InputParameters", v.checkInputParameter)
+	inputArtifacts := names("InputArtifacts", v.checkInputArtifact)
+	outputParameters := names("OutputParameters", v.checkOutputParameter)
+	outputArtifacts := names("OutputArtifacts", v.checkOutputArtifact)
+
+	// Placeholders and IfPresent refer to inputs by name only, so a name must
+	// not be both a parameter and an artifact.
+	checkDisjoint := func(kind string, parameters, artifacts []string) {
+		isParameter := make(map[string]bool)
+		for _, n := range parameters {
+			isParameter[n] = true
+		}
+		for _, n := range artifacts {
+			if isParameter[n] {
+				v.addf("$", "%s %q is declared as both a parameter and an artifact", kind, n)
+			}
+		}
+	}
+	checkDisjoint("input", inputParameters, inputArtifacts)
+	checkDisjoint("output", outputParameters, outputArtifacts)
+
+	artifactIterator, hasArtifactIterator := sections["ArtifactIterator"]
+	parameterIterator, hasParameterIterator := sections["ParameterIterator"]
+	if hasArtifactIterator && hasParameterIterator {
+		v.addf("$", "must have at most one of artifactIterator and parameterIterator")
+	}
+	if hasArtifactIterator {
+		if it := v.checkIterator("$."+artifactIterator.name, artifactIterator.value, true); it != nil {
+			v.checkIteratorInputs(it, "artifact", inputArtifacts, inputParameters, inputArtifacts)
+		}
+	}
+	if hasParameterIterator {
+		if it := v.checkIterator("$."+parameterIterator.name, parameterIterator.value, false); it != nil {
+			v.checkIteratorInputs(it, "parameter", inputParameters, inputParameters, inputArtifacts)
+		}
+	}
+
+	if len(v.problems) > 0 {
+		return &RuntimeInfoError{Problems: v.problems}
+	}
+	return nil
+}
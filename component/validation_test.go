@@ -0,0 +1,177 @@
+package component
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_fieldSpecs(t *testing.T) {
+	type items struct {
+		Name string `validate:"required"`
+	}
+	type object struct {
+		Items    *items `validate:"required"`
+		Optional bool
+		Renamed  string            `json:"newName,omitempty"`
+		Values   map[string]string `json:"values"`
+		Skipped  string            `json:"-"`
+		internal string
+	}
+	want := []fieldSpec{
+		{name: "Items", required: true, kind: "object"},
+		{name: "Optional", kind: "bool"},
+		{name: "newName", kind: "string"},
+		{name: "values", kind: "object"},
+	}
+	if diff := cmp.Diff(want, fieldSpecs(object{}), cmp.AllowUnexported(fieldSpec{})); diff != "" {
+		t.Errorf("fieldSpecs() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func Test_validateRuntimeInfo(t *testing.T) {
+	tests := []struct {
+		name         string
+		jsonEncoded  string
+		wantProblems []string
+	}{
+		{
+			name: "Valid",
+			jsonEncoded: `{
+				"inputParameters": {
+					"lr": {"parameterType": "DOUBLE", "parameterValue": "0.1"},
+					"seed": {"parameterType": "INT", "optional": true},
+					"config": {"parameterType": "STRUCT", "defaultValue": "{}"}
+				},
+				"inputArtifacts": {
+					"dataset": {"fileInputPath": "/tmp/inputs/dataset"},
+					"vocab": {"optional": true},
+					"embeddings": {"defaultValue": {"uri": "gs://bucket/embeddings", "artifactSchema": "title: kfp.Dataset\n"}}
+				},
+				"outputParameters": {
+					"accuracy": {"parameterType": "DOUBLE", "fileOutputPath": "/tmp/outputs/accuracy"}
+				},
+				"outputArtifacts": {
+					"model": {"artifactSchema": "title: kfp.Model\n", "fileOutputPath": "/tmp/outputs/model"}
+				}
+			}`,
+		},
+		{
+			name: "Reports every problem",
+			jsonEncoded: `{
+				"inputParameters": {
+					"lr": {"parameterTyp": "DOUBLE", "parameterValue": "0.1"},
+					"epochs": {"parameterType": "INTEGER", "parameterValue": "10"},
+					"shuffle": {"parameterType": "BOOLEAN", "parameterValue": "maybe"},
+					"epochs": {"parameterType": "INT", "parameterValue": "10"},
+					"dataset": {"parameterType": "STRING", "parameterValue": 5}
+				},
+				"inputArtifacts": {
+					"dataset": {"fileInputPath": ""}
+				},
+				"outputParameters": {
+					"accuracy": {"parameterType": "DOUBLE"}
+				},
+				"outputArtifacts": {
+					"model": {"fileOutputPath": "/tmp/outputs/model"},
+					"../escape": {"artifactSchema": "title: kfp.Model\n", "fileOutputPath": "/tmp/outputs/escape"}
+				},
+				"outputs": {}
+			}`,
+			wantProblems: []string{
+				`$.outputs: unknown field, expected one of inputParameters, inputArtifacts, outputParameters, outputArtifacts, artifactIterator, parameterIterator`,
+				`$.inputParameters.lr.parameterTyp: unknown field, expected one of parameterType, parameterValue, optional, defaultValue`,
+				`$.inputParameters.lr: missing required field parameterType`,
+				`$.inputParameters.epochs.parameterType: unknown parameter type "INTEGER", expected one of STRING, INT, DOUBLE, BOOLEAN, LIST, STRUCT`,
+				`$.inputParameters.shuffle.parameterValue: invalid BOOLEAN value: strconv.ParseBool: parsing "maybe": invalid syntax`,
+				`$.inputParameters.epochs: duplicate name`,
+				`$.inputParameters.dataset.parameterValue: must be a JSON string, got 5`,
+				`$.inputParameters.dataset: missing parameterValue for a required input without defaultValue`,
+				`$.inputArtifacts.dataset: missing fileInputPath for a required input artifact without defaultValue`,
+				`$.outputParameters.accuracy: missing required field fileOutputPath`,
+				`$.outputArtifacts.model: missing required field artifactSchema`,
+				`$.outputArtifacts.../escape: Invalid output name "../escape"`,
+				`$: input "dataset" is declared as both a parameter and an artifact`,
+			},
+		},
+		{
+			name: "Invalid default artifacts",
+			jsonEncoded: `{
+				"inputArtifacts": {
+					"vocab": {"defaultValue": {"uri": "/data/vocab"}},
+					"embeddings": {"defaultValue": {"uri": "gs://bucket/embeddings", "artifactSchema": ""}}
+				}
+			}`,
+			wantProblems: []string{
+				`$.inputArtifacts.vocab.defaultValue: missing required field artifactSchema`,
+				`$.inputArtifacts.vocab.defaultValue.uri: must be a URI, got "/data/vocab"`,
+				`$.inputArtifacts.embeddings.defaultValue.artifactSchema: must not be empty`,
+			},
+		},
+		{
+			name: "Valid iterator",
+			jsonEncoded: `{
+				"inputArtifacts": {"models": {"fileInputPath": "/tmp/inputs/models"}},
+				"artifactIterator": {"items": {"inputArtifact": "models"}, "itemInput": "model", "indexInput": "index"}
+			}`,
+		},
+		{
+			name: "Invalid iterators",
+			jsonEncoded: `{
+				"inputParameters": {
+					"item": {"parameterType": "STRING", "parameterValue": "a"}
+				},
+				"artifactIterator": {"items": {"inputArtifact": "models"}, "itemInput": "model", "indexInput": "model"},
+				"parameterIterator": {"items": {"raw": "{}", "inputParameter": "item"}, "itemInput": "item"}
+			}`,
+			wantProblems: []string{
+				`$: must have at most one of artifactIterator and parameterIterator`,
+				`$.artifactIterator: items refer to undeclared input artifact "models"`,
+				`$.artifactIterator: indexInput and itemInput must differ, both are "model"`,
+				`$.parameterIterator.items: must have exactly one of raw and inputParameter`,
+				`$.parameterIterator: input "item" is provided by the iterator and must not be declared`,
+			},
+		},
+		{
+			name: "Null fields",
+			jsonEncoded: `{
+				"inputParameters": {
+					"lr": {"parameterType": null, "parameterValue": "0.1"},
+					"seed": {"parameterType": "INT", "parameterValue": null, "defaultValue": null, "optional": true}
+				},
+				"outputArtifacts": {
+					"model": {"artifactSchema": "title: kfp.Model\n", "fileOutputPath": null}
+				},
+				"artifactIterator": null
+			}`,
+			wantProblems: []string{
+				`$.inputParameters.lr.parameterType: must not be null`,
+				`$.inputParameters.lr: missing required field parameterType`,
+				`$.outputArtifacts.model.fileOutputPath: must not be null`,
+				`$.outputArtifacts.model: missing required field fileOutputPath`,
+			},
+		},
+		{
+			name:         "Not an object",
+			jsonEncoded:  `[]`,
+			wantProblems: []string{`$: must be a JSON object, got []`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateRuntimeInfo(tt.jsonEncoded)
+			var got []string
+			if err != nil {
+				var rie *RuntimeInfoError
+				if !errors.As(err, &rie) {
+					t.Fatalf("validateRuntimeInfo() error = %v, want a *RuntimeInfoError", err)
+				}
+				got = rie.Problems
+			}
+			if diff := cmp.Diff(tt.wantProblems, got); diff != "" {
+				t.Errorf("validateRuntimeInfo() problems mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
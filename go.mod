@@ -3,10 +3,12 @@ module github.com/neuromage/kfp-launcher
 go 1.15
 
 require (
+	github.com/aws/aws-sdk-go v1.36.1
 	github.com/davecgh/go-spew v1.1.1
 	github.com/golang/glog v0.0.0-20160126235308-23def4e6c14b
 	github.com/golang/protobuf v1.4.3
 	github.com/google/go-cmp v0.5.4
+	github.com/johannesboyne/gofakes3 v0.0.0-20210124080349-901cf567bf01
 	gocloud.dev v0.22.0
 	google.golang.org/grpc v1.35.0
 	google.golang.org/protobuf v1.25.0
@@ -0,0 +1,211 @@
+package metadata
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/golang/glog"
+	pb "github.com/neuromage/kfp-launcher/third_party/ml_metadata"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	retryAttemptProperty = "retry_attempt"
+	supersededByProperty = "superseded_by"
+)
+
+// executionName is the deterministic name of the execution for one attempt
+// of a task in a pipeline run. MLMD names are unique per type, so looking the
+// name up finds any earlier registration of the same attempt.
+func executionName(runID, taskID string, attempt int) string {
+	return fmt.Sprintf("%s/%s/attempt-%d", runID, taskID, attempt)
+}
+
+// getExecutionByName returns the execution of type t called name, or nil if
+// there is none.
+func (c *Client) getExecutionByName(ctx context.Context, t ExecutionType, name string) (*pb.Execution, error) {
+	var res *pb.GetExecutionByTypeAndNameResponse
+	err := c.call(ctx, "GetExecutionByTypeAndName", func(ctx context.Context) (err error) {
+		res, err = c.svc.GetExecutionByTypeAndName(ctx, &pb.GetExecutionByTypeAndNameRequest{
+			TypeName:      proto.String(string(t)),
+			ExecutionName: proto.String(name),
+		})
+		return err
+	})
+	if status.Code(err) == codes.NotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Like GetContextByTypeAndName, MLMD returns OK with no execution when
+	// nothing matches.
+	return res.GetExecution(), nil
+}
+
+// supersedePreviousAttempts links the executions of earlier attempts of
+// taskID to executionID. Attempts that never reached a terminal state were
+// abandoned by their pod and are marked FAILED.
+func (c *Client) supersedePreviousAttempts(ctx context.Context, t ExecutionType, pipeline *Pipeline, taskID string, attempt int, executionID int64) error {
+	for a := 0; a < attempt; a++ {
+		prev, err := c.getExecutionByName(ctx, t, executionName(pipeline.pipelineRunCtx.GetName(), taskID, a))
+		if err != nil {
+			return err
+		}
+		if prev == nil || prev.GetCustomProperties()[supersededByProperty] != nil {
+			continue
+		}
+
+		if prev.CustomProperties == nil {
+			prev.CustomProperties = make(map[string]*pb.Value)
+		}
+		prev.CustomProperties[supersededByProperty] = intValue(executionID)
+		switch prev.GetLastKnownState() {
+		case pb.Execution_UNKNOWN, pb.Execution_NEW, pb.Execution_RUNNING:
+			prev.LastKnownState = pb.Execution_FAILED.Enum()
+		}
+
+		glog.Infof("Execution %d (attempt %d) is superseded by execution %d", prev.GetId(), a, executionID)
+		if _, err := c.putExecution(ctx, &pb.PutExecutionRequest{Execution: prev}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unrecordedEvents returns the pairs whose events are not yet recorded for
+// executionID.
+func (c *Client) unrecordedEvents(ctx context.Context, executionID int64, pairs []*pb.PutExecutionRequest_ArtifactAndEvent) ([]*pb.PutExecutionRequest_ArtifactAndEvent, error) {
+	var res *pb.GetEventsByExecutionIDsResponse
+	err := c.call(ctx, "GetEventsByExecutionIDs", func(ctx context.Context) (err error) {
+		res, err = c.svc.GetEventsByExecutionIDs(ctx, &pb.GetEventsByExecutionIDsRequest{ExecutionIds: []int64{executionID}})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	type eventKey struct {
+		artifactID int64
+		eventType  pb.Event_Type
+	}
+	recorded := make(map[eventKey]bool)
+	for _, e := range res.GetEvents() {
+		recorded[eventKey{e.GetArtifactId(), e.GetType()}] = true
+	}
+
+	var result []*pb.PutExecutionRequest_ArtifactAndEvent
+	for _, p := range pairs {
+		ev := p.GetEvent()
+		if ev.ArtifactId != nil && recorded[eventKey{ev.GetArtifactId(), ev.GetType()}] {
+			continue
+		}
+		result = append(result, p)
+	}
+	return result, nil
+}
+
+// getArtifactByURI returns an artifact of type typeID recorded at uri, or nil
+// if there is none.
+func (c *Client) getArtifactByURI(ctx context.Context, uri string, typeID int64) (*pb.Artifact, error) {
+	artifacts, err := c.getArtifactsByURI(ctx, uri, typeID)
+	if err != nil {
+		return nil, err
+	}
+
+	var found *pb.Artifact
+	for _, a := range artifacts {
+		if found == nil || a.GetId() < found.GetId() {
+			found = a
+		}
+	}
+	return found, nil
+}
+
+// getArtifactsByURI returns the artifacts of type typeID recorded at uri.
+func (c *Client) getArtifactsByURI(ctx context.Context, uri string, typeID int64) ([]*pb.Artifact, error) {
+	if len(uri) == 0 {
+		return nil, nil
+	}
+	var res *pb.GetArtifactsByURIResponse
+	err := c.call(ctx, "GetArtifactsByURI", func(ctx context.Context) (err error) {
+		res, err = c.svc.GetArtifactsByURI(ctx, &pb.GetArtifactsByURIRequest{Uris: []string{uri}})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var artifacts []*pb.Artifact
+	for _, a := range res.GetArtifacts() {
+		if a.GetTypeId() == typeID {
+			artifacts = append(artifacts, a)
+		}
+	}
+	return artifacts, nil
+}
+
+// artifactInserted returns the check run before resending PutArtifacts for
+// artifact, which has no ID yet: an artifact of its type that appeared at its
+// URI since artifactInserted was called was inserted by an earlier attempt,
+// and its ID is stored in id. It returns nil for artifacts without a URI,
+// which cannot be looked up.
+func (c *Client) artifactInserted(ctx context.Context, artifact *pb.Artifact, id *int64) (func(ctx context.Context) (bool, error), error) {
+	if len(artifact.GetUri()) == 0 {
+		return nil, nil
+	}
+	before, err := c.getArtifactsByURI(ctx, artifact.GetUri(), artifact.GetTypeId())
+	if err != nil {
+		return nil, err
+	}
+	known := make(map[int64]bool)
+	for _, a := range before {
+		known[a.GetId()] = true
+	}
+
+	return func(ctx context.Context) (bool, error) {
+		artifacts, err := c.getArtifactsByURI(ctx, artifact.GetUri(), artifact.GetTypeId())
+		if err != nil {
+			return false, err
+		}
+		for _, a := range artifacts {
+			if !known[a.GetId()] {
+				*id = a.GetId()
+				return true, nil
+			}
+		}
+		return false, nil
+	}, nil
+}
+
+// executionInserted returns the check run before resending PutExecution for
+// e, which has no ID yet: an execution of its type with its name was inserted
+// by an earlier attempt, and its ID is stored in id. It returns nil for
+// unnamed executions, which cannot be looked up.
+func (c *Client) executionInserted(e *pb.Execution, id *int64) func(ctx context.Context) (bool, error) {
+	if len(e.GetName()) == 0 {
+		return nil
+	}
+	return func(ctx context.Context) (bool, error) {
+		var res *pb.GetExecutionTypesByIDResponse
+		err := c.call(ctx, "GetExecutionTypesByID", func(ctx context.Context) (err error) {
+			res, err = c.svc.GetExecutionTypesByID(ctx, &pb.GetExecutionTypesByIDRequest{TypeIds: []int64{e.GetTypeId()}})
+			return err
+		})
+		if err != nil {
+			return false, err
+		}
+		if len(res.GetExecutionTypes()) != 1 {
+			return false, fmt.Errorf("Execution type %d not found", e.GetTypeId())
+		}
+
+		existing, err := c.getExecutionByName(ctx, ExecutionType(res.GetExecutionTypes()[0].GetName()), e.GetName())
+		if err != nil || existing == nil {
+			return false, err
+		}
+		*id = existing.GetId()
+		return true, nil
+	}
+}
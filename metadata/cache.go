@@ -0,0 +1,155 @@
+package metadata
+
+import (
+	"context"
+	"strings"
+
+	pb "github.com/neuromage/kfp-launcher/third_party/ml_metadata"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	cacheFingerprintProperty  = "cache_fingerprint"
+	cachedExecutionIDProperty = "cached_execution_id"
+	outputParameterPrefix     = "output:"
+)
+
+// CachedExecution is a previously completed execution whose outputs can be
+// reused by an execution with the same fingerprint.
+type CachedExecution struct {
+	ExecutionID int64
+	// OutputParameters maps output parameter names to their recorded values.
+	OutputParameters map[string]*pb.Value
+	// OutputArtifacts maps output names to the artifacts that were produced.
+	OutputArtifacts map[string]*pb.Artifact
+}
+
+// cacheLookupPageSize is the number of executions of a fingerprint fetched at
+// once, newest first, while looking for a completed one.
+const cacheLookupPageSize = 20
+
+// GetCachedExecution returns the most recent COMPLETE container execution
+// recorded with fingerprint, or nil if there is none. Only the executions
+// associated with the context of the fingerprint, see CreateExecution, are
+// read.
+func (c *Client) GetCachedExecution(ctx context.Context, fingerprint string) (*CachedExecution, error) {
+	cacheCtx, err := c.getContextByTypeAndName(ctx, fingerprint, cacheContextType)
+	if err != nil {
+		return nil, err
+	}
+	if cacheCtx.GetContext() == nil {
+		return nil, nil
+	}
+
+	var match *pb.Execution
+	options := &pb.ListOperationOptions{
+		MaxResultSize: proto.Int32(cacheLookupPageSize),
+		OrderByField: &pb.ListOperationOptions_OrderByField{
+			Field: pb.ListOperationOptions_OrderByField_ID.Enum(),
+			IsAsc: proto.Bool(false),
+		},
+	}
+	for match == nil {
+		var res *pb.GetExecutionsByContextResponse
+		err := c.call(ctx, "GetExecutionsByContext", func(ctx context.Context) (err error) {
+			res, err = c.svc.GetExecutionsByContext(ctx, &pb.GetExecutionsByContextRequest{
+				ContextId: cacheCtx.GetContext().Id,
+				Options:   options,
+			})
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range res.GetExecutions() {
+			if e.GetLastKnownState() == pb.Execution_COMPLETE &&
+				e.GetCustomProperties()[cacheFingerprintProperty].GetStringValue() == fingerprint {
+				match = e
+				break
+			}
+		}
+		if len(res.GetNextPageToken()) == 0 {
+			break
+		}
+		options.NextPageToken = res.NextPageToken
+	}
+	if match == nil {
+		return nil, nil
+	}
+
+	cached := &CachedExecution{
+		ExecutionID:      match.GetId(),
+		OutputParameters: make(map[string]*pb.Value),
+		OutputArtifacts:  make(map[string]*pb.Artifact),
+	}
+	for k, v := range match.GetCustomProperties() {
+		if strings.HasPrefix(k, outputParameterPrefix) {
+			cached.OutputParameters[strings.TrimPrefix(k, outputParameterPrefix)] = v
+		}
+	}
+
+	var eventsRes *pb.GetEventsByExecutionIDsResponse
+	err = c.call(ctx, "GetEventsByExecutionIDs", func(ctx context.Context) (err error) {
+		eventsRes, err = c.svc.GetEventsByExecutionIDs(ctx, &pb.GetEventsByExecutionIDsRequest{
+			ExecutionIds: []int64{match.GetId()},
+		})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[int64]string)
+	var ids []int64
+	for _, ev := range eventsRes.GetEvents() {
+		if ev.GetType() != pb.Event_OUTPUT {
+			continue
+		}
+		steps := ev.GetPath().GetSteps()
+		if len(steps) == 0 || len(steps[0].GetKey()) == 0 {
+			// Recorded before output names were tracked; cannot be matched.
+			continue
+		}
+		names[ev.GetArtifactId()] = steps[0].GetKey()
+		ids = append(ids, ev.GetArtifactId())
+	}
+	if len(ids) == 0 {
+		return cached, nil
+	}
+
+	artifacts, err := c.GetArtifacts(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range artifacts {
+		cached.OutputArtifacts[names[a.GetId()]] = a
+	}
+	return cached, nil
+}
+
+// PublishCachedExecution marks execution as CACHED, reusing the output
+// parameters and artifacts of cached.
+func (c *Client) PublishCachedExecution(ctx context.Context, execution *Execution, cached *CachedExecution) error {
+	e := execution.execution
+	e.LastKnownState = pb.Execution_CACHED.Enum()
+	e.CustomProperties[cachedExecutionIDProperty] = intValue(cached.ExecutionID)
+	for n, v := range cached.OutputParameters {
+		e.CustomProperties[outputParameterPrefix+n] = v
+	}
+
+	req := &pb.PutExecutionRequest{
+		Execution: e,
+		Contexts:  execution.pipeline.contexts(),
+	}
+	for n, a := range cached.OutputArtifacts {
+		req.ArtifactEventPairs = append(req.ArtifactEventPairs, &pb.PutExecutionRequest_ArtifactAndEvent{
+			Event: &pb.Event{
+				Type:       pb.Event_OUTPUT.Enum(),
+				ArtifactId: a.Id,
+				Path:       eventPath(n),
+			},
+		})
+	}
+
+	_, err := c.putExecution(ctx, req)
+	return err
+}
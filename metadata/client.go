@@ -12,13 +12,30 @@ import (
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
 	"gopkg.in/yaml.v2"
 )
 
 const (
 	pipelineContextTypeName    = "kfp.Pipeline"
 	pipelineRunContextTypeName = "kfp.PipelineRun"
-	containerExecutionTypeName = "kfp.ContainerExecution"
+	iteratorContextTypeName    = "kfp.Iterator"
+	// Executions recorded with a cache fingerprint are associated with a
+	// context of this type named after the fingerprint.
+	cacheContextTypeName = "kfp.CacheFingerprint"
+)
+
+// ExecutionType is the MLMD execution type of a task, i.e. what the launcher
+// did for it.
+type ExecutionType string
+
+const (
+	// ContainerExecution runs a user command.
+	ContainerExecution ExecutionType = "kfp.ContainerExecution"
+	// ImporterExecution registers an artifact that already exists in storage.
+	ImporterExecution ExecutionType = "kfp.ImporterExecution"
+	// ResolverExecution resolves artifacts already recorded in MLMD.
+	ResolverExecution ExecutionType = "kfp.ResolverExecution"
 )
 
 var (
@@ -31,25 +48,44 @@ var (
 		Name: proto.String(pipelineRunContextTypeName),
 	}
 
-	containerExecutionType = &pb.ExecutionType{
-		Name: proto.String(containerExecutionTypeName),
+	iteratorContextType = &pb.ContextType{
+		Name: proto.String(iteratorContextTypeName),
+	}
+
+	cacheContextType = &pb.ContextType{
+		Name: proto.String(cacheContextTypeName),
 	}
 )
 
 // Client is ..
 type Client struct {
-	svc pb.MetadataStoreServiceClient
+	svc   pb.MetadataStoreServiceClient
+	retry RetryOptions
 }
 
-// NewClient ...
-func NewClient(serverAddress, serverPort string) (*Client, error) {
-	conn, err := grpc.Dial(fmt.Sprintf("%s:%s", serverAddress, serverPort), grpc.WithInsecure())
+// NewClient ... A nil opts connects over plaintext without authentication.
+func NewClient(serverAddress, serverPort string, opts *ClientOptions) (*Client, error) {
+	if opts == nil {
+		opts = &ClientOptions{}
+	}
+	dialOpts, err := opts.dialOptions()
 	if err != nil {
 		return nil, err
 	}
 
+	conn, err := grpc.Dial(fmt.Sprintf("%s:%s", serverAddress, serverPort), dialOpts...)
+	if err != nil {
+		return nil, err
+	}
+
+	retry := DefaultRetryOptions
+	if opts.Retry != nil {
+		retry = *opts.Retry
+	}
+
 	return &Client{
-		svc: pb.NewMetadataStoreServiceClient(conn),
+		svc:   pb.NewMetadataStoreServiceClient(conn),
+		retry: retry,
 	}, nil
 }
 
@@ -57,24 +93,63 @@ type Parameters struct {
 	IntParameters    map[string]int64
 	StringParameters map[string]string
 	DoubleParameters map[string]float64
+	// StructParameters holds JSON valued parameters. Booleans and lists are
+	// wrapped as {"value": ...} by the caller.
+	StructParameters map[string]*structpb.Struct
 }
 
 type ExecutionConfig struct {
 	InputParameters *Parameters
 	InputArtifacts  []*InputArtifact
+	// CacheFingerprint identifies executions whose outputs are
+	// interchangeable. Empty if the execution must not be cached.
+	CacheFingerprint string
+	// RetryAttempt is the zero-based attempt number of the task. Together
+	// with the run and task IDs it names the execution.
+	RetryAttempt int
+	// Type is the execution type. Defaults to ContainerExecution.
+	Type ExecutionType
+	// Iteration is the zero-based index of the execution among the
+	// iterations of a task, or nil if the task does not iterate. See
+	// Client.GetIterator.
+	Iteration *int
 }
 
 type InputArtifact struct {
+	// Name is the input name, recorded in the INPUT event path.
+	Name     string
 	Artifact *pb.Artifact
 }
 type OutputArtifact struct {
+	// Name is the output name, recorded in the OUTPUT event path.
+	Name     string
 	Artifact *pb.Artifact
 	Schema   string
 }
 
+func eventPath(name string) *pb.Event_Path {
+	if len(name) == 0 {
+		return nil
+	}
+	return &pb.Event_Path{
+		Steps: []*pb.Event_Path_Step{{Value: &pb.Event_Path_Step_Key{Key: name}}},
+	}
+}
+
 type Pipeline struct {
 	pipelineCtx    *pb.Context
 	pipelineRunCtx *pb.Context
+	// iteratorCtx groups the iterations of a task, if any.
+	iteratorCtx *pb.Context
+}
+
+// contexts returns the contexts executions of the pipeline belong to.
+func (p *Pipeline) contexts() []*pb.Context {
+	contexts := []*pb.Context{p.pipelineCtx, p.pipelineRunCtx}
+	if p.iteratorCtx != nil {
+		contexts = append(contexts, p.iteratorCtx)
+	}
+	return contexts
 }
 
 type Execution struct {
@@ -83,13 +158,13 @@ type Execution struct {
 }
 
 func (c *Client) GetPipeline(ctx context.Context, pipelineName string, pipelineRunID string) (*Pipeline, error) {
-	pipelineContext, err := getOrInsertContext(ctx, c.svc, pipelineName, pipelineContextType)
+	pipelineContext, err := c.getOrInsertContext(ctx, pipelineName, pipelineContextType)
 	if err != nil {
 		return nil, err
 	}
 	fmt.Printf("Got pipeline context:\n%+v\n", pipelineContext)
 
-	pipelineRunContext, err := getOrInsertContext(ctx, c.svc, pipelineRunID, pipelineRunContextType)
+	pipelineRunContext, err := c.getOrInsertContext(ctx, pipelineRunID, pipelineRunContextType)
 	if err != nil {
 		return nil, err
 	}
@@ -102,9 +177,13 @@ func (c *Client) GetPipeline(ctx context.Context, pipelineName string, pipelineR
 
 }
 
-func (c *Client) getContainerExecutionTypeID(ctx context.Context) (int64, error) {
-	eType, err := c.svc.PutExecutionType(ctx, &pb.PutExecutionTypeRequest{
-		ExecutionType: containerExecutionType,
+func (c *Client) getExecutionTypeID(ctx context.Context, t ExecutionType) (int64, error) {
+	var eType *pb.PutExecutionTypeResponse
+	err := c.call(ctx, "PutExecutionType", func(ctx context.Context) (err error) {
+		eType, err = c.svc.PutExecutionType(ctx, &pb.PutExecutionTypeRequest{
+			ExecutionType: &pb.ExecutionType{Name: proto.String(string(t))},
+		})
+		return err
 	})
 
 	if err != nil {
@@ -126,24 +205,31 @@ func doubleValue(f float64) *pb.Value {
 	return &pb.Value{Value: &pb.Value_DoubleValue{DoubleValue: f}}
 }
 
+func structValue(s *structpb.Struct) *pb.Value {
+	return &pb.Value{Value: &pb.Value_StructValue{StructValue: s}}
+}
+
 func (c *Client) PublishExecution(ctx context.Context, execution *Execution, outputParameters *Parameters, outputArtifacts []*OutputArtifact) error {
 	e := execution.execution
 	e.LastKnownState = pb.Execution_COMPLETE.Enum()
 
 	// Record output parameters.
 	for n, p := range outputParameters.IntParameters {
-		e.CustomProperties["output:"+n] = intValue(p)
+		e.CustomProperties[outputParameterPrefix+n] = intValue(p)
 	}
 	for n, p := range outputParameters.DoubleParameters {
-		e.CustomProperties["output:"+n] = doubleValue(p)
+		e.CustomProperties[outputParameterPrefix+n] = doubleValue(p)
 	}
 	for n, p := range outputParameters.StringParameters {
-		e.CustomProperties["output:"+n] = stringValue(p)
+		e.CustomProperties[outputParameterPrefix+n] = stringValue(p)
+	}
+	for n, p := range outputParameters.StructParameters {
+		e.CustomProperties[outputParameterPrefix+n] = structValue(p)
 	}
 
 	req := &pb.PutExecutionRequest{
 		Execution: e,
-		Contexts:  []*pb.Context{execution.pipeline.pipelineCtx, execution.pipeline.pipelineRunCtx},
+		Contexts:  execution.pipeline.contexts(),
 	}
 
 	for _, oa := range outputArtifacts {
@@ -151,33 +237,132 @@ func (c *Client) PublishExecution(ctx context.Context, execution *Execution, out
 			Event: &pb.Event{
 				Type:       pb.Event_OUTPUT.Enum(),
 				ArtifactId: oa.Artifact.Id,
+				Path:       eventPath(oa.Name),
 			},
 		}
 		req.ArtifactEventPairs = append(req.ArtifactEventPairs, aePair)
 	}
 
-	_, err := c.svc.PutExecution(ctx, req)
+	_, err := c.putExecution(ctx, req)
+	return err
+}
+
+// ExecutionFailure describes why an execution did not complete.
+type ExecutionFailure struct {
+	// ExitCode of the user command, or -1 if the command never ran or did not
+	// exit normally.
+	ExitCode int
+	// Signal that terminated the user command, if any.
+	Signal string
+	// Message summarizes the error.
+	Message string
+}
+
+// maxErrorMessageLength bounds the error summary stored on failed executions.
+const maxErrorMessageLength = 4096
+
+// FailExecution marks execution as FAILED, recording the exit code, signal and
+// error summary as custom properties.
+func (c *Client) FailExecution(ctx context.Context, execution *Execution, failure *ExecutionFailure) error {
+	return c.endExecution(ctx, execution, pb.Execution_FAILED, failure, nil)
+}
+
+// CancelExecution marks execution as CANCELED after the launcher was asked to
+// terminate. Partial outputs that were kept are recorded as OUTPUT events.
+func (c *Client) CancelExecution(ctx context.Context, execution *Execution, failure *ExecutionFailure, partialOutputs []*OutputArtifact) error {
+	return c.endExecution(ctx, execution, pb.Execution_CANCELED, failure, partialOutputs)
+}
+
+func (c *Client) endExecution(ctx context.Context, execution *Execution, state pb.Execution_State, failure *ExecutionFailure, outputArtifacts []*OutputArtifact) error {
+	e := execution.execution
+	e.LastKnownState = state.Enum()
+	if e.CustomProperties == nil {
+		e.CustomProperties = make(map[string]*pb.Value)
+	}
+
+	e.CustomProperties["exit_code"] = intValue(int64(failure.ExitCode))
+	if len(failure.Signal) > 0 {
+		e.CustomProperties["signal"] = stringValue(failure.Signal)
+	}
+	msg := failure.Message
+	if len(msg) > maxErrorMessageLength {
+		msg = msg[:maxErrorMessageLength] + "...(truncated)"
+	}
+	e.CustomProperties["error"] = stringValue(msg)
+
+	req := &pb.PutExecutionRequest{
+		Execution: e,
+		Contexts:  execution.pipeline.contexts(),
+	}
+	for _, oa := range outputArtifacts {
+		req.ArtifactEventPairs = append(req.ArtifactEventPairs, &pb.PutExecutionRequest_ArtifactAndEvent{
+			Event: &pb.Event{
+				Type:       pb.Event_OUTPUT.Enum(),
+				ArtifactId: oa.Artifact.Id,
+				Path:       eventPath(oa.Name),
+			},
+		})
+	}
+	_, err := c.putExecution(ctx, req)
 	return err
 }
 
 func (c *Client) CreateExecution(ctx context.Context, pipeline *Pipeline, taskName, taskID, containerImage string, config *ExecutionConfig) (*Execution, error) {
-	typeID, err := c.getContainerExecutionTypeID(ctx)
+	executionType := config.Type
+	if len(executionType) == 0 {
+		executionType = ContainerExecution
+	}
+	typeID, err := c.getExecutionTypeID(ctx, executionType)
 	if err != nil {
 		return nil, err
 	}
 
+	// Iterations are named, and retried, like separate tasks.
+	taskKey := taskID
+	if config.Iteration != nil {
+		taskKey = fmt.Sprintf("%s/iteration-%d", taskID, *config.Iteration)
+	}
+	name := executionName(pipeline.pipelineRunCtx.GetName(), taskKey, config.RetryAttempt)
 	e := &pb.Execution{
 		TypeId: &typeID,
+		Name:   proto.String(name),
 		CustomProperties: map[string]*pb.Value{
-			"task_name":       stringValue(taskName),
-			"pipeline_name":   stringValue(*pipeline.pipelineCtx.Name),
-			"pipeline_run_id": stringValue(*pipeline.pipelineRunCtx.Name),
-			"kfp_pod_name":    stringValue(taskID),
-			"container_image": stringValue(containerImage),
+			"task_name":          stringValue(taskName),
+			"pipeline_name":      stringValue(*pipeline.pipelineCtx.Name),
+			"pipeline_run_id":    stringValue(*pipeline.pipelineRunCtx.Name),
+			"kfp_pod_name":       stringValue(taskID),
+			"container_image":    stringValue(containerImage),
+			retryAttemptProperty: intValue(int64(config.RetryAttempt)),
 		},
 		LastKnownState: pb.Execution_RUNNING.Enum(),
 	}
 
+	// The same attempt may be registered more than once, e.g. when the launcher
+	// restarts inside the pod. Attach to the earlier record instead of
+	// inserting a duplicate.
+	existing, err := c.getExecutionByName(ctx, executionType, name)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		// A finished execution keeps its recorded state, e.g. when a completed
+		// task is launched again.
+		switch state := existing.GetLastKnownState(); state {
+		case pb.Execution_NEW, pb.Execution_RUNNING:
+		default:
+			return nil, fmt.Errorf("Execution %d (%s) already finished as %v", existing.GetId(), name, state)
+		}
+		glog.Infof("Attaching to existing execution %d (%s)", existing.GetId(), name)
+		e.Id = existing.Id
+	}
+
+	if config.Iteration != nil {
+		e.CustomProperties[iterationIndexProperty] = intValue(int64(*config.Iteration))
+	}
+	if len(config.CacheFingerprint) > 0 {
+		e.CustomProperties[cacheFingerprintProperty] = stringValue(config.CacheFingerprint)
+	}
+
 	for k, v := range config.InputParameters.StringParameters {
 		e.CustomProperties["input:"+k] = stringValue(v)
 	}
@@ -187,10 +372,21 @@ func (c *Client) CreateExecution(ctx context.Context, pipeline *Pipeline, taskNa
 	for k, v := range config.InputParameters.DoubleParameters {
 		e.CustomProperties["input:"+k] = doubleValue(v)
 	}
+	for k, v := range config.InputParameters.StructParameters {
+		e.CustomProperties["input:"+k] = structValue(v)
+	}
 
 	req := &pb.PutExecutionRequest{
 		Execution: e,
-		Contexts:  []*pb.Context{pipeline.pipelineCtx, pipeline.pipelineRunCtx},
+		Contexts:  pipeline.contexts(),
+	}
+	if len(config.CacheFingerprint) > 0 {
+		// Lets GetCachedExecution find the execution by its fingerprint.
+		cacheCtx, err := c.getOrInsertContext(ctx, config.CacheFingerprint, cacheContextType)
+		if err != nil {
+			return nil, err
+		}
+		req.Contexts = append(req.Contexts, cacheCtx)
 	}
 
 	for _, ia := range config.InputArtifacts {
@@ -198,21 +394,30 @@ func (c *Client) CreateExecution(ctx context.Context, pipeline *Pipeline, taskNa
 			Event: &pb.Event{
 				Type:       pb.Event_INPUT.Enum(),
 				ArtifactId: ia.Artifact.Id,
+				Path:       eventPath(ia.Name),
 			},
 		}
 		req.ArtifactEventPairs = append(req.ArtifactEventPairs, aePair)
 	}
 
-	res, err := c.svc.PutExecution(ctx, req)
+	executionID, err := c.putExecution(ctx, req)
 	if err != nil {
 		return nil, err
 	}
 
+	if err := c.supersedePreviousAttempts(ctx, executionType, pipeline, taskKey, config.RetryAttempt, executionID); err != nil {
+		return nil, err
+	}
+
 	getReq := &pb.GetExecutionsByIDRequest{
-		ExecutionIds: []int64{res.GetExecutionId()},
+		ExecutionIds: []int64{executionID},
 	}
 
-	getRes, err := c.svc.GetExecutionsByID(ctx, getReq)
+	var getRes *pb.GetExecutionsByIDResponse
+	err = c.call(ctx, "GetExecutionsByID", func(ctx context.Context) (err error) {
+		getRes, err = c.svc.GetExecutionsByID(ctx, getReq)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -230,16 +435,36 @@ func (c *Client) CreateExecution(ctx context.Context, pipeline *Pipeline, taskNa
 // GetArtifacts ...
 func (c *Client) GetArtifacts(ctx context.Context, ids []int64) ([]*pb.Artifact, error) {
 	req := &pb.GetArtifactsByIDRequest{ArtifactIds: ids}
-	res, err := c.svc.GetArtifactsByID(ctx, req)
+	var res *pb.GetArtifactsByIDResponse
+	err := c.call(ctx, "GetArtifactsByID", func(ctx context.Context) (err error) {
+		res, err = c.svc.GetArtifactsByID(ctx, req)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
 	return res.Artifacts, nil
 }
 
-// Only supports schema titles for now.
+// schemaObject is the part of an artifact's instance schema, an OpenAPI object
+// schema in YAML, that is recorded in MLMD.
 type schemaObject struct {
-	Title string `yaml:"title"`
+	Title      string                     `yaml:"title"`
+	Properties map[string]*schemaProperty `yaml:"properties"`
+}
+
+type schemaProperty struct {
+	Type string `yaml:"type"`
+}
+
+// schemaPropertyTypes maps the types of schema properties to MLMD property
+// types. Objects and arrays are stored as structs.
+var schemaPropertyTypes = map[string]pb.PropertyType{
+	"string":  pb.PropertyType_STRING,
+	"integer": pb.PropertyType_INT,
+	"number":  pb.PropertyType_DOUBLE,
+	"object":  pb.PropertyType_STRUCT,
+	"array":   pb.PropertyType_STRUCT,
 }
 
 func schemaToArtifactType(schema string) (*pb.ArtifactType, error) {
@@ -248,53 +473,234 @@ func schemaToArtifactType(schema string) (*pb.ArtifactType, error) {
 		return nil, err
 	}
 
-	// TODO: Also parse properties.
 	if so.Title == "" {
-		glog.Fatal("No title specified")
+		return nil, fmt.Errorf("No title specified in artifact schema %q", schema)
 	}
 	at := &pb.ArtifactType{Name: proto.String(so.Title)}
+
+	for name, p := range so.Properties {
+		if p == nil || len(p.Type) == 0 {
+			return nil, fmt.Errorf("Property %q of artifact type %q has no type", name, so.Title)
+		}
+		t, ok := schemaPropertyTypes[p.Type]
+		if !ok {
+			return nil, fmt.Errorf("Property %q of artifact type %q has unsupported type %q", name, so.Title, p.Type)
+		}
+		if at.Properties == nil {
+			at.Properties = make(map[string]pb.PropertyType)
+		}
+		at.Properties[name] = t
+	}
 	return at, nil
 }
 
+func valuePropertyType(v *pb.Value) pb.PropertyType {
+	switch v.GetValue().(type) {
+	case *pb.Value_IntValue:
+		return pb.PropertyType_INT
+	case *pb.Value_DoubleValue:
+		return pb.PropertyType_DOUBLE
+	case *pb.Value_StringValue:
+		return pb.PropertyType_STRING
+	case *pb.Value_StructValue:
+		return pb.PropertyType_STRUCT
+	}
+	return pb.PropertyType_UNKNOWN
+}
+
+// validateArtifactProperties checks that every property of artifact is
+// declared by its type, with a value of the declared type. Integers are
+// accepted for DOUBLE properties and converted.
+func validateArtifactProperties(at *pb.ArtifactType, artifact *pb.Artifact) error {
+	for name, v := range artifact.GetProperties() {
+		want, ok := at.GetProperties()[name]
+		if !ok {
+			return fmt.Errorf("Artifact type %q does not declare property %q", at.GetName(), name)
+		}
+		got := valuePropertyType(v)
+		if got == pb.PropertyType_INT && want == pb.PropertyType_DOUBLE {
+			artifact.Properties[name] = doubleValue(float64(v.GetIntValue()))
+			continue
+		}
+		if got != want {
+			return fmt.Errorf("Property %q of artifact type %q must be %v, got %v", name, at.GetName(), want, got)
+		}
+	}
+	return nil
+}
+
 // RecordArtifact ...
 func (c *Client) RecordArtifact(ctx context.Context, schema string, artifact *pb.Artifact) (*pb.Artifact, error) {
 	fmt.Printf("Logging Artifact %s, schema: %s", spew.Sdump(artifact), schema)
 
-	at, err := schemaToArtifactType(schema)
-	if err != nil {
+	if err := c.putArtifactType(ctx, schema, artifact); err != nil {
 		return nil, err
 	}
-	putTypeRes, err := c.svc.PutArtifactType(ctx, &pb.PutArtifactTypeRequest{ArtifactType: at})
+
+	// Output URIs are deterministic, so a retried task records the same URI
+	// again. Update the existing artifact rather than inserting a duplicate.
+	existing, err := c.getArtifactByURI(ctx, artifact.GetUri(), artifact.GetTypeId())
 	if err != nil {
 		return nil, err
 	}
-	at.Id = putTypeRes.TypeId
+	if existing != nil {
+		artifact.Id = existing.Id
+	}
+	return c.putArtifact(ctx, artifact)
+}
 
-	artifact.TypeId = at.Id
+// ImportArtifact registers artifact, which already exists in storage, for an
+// importer. An artifact of the same type registered earlier with the same URI
+// is returned unchanged, unless reimport is set, in which case a new artifact
+// is always registered.
+func (c *Client) ImportArtifact(ctx context.Context, schema string, artifact *pb.Artifact, reimport bool) (*pb.Artifact, error) {
+	glog.V(1).Infof("Importing artifact %v, schema: %s", artifact, schema)
 
-	res, err := c.svc.PutArtifacts(ctx, &pb.PutArtifactsRequest{
-		Artifacts: []*pb.Artifact{artifact},
+	if err := c.putArtifactType(ctx, schema, artifact); err != nil {
+		return nil, err
+	}
+	if !reimport {
+		existing, err := c.getArtifactByURI(ctx, artifact.GetUri(), artifact.GetTypeId())
+		if err != nil {
+			return nil, err
+		}
+		if existing != nil {
+			glog.Infof("Reusing artifact %d previously imported from %s", existing.GetId(), artifact.GetUri())
+			return existing, nil
+		}
+	}
+	return c.putArtifact(ctx, artifact)
+}
+
+// putArtifactType validates artifact against schema, registers the artifact
+// type and sets the type ID of artifact.
+func (c *Client) putArtifactType(ctx context.Context, schema string, artifact *pb.Artifact) error {
+	at, err := schemaToArtifactType(schema)
+	if err != nil {
+		return err
+	}
+	if err := validateArtifactProperties(at, artifact); err != nil {
+		return err
+	}
+
+	// Schemas evolve, so allow adding properties to an existing type and
+	// recording it from components built against older versions.
+	var putTypeRes *pb.PutArtifactTypeResponse
+	err = c.call(ctx, "PutArtifactType", func(ctx context.Context) (err error) {
+		putTypeRes, err = c.svc.PutArtifactType(ctx, &pb.PutArtifactTypeRequest{
+			ArtifactType:  at,
+			CanAddFields:  proto.Bool(true),
+			CanOmitFields: proto.Bool(true),
+		})
+		return err
 	})
 	if err != nil {
-		return nil, err
+		return err
 	}
-	if len(res.ArtifactIds) != 1 {
-		return nil, errors.New("Failed to insert exactly one artifact")
+	artifact.TypeId = putTypeRes.TypeId
+	return nil
+}
+
+// putArtifact writes artifact and returns it as stored.
+func (c *Client) putArtifact(ctx context.Context, artifact *pb.Artifact) (*pb.Artifact, error) {
+	req := &pb.PutArtifactsRequest{Artifacts: []*pb.Artifact{artifact}}
+	var id int64
+	rpc := func(ctx context.Context) error {
+		res, err := c.svc.PutArtifacts(ctx, req)
+		if err != nil {
+			return err
+		}
+		if len(res.ArtifactIds) != 1 {
+			return errors.New("Failed to insert exactly one artifact")
+		}
+		id = res.ArtifactIds[0]
+		return nil
 	}
 
-	getRes, err := c.svc.GetArtifactsByID(ctx, &pb.GetArtifactsByIDRequest{ArtifactIds: res.ArtifactIds})
+	var err error
+	if artifact.Id != nil {
+		// Updating an artifact is idempotent.
+		err = c.call(ctx, "PutArtifacts", rpc)
+	} else {
+		var inserted func(ctx context.Context) (bool, error)
+		inserted, err = c.artifactInserted(ctx, artifact, &id)
+		if err != nil {
+			return nil, err
+		}
+		err = c.callWrite(ctx, "PutArtifacts", rpc, inserted)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	artifacts, err := c.GetArtifacts(ctx, []int64{id})
 	if err != nil {
 		return nil, err
 	}
-	if len(getRes.Artifacts) != 1 {
+	if len(artifacts) != 1 {
 		return nil, errors.New("Failed to retrieve exactly one artifact")
 	}
-	return getRes.Artifacts[0], nil
+	return artifacts[0], nil
 }
 
-func getOrInsertContext(ctx context.Context, svc pb.MetadataStoreServiceClient, contextName string, contextType *pb.ContextType) (*pb.Context, error) {
+// putExecution writes req and returns the execution ID. Events that were
+// already recorded for an existing execution are dropped, so that repeating a
+// request does not duplicate lineage.
+func (c *Client) putExecution(ctx context.Context, req *pb.PutExecutionRequest) (int64, error) {
+	e := req.GetExecution()
+	if e.GetId() != 0 && len(req.ArtifactEventPairs) > 0 {
+		pairs, err := c.unrecordedEvents(ctx, e.GetId(), req.ArtifactEventPairs)
+		if err != nil {
+			return 0, err
+		}
+		req.ArtifactEventPairs = pairs
+	}
+
+	var id int64
+	rpc := func(ctx context.Context) error {
+		res, err := c.svc.PutExecution(ctx, req)
+		if err != nil {
+			return err
+		}
+		id = res.GetExecutionId()
+		return nil
+	}
+
+	var err error
+	switch {
+	case e.GetId() == 0:
+		// A new execution can only be found again by its name.
+		err = c.callWrite(ctx, "PutExecution", rpc, c.executionInserted(e, &id))
+	case len(req.ArtifactEventPairs) > 0:
+		// Updating the execution is idempotent, but its events must not be
+		// inserted twice.
+		err = c.callWrite(ctx, "PutExecution", rpc, func(ctx context.Context) (bool, error) {
+			pairs, err := c.unrecordedEvents(ctx, e.GetId(), req.ArtifactEventPairs)
+			req.ArtifactEventPairs = pairs
+			return false, err
+		})
+	default:
+		// Updating an execution is idempotent.
+		err = c.call(ctx, "PutExecution", rpc)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+func (c *Client) getContextByTypeAndName(ctx context.Context, contextName string, contextType *pb.ContextType) (*pb.GetContextByTypeAndNameResponse, error) {
+	var res *pb.GetContextByTypeAndNameResponse
+	err := c.call(ctx, "GetContextByTypeAndName", func(ctx context.Context) (err error) {
+		res, err = c.svc.GetContextByTypeAndName(ctx, &pb.GetContextByTypeAndNameRequest{TypeName: contextType.Name, ContextName: proto.String(contextName)})
+		return err
+	})
+	return res, err
+}
+
+func (c *Client) getOrInsertContext(ctx context.Context, contextName string, contextType *pb.ContextType) (*pb.Context, error) {
 	// See if the context already exists.
-	getCtxRes, err := svc.GetContextByTypeAndName(ctx, &pb.GetContextByTypeAndNameRequest{TypeName: contextType.Name, ContextName: proto.String(contextName)})
+	getCtxRes, err := c.getContextByTypeAndName(ctx, contextName, contextType)
 
 	// Bug in MLMD GetContextsByTypeAndName, where we return status OK even when
 	// no context was found.
@@ -305,7 +711,11 @@ func getOrInsertContext(ctx context.Context, svc pb.MetadataStoreServiceClient,
 	// Otherwise, create the Context.
 	// First, lookup or create the ContextType.
 	var typeID *int64
-	getTypeRes, err := svc.GetContextType(ctx, &pb.GetContextTypeRequest{TypeName: contextType.Name})
+	var getTypeRes *pb.GetContextTypeResponse
+	err = c.call(ctx, "GetContextType", func(ctx context.Context) (err error) {
+		getTypeRes, err = c.svc.GetContextType(ctx, &pb.GetContextTypeRequest{TypeName: contextType.Name})
+		return err
+	})
 	if err == nil {
 		typeID = getTypeRes.ContextType.Id
 	} else {
@@ -313,7 +723,11 @@ func getOrInsertContext(ctx context.Context, svc pb.MetadataStoreServiceClient,
 			return nil, err
 		}
 		// Create the ContextType.
-		res, err := svc.PutContextType(ctx, &pb.PutContextTypeRequest{ContextType: contextType})
+		var res *pb.PutContextTypeResponse
+		err = c.call(ctx, "PutContextType", func(ctx context.Context) (err error) {
+			res, err = c.svc.PutContextType(ctx, &pb.PutContextTypeRequest{ContextType: contextType})
+			return err
+		})
 		if err != nil {
 			return nil, err
 		}
@@ -329,12 +743,18 @@ func getOrInsertContext(ctx context.Context, svc pb.MetadataStoreServiceClient,
 			},
 		},
 	}
-	_, err = svc.PutContexts(ctx, putReq)
+	err = c.callWrite(ctx, "PutContexts", func(ctx context.Context) error {
+		_, err := c.svc.PutContexts(ctx, putReq)
+		return err
+	}, func(ctx context.Context) (bool, error) {
+		res, err := c.getContextByTypeAndName(ctx, contextName, contextType)
+		return res.GetContext() != nil, err
+	})
 	if err != nil {
 		return nil, err
 	}
 
 	// Get the created context.
-	getCtxRes, err = svc.GetContextByTypeAndName(ctx, &pb.GetContextByTypeAndNameRequest{TypeName: contextType.Name, ContextName: proto.String(contextName)})
+	getCtxRes, err = c.getContextByTypeAndName(ctx, contextName, contextType)
 	return getCtxRes.GetContext(), err
 }
@@ -1,11 +1,15 @@
 package metadata
 
 import (
+	"context"
+	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
 	pb "github.com/neuromage/kfp-launcher/third_party/ml_metadata"
+	"google.golang.org/grpc"
 	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/testing/protocmp"
 )
@@ -25,6 +29,35 @@ func Test_schemaToArtifactType(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name:   "Parses Schema Properties",
+			schema: "title: kfp.Model\ntype: object\nproperties:\n  framework:\n    type: string\n  epochs:\n    type: integer\n  accuracy:\n    type: number\n  hyperparameters:\n    type: object\n  labels:\n    type: array\n",
+			want: &pb.ArtifactType{
+				Name: proto.String("kfp.Model"),
+				Properties: map[string]pb.PropertyType{
+					"framework":       pb.PropertyType_STRING,
+					"epochs":          pb.PropertyType_INT,
+					"accuracy":        pb.PropertyType_DOUBLE,
+					"hyperparameters": pb.PropertyType_STRUCT,
+					"labels":          pb.PropertyType_STRUCT,
+				},
+			},
+		},
+		{
+			name:    "Missing Title",
+			schema:  "type: object\n",
+			wantErr: true,
+		},
+		{
+			name:    "Property Without Type",
+			schema:  "title: kfp.Model\nproperties:\n  framework:\n",
+			wantErr: true,
+		},
+		{
+			name:    "Unsupported Property Type",
+			schema:  "title: kfp.Model\nproperties:\n  trained:\n    type: boolean\n",
+			wantErr: true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -39,3 +72,529 @@ func Test_schemaToArtifactType(t *testing.T) {
 		})
 	}
 }
+
+// fakeMetadataStore records the requests it receives. Methods that are not
+// overridden panic when called.
+type fakeMetadataStore struct {
+	pb.MetadataStoreServiceClient
+
+	putExecutionRequests []*pb.PutExecutionRequest
+	executions           []*pb.Execution
+	events               []*pb.Event
+	artifacts            []*pb.Artifact
+	contexts             []*pb.Context
+	associations         []*pb.Association
+}
+
+func (f *fakeMetadataStore) GetExecutionsByType(ctx context.Context, in *pb.GetExecutionsByTypeRequest, opts ...grpc.CallOption) (*pb.GetExecutionsByTypeResponse, error) {
+	return &pb.GetExecutionsByTypeResponse{Executions: f.executions}, nil
+}
+
+func (f *fakeMetadataStore) GetContextByTypeAndName(ctx context.Context, in *pb.GetContextByTypeAndNameRequest, opts ...grpc.CallOption) (*pb.GetContextByTypeAndNameResponse, error) {
+	for _, c := range f.contexts {
+		if c.GetName() == in.GetContextName() {
+			return &pb.GetContextByTypeAndNameResponse{Context: c}, nil
+		}
+	}
+	return &pb.GetContextByTypeAndNameResponse{}, nil
+}
+
+// GetExecutionsByContext returns all associated executions in one page,
+// ordered by ID as requested.
+func (f *fakeMetadataStore) GetExecutionsByContext(ctx context.Context, in *pb.GetExecutionsByContextRequest, opts ...grpc.CallOption) (*pb.GetExecutionsByContextResponse, error) {
+	res := &pb.GetExecutionsByContextResponse{}
+	for _, e := range f.executions {
+		for _, a := range f.associations {
+			if a.GetContextId() == in.GetContextId() && a.GetExecutionId() == e.GetId() {
+				res.Executions = append(res.Executions, e)
+			}
+		}
+	}
+	if !in.GetOptions().GetOrderByField().GetIsAsc() {
+		for i, j := 0, len(res.Executions)-1; i < j; i, j = i+1, j-1 {
+			res.Executions[i], res.Executions[j] = res.Executions[j], res.Executions[i]
+		}
+	}
+	return res, nil
+}
+
+func (f *fakeMetadataStore) GetEventsByExecutionIDs(ctx context.Context, in *pb.GetEventsByExecutionIDsRequest, opts ...grpc.CallOption) (*pb.GetEventsByExecutionIDsResponse, error) {
+	res := &pb.GetEventsByExecutionIDsResponse{}
+	for _, e := range f.events {
+		for _, id := range in.GetExecutionIds() {
+			if e.GetExecutionId() == id {
+				res.Events = append(res.Events, e)
+			}
+		}
+	}
+	return res, nil
+}
+
+func (f *fakeMetadataStore) GetArtifactsByID(ctx context.Context, in *pb.GetArtifactsByIDRequest, opts ...grpc.CallOption) (*pb.GetArtifactsByIDResponse, error) {
+	res := &pb.GetArtifactsByIDResponse{}
+	for _, a := range f.artifacts {
+		for _, id := range in.GetArtifactIds() {
+			if a.GetId() == id {
+				res.Artifacts = append(res.Artifacts, a)
+			}
+		}
+	}
+	return res, nil
+}
+
+func (f *fakeMetadataStore) GetExecutionsByID(ctx context.Context, in *pb.GetExecutionsByIDRequest, opts ...grpc.CallOption) (*pb.GetExecutionsByIDResponse, error) {
+	res := &pb.GetExecutionsByIDResponse{}
+	for _, e := range f.executions {
+		for _, id := range in.GetExecutionIds() {
+			if e.GetId() == id {
+				res.Executions = append(res.Executions, proto.Clone(e).(*pb.Execution))
+			}
+		}
+	}
+	return res, nil
+}
+
+func (f *fakeMetadataStore) GetExecutionByTypeAndName(ctx context.Context, in *pb.GetExecutionByTypeAndNameRequest, opts ...grpc.CallOption) (*pb.GetExecutionByTypeAndNameResponse, error) {
+	for _, e := range f.executions {
+		if e.GetName() == in.GetExecutionName() {
+			return &pb.GetExecutionByTypeAndNameResponse{Execution: proto.Clone(e).(*pb.Execution)}, nil
+		}
+	}
+	return &pb.GetExecutionByTypeAndNameResponse{}, nil
+}
+
+func (f *fakeMetadataStore) GetArtifactsByURI(ctx context.Context, in *pb.GetArtifactsByURIRequest, opts ...grpc.CallOption) (*pb.GetArtifactsByURIResponse, error) {
+	res := &pb.GetArtifactsByURIResponse{}
+	for _, a := range f.artifacts {
+		for _, uri := range in.GetUris() {
+			if a.GetUri() == uri {
+				res.Artifacts = append(res.Artifacts, a)
+			}
+		}
+	}
+	return res, nil
+}
+
+func (f *fakeMetadataStore) PutExecutionType(ctx context.Context, in *pb.PutExecutionTypeRequest, opts ...grpc.CallOption) (*pb.PutExecutionTypeResponse, error) {
+	return &pb.PutExecutionTypeResponse{TypeId: proto.Int64(100)}, nil
+}
+
+func (f *fakeMetadataStore) PutArtifactType(ctx context.Context, in *pb.PutArtifactTypeRequest, opts ...grpc.CallOption) (*pb.PutArtifactTypeResponse, error) {
+	return &pb.PutArtifactTypeResponse{TypeId: proto.Int64(200)}, nil
+}
+
+func (f *fakeMetadataStore) PutArtifacts(ctx context.Context, in *pb.PutArtifactsRequest, opts ...grpc.CallOption) (*pb.PutArtifactsResponse, error) {
+	res := &pb.PutArtifactsResponse{}
+	for _, a := range in.GetArtifacts() {
+		a = proto.Clone(a).(*pb.Artifact)
+		if a.Id == nil {
+			a.Id = proto.Int64(int64(len(f.artifacts) + 1))
+			f.artifacts = append(f.artifacts, a)
+		} else {
+			for i := range f.artifacts {
+				if f.artifacts[i].GetId() == a.GetId() {
+					f.artifacts[i] = a
+				}
+			}
+		}
+		res.ArtifactIds = append(res.ArtifactIds, a.GetId())
+	}
+	return res, nil
+}
+
+// PutExecution inserts or updates the execution and records its events.
+func (f *fakeMetadataStore) PutExecution(ctx context.Context, in *pb.PutExecutionRequest, opts ...grpc.CallOption) (*pb.PutExecutionResponse, error) {
+	f.putExecutionRequests = append(f.putExecutionRequests, proto.Clone(in).(*pb.PutExecutionRequest))
+
+	e := proto.Clone(in.GetExecution()).(*pb.Execution)
+	if e.Id == nil {
+		e.Id = proto.Int64(int64(len(f.executions) + 1))
+	}
+	replaced := false
+	for i := range f.executions {
+		if f.executions[i].GetId() == e.GetId() {
+			f.executions[i] = e
+			replaced = true
+		}
+	}
+	if !replaced {
+		f.executions = append(f.executions, e)
+	}
+	for _, p := range in.GetArtifactEventPairs() {
+		ev := proto.Clone(p.GetEvent()).(*pb.Event)
+		ev.ExecutionId = e.Id
+		f.events = append(f.events, ev)
+	}
+	return &pb.PutExecutionResponse{ExecutionId: e.Id}, nil
+}
+
+func TestFailExecution(t *testing.T) {
+	fake := &fakeMetadataStore{}
+	c := &Client{svc: fake}
+
+	pipeline := &Pipeline{
+		pipelineCtx:    &pb.Context{Id: proto.Int64(1), Name: proto.String("my-pipeline")},
+		pipelineRunCtx: &pb.Context{Id: proto.Int64(2), Name: proto.String("my-run")},
+	}
+	execution := &Execution{
+		pipeline: pipeline,
+		execution: &pb.Execution{
+			Id:               proto.Int64(3),
+			LastKnownState:   pb.Execution_RUNNING.Enum(),
+			CustomProperties: map[string]*pb.Value{"task_name": stringValue("trainer")},
+		},
+	}
+
+	failure := &ExecutionFailure{
+		ExitCode: 137,
+		Signal:   "killed",
+		Message:  strings.Repeat("x", maxErrorMessageLength+1),
+	}
+	if err := c.FailExecution(context.Background(), execution, failure); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(fake.putExecutionRequests) != 1 {
+		t.Fatalf("Got %d PutExecution requests, want 1", len(fake.putExecutionRequests))
+	}
+	want := &pb.PutExecutionRequest{
+		Execution: &pb.Execution{
+			Id:             proto.Int64(3),
+			LastKnownState: pb.Execution_FAILED.Enum(),
+			CustomProperties: map[string]*pb.Value{
+				"task_name": stringValue("trainer"),
+				"exit_code": intValue(137),
+				"signal":    stringValue("killed"),
+				"error":     stringValue(strings.Repeat("x", maxErrorMessageLength) + "...(truncated)"),
+			},
+		},
+		Contexts: []*pb.Context{pipeline.pipelineCtx, pipeline.pipelineRunCtx},
+	}
+	if diff := cmp.Diff(want, fake.putExecutionRequests[0], protocmp.Transform()); diff != "" {
+		t.Errorf("PutExecution request mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestGetCachedExecution(t *testing.T) {
+	execution := func(id int64, state pb.Execution_State, fingerprint string) *pb.Execution {
+		return &pb.Execution{
+			Id:             proto.Int64(id),
+			LastKnownState: state.Enum(),
+			CustomProperties: map[string]*pb.Value{
+				cacheFingerprintProperty: stringValue(fingerprint),
+				"output:accuracy":        doubleValue(float64(id)),
+			},
+		}
+	}
+	fake := &fakeMetadataStore{
+		executions: []*pb.Execution{
+			execution(1, pb.Execution_COMPLETE, "fp"),
+			execution(2, pb.Execution_COMPLETE, "fp"),
+			execution(3, pb.Execution_FAILED, "fp"),
+			execution(4, pb.Execution_COMPLETE, "other"),
+		},
+		events: []*pb.Event{
+			{ExecutionId: proto.Int64(2), ArtifactId: proto.Int64(10), Type: pb.Event_INPUT.Enum(), Path: eventPath("dataset")},
+			{ExecutionId: proto.Int64(2), ArtifactId: proto.Int64(11), Type: pb.Event_OUTPUT.Enum(), Path: eventPath("model")},
+		},
+		artifacts: []*pb.Artifact{
+			{Id: proto.Int64(10), Uri: proto.String("gs://b/dataset")},
+			{Id: proto.Int64(11), Uri: proto.String("gs://b/model")},
+		},
+		contexts: []*pb.Context{
+			{Id: proto.Int64(20), Name: proto.String("fp")},
+			{Id: proto.Int64(21), Name: proto.String("other")},
+		},
+		associations: []*pb.Association{
+			{ExecutionId: proto.Int64(1), ContextId: proto.Int64(20)},
+			{ExecutionId: proto.Int64(2), ContextId: proto.Int64(20)},
+			{ExecutionId: proto.Int64(3), ContextId: proto.Int64(20)},
+			{ExecutionId: proto.Int64(4), ContextId: proto.Int64(21)},
+		},
+	}
+	c := &Client{svc: fake}
+
+	got, err := c.GetCachedExecution(context.Background(), "fp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &CachedExecution{
+		ExecutionID:      2,
+		OutputParameters: map[string]*pb.Value{"accuracy": doubleValue(2)},
+		OutputArtifacts: map[string]*pb.Artifact{
+			"model": {Id: proto.Int64(11), Uri: proto.String("gs://b/model")},
+		},
+	}
+	if diff := cmp.Diff(want, got, protocmp.Transform()); diff != "" {
+		t.Errorf("GetCachedExecution() mismatch (-want +got):\n%s", diff)
+	}
+
+	got, err = c.GetCachedExecution(context.Background(), "missing")
+	if err != nil || got != nil {
+		t.Errorf("GetCachedExecution() for unknown fingerprint = %v, %v, want nil, nil", got, err)
+	}
+}
+
+func TestCreateExecution_Idempotent(t *testing.T) {
+	fake := &fakeMetadataStore{}
+	c := &Client{svc: fake}
+	ctx := context.Background()
+
+	pipeline := &Pipeline{
+		pipelineCtx:    &pb.Context{Id: proto.Int64(1), Name: proto.String("my-pipeline")},
+		pipelineRunCtx: &pb.Context{Id: proto.Int64(2), Name: proto.String("my-run")},
+	}
+	config := func(attempt int) *ExecutionConfig {
+		return &ExecutionConfig{
+			InputParameters: &Parameters{},
+			InputArtifacts: []*InputArtifact{
+				{Name: "dataset", Artifact: &pb.Artifact{Id: proto.Int64(10)}},
+			},
+			RetryAttempt: attempt,
+		}
+	}
+
+	first, err := c.CreateExecution(ctx, pipeline, "trainer", "task-1", "image", config(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := first.execution.GetName(), "my-run/task-1/attempt-0"; got != want {
+		t.Errorf("Execution name = %q, want %q", got, want)
+	}
+
+	// Registering the same attempt again attaches to the existing execution.
+	again, err := c.CreateExecution(ctx, pipeline, "trainer", "task-1", "image", config(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.execution.GetId() != first.execution.GetId() {
+		t.Errorf("Re-registered execution ID = %d, want %d", again.execution.GetId(), first.execution.GetId())
+	}
+	if len(fake.executions) != 1 || len(fake.events) != 1 {
+		t.Errorf("Got %d executions and %d events, want 1 and 1", len(fake.executions), len(fake.events))
+	}
+
+	// The next attempt supersedes the abandoned one.
+	retry, err := c.CreateExecution(ctx, pipeline, "trainer", "task-1", "image", config(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if retry.execution.GetId() == first.execution.GetId() {
+		t.Fatalf("Retry attempt reused execution %d", first.execution.GetId())
+	}
+	prev := fake.executions[0]
+	if got := prev.GetLastKnownState(); got != pb.Execution_FAILED {
+		t.Errorf("Superseded execution state = %v, want FAILED", got)
+	}
+	if diff := cmp.Diff(intValue(retry.execution.GetId()), prev.GetCustomProperties()[supersededByProperty], protocmp.Transform()); diff != "" {
+		t.Errorf("superseded_by mismatch (-want +got):\n%s", diff)
+	}
+
+	// Launching a finished attempt again leaves its record unchanged.
+	fake.executions[1].LastKnownState = pb.Execution_COMPLETE.Enum()
+	want := proto.Clone(fake.executions[1])
+	if _, err := c.CreateExecution(ctx, pipeline, "trainer", "task-1", "image", config(1)); err == nil {
+		t.Error("CreateExecution() of a completed attempt succeeded")
+	}
+	if diff := cmp.Diff(want, fake.executions[1], protocmp.Transform()); diff != "" {
+		t.Errorf("Completed execution changed (-want +got):\n%s", diff)
+	}
+	if len(fake.executions) != 2 {
+		t.Errorf("Got %d executions, want 2", len(fake.executions))
+	}
+}
+
+func TestRecordArtifact_DeduplicatesByURI(t *testing.T) {
+	fake := &fakeMetadataStore{}
+	c := &Client{svc: fake}
+	ctx := context.Background()
+	schema := "title: kfp.Model\n"
+
+	first, err := c.RecordArtifact(ctx, schema, &pb.Artifact{Uri: proto.String("gs://b/model")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := c.RecordArtifact(ctx, schema, &pb.Artifact{
+		Uri:              proto.String("gs://b/model"),
+		CustomProperties: map[string]*pb.Value{"accuracy": doubleValue(0.9)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if second.GetId() != first.GetId() {
+		t.Errorf("Recorded artifact ID = %d, want %d", second.GetId(), first.GetId())
+	}
+	if len(fake.artifacts) != 1 {
+		t.Errorf("Got %d artifacts, want 1", len(fake.artifacts))
+	}
+	if second.GetCustomProperties()["accuracy"] == nil {
+		t.Errorf("Existing artifact was not updated: %v", second)
+	}
+}
+
+func TestImportArtifact(t *testing.T) {
+	fake := &fakeMetadataStore{}
+	c := &Client{svc: fake}
+	ctx := context.Background()
+	schema := "title: kfp.Dataset\n"
+
+	first, err := c.ImportArtifact(ctx, schema, &pb.Artifact{Uri: proto.String("gs://b/external")}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Importing the same URI again reuses the artifact as is.
+	second, err := c.ImportArtifact(ctx, schema, &pb.Artifact{
+		Uri:              proto.String("gs://b/external"),
+		CustomProperties: map[string]*pb.Value{"version": stringValue("2")},
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.GetId() != first.GetId() || second.GetCustomProperties()["version"] != nil {
+		t.Errorf("ImportArtifact() = %v, want existing artifact %v", second, first)
+	}
+
+	reimported, err := c.ImportArtifact(ctx, schema, &pb.Artifact{Uri: proto.String("gs://b/external")}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reimported.GetId() == first.GetId() {
+		t.Errorf("ImportArtifact() with reimport reused artifact %d", first.GetId())
+	}
+	if len(fake.artifacts) != 2 {
+		t.Errorf("Got %d artifacts, want 2", len(fake.artifacts))
+	}
+}
+
+func TestCancelExecution(t *testing.T) {
+	fake := &fakeMetadataStore{}
+	c := &Client{svc: fake}
+
+	pipeline := &Pipeline{
+		pipelineCtx:    &pb.Context{Id: proto.Int64(1), Name: proto.String("my-pipeline")},
+		pipelineRunCtx: &pb.Context{Id: proto.Int64(2), Name: proto.String("my-run")},
+	}
+	execution := &Execution{
+		pipeline:  pipeline,
+		execution: &pb.Execution{Id: proto.Int64(3), LastKnownState: pb.Execution_RUNNING.Enum()},
+	}
+	partial := []*OutputArtifact{{Name: "checkpoint", Artifact: &pb.Artifact{Id: proto.Int64(4)}}}
+
+	failure := &ExecutionFailure{ExitCode: 143, Signal: "terminated", Message: "User command terminated by terminated"}
+	if err := c.CancelExecution(context.Background(), execution, failure, partial); err != nil {
+		t.Fatal(err)
+	}
+
+	want := &pb.PutExecutionRequest{
+		Execution: &pb.Execution{
+			Id:             proto.Int64(3),
+			LastKnownState: pb.Execution_CANCELED.Enum(),
+			CustomProperties: map[string]*pb.Value{
+				"exit_code": intValue(143),
+				"signal":    stringValue("terminated"),
+				"error":     stringValue("User command terminated by terminated"),
+			},
+		},
+		ArtifactEventPairs: []*pb.PutExecutionRequest_ArtifactAndEvent{{
+			Event: &pb.Event{Type: pb.Event_OUTPUT.Enum(), ArtifactId: proto.Int64(4), Path: eventPath("checkpoint")},
+		}},
+		Contexts: []*pb.Context{pipeline.pipelineCtx, pipeline.pipelineRunCtx},
+	}
+	if len(fake.putExecutionRequests) != 1 {
+		t.Fatalf("Got %d PutExecution requests, want 1", len(fake.putExecutionRequests))
+	}
+	if diff := cmp.Diff(want, fake.putExecutionRequests[0], protocmp.Transform()); diff != "" {
+		t.Errorf("PutExecution request mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func Test_validateArtifactProperties(t *testing.T) {
+	at := &pb.ArtifactType{
+		Name: proto.String("kfp.Model"),
+		Properties: map[string]pb.PropertyType{
+			"framework": pb.PropertyType_STRING,
+			"accuracy":  pb.PropertyType_DOUBLE,
+		},
+	}
+	tests := []struct {
+		name       string
+		properties map[string]*pb.Value
+		want       map[string]*pb.Value
+		wantErr    bool
+	}{
+		{
+			name:       "Declared properties",
+			properties: map[string]*pb.Value{"framework": stringValue("tf"), "accuracy": doubleValue(0.9)},
+			want:       map[string]*pb.Value{"framework": stringValue("tf"), "accuracy": doubleValue(0.9)},
+		},
+		{
+			name:       "Integers are converted for DOUBLE properties",
+			properties: map[string]*pb.Value{"accuracy": intValue(1)},
+			want:       map[string]*pb.Value{"accuracy": doubleValue(1)},
+		},
+		{
+			name:       "Undeclared property",
+			properties: map[string]*pb.Value{"owner": stringValue("me")},
+			wantErr:    true,
+		},
+		{
+			name:       "Mismatched type",
+			properties: map[string]*pb.Value{"framework": intValue(2)},
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			artifact := &pb.Artifact{Properties: tt.properties}
+			err := validateArtifactProperties(at, artifact)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("validateArtifactProperties() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if diff := cmp.Diff(tt.want, artifact.GetProperties(), protocmp.Transform()); diff != "" {
+				t.Errorf("Properties mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestCreateExecution_Iteration(t *testing.T) {
+	fake := &fakeMetadataStore{}
+	c := &Client{svc: fake}
+	ctx := context.Background()
+
+	iteratorCtx := &pb.Context{Id: proto.Int64(3), Name: proto.String("my-run/task-1")}
+	pipeline := &Pipeline{
+		pipelineCtx:    &pb.Context{Id: proto.Int64(1), Name: proto.String("my-pipeline")},
+		pipelineRunCtx: &pb.Context{Id: proto.Int64(2), Name: proto.String("my-run")},
+		iteratorCtx:    iteratorCtx,
+	}
+	for i := 0; i < 2; i++ {
+		i := i
+		e, err := c.CreateExecution(ctx, pipeline, "trainer", "task-1", "image", &ExecutionConfig{
+			InputParameters: &Parameters{},
+			Iteration:       &i,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := e.execution.GetName(), fmt.Sprintf("my-run/task-1/iteration-%d/attempt-0", i); got != want {
+			t.Errorf("Execution name = %q, want %q", got, want)
+		}
+		if diff := cmp.Diff(intValue(int64(i)), e.execution.GetCustomProperties()[iterationIndexProperty], protocmp.Transform()); diff != "" {
+			t.Errorf("iteration_index mismatch (-want +got):\n%s", diff)
+		}
+	}
+
+	// Iterations are separate executions in the iterator context.
+	if len(fake.executions) != 2 {
+		t.Fatalf("Got %d executions, want 2", len(fake.executions))
+	}
+	contexts := fake.putExecutionRequests[len(fake.putExecutionRequests)-1].GetContexts()
+	if len(contexts) != 3 || contexts[2].GetId() != iteratorCtx.GetId() {
+		t.Errorf("Execution contexts = %v, want the pipeline, run and iterator contexts", contexts)
+	}
+}
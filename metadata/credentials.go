@@ -0,0 +1,132 @@
+package metadata
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// ClientOptions configures how the Client connects to the metadata store.
+// The zero value connects over plaintext, without authentication.
+type ClientOptions struct {
+	// UseTLS connects over TLS, verifying the server against the system roots
+	// unless CACertFile is set. It is implied by CACertFile and ClientCertFile.
+	UseTLS bool
+	// CACertFile is a PEM bundle of CAs used to verify the server.
+	CACertFile string
+	// ClientCertFile and ClientKeyFile hold the PEM client certificate and key
+	// used for mutual TLS.
+	ClientCertFile string
+	ClientKeyFile  string
+	// ServerNameOverride replaces the server name used to verify the server
+	// certificate.
+	ServerNameOverride string
+	// TokenFile holds a bearer token sent with every RPC. The file is re-read
+	// whenever it changes, so the token may be rotated while running. Requires
+	// TLS.
+	TokenFile string
+
+	// Retry controls retries and deadlines of individual RPCs. Defaults to
+	// DefaultRetryOptions when nil.
+	Retry *RetryOptions
+}
+
+func (o *ClientOptions) tlsEnabled() bool {
+	return o.UseTLS || len(o.CACertFile) > 0 || len(o.ClientCertFile) > 0
+}
+
+// dialOptions returns the gRPC dial options for o.
+func (o *ClientOptions) dialOptions() ([]grpc.DialOption, error) {
+	if (len(o.ClientCertFile) > 0) != (len(o.ClientKeyFile) > 0) {
+		return nil, errors.New("ClientCertFile and ClientKeyFile must be specified together")
+	}
+	if !o.tlsEnabled() {
+		if len(o.TokenFile) > 0 {
+			return nil, errors.New("TokenFile requires a TLS connection to the metadata store")
+		}
+		return []grpc.DialOption{grpc.WithInsecure()}, nil
+	}
+
+	cfg := &tls.Config{ServerName: o.ServerNameOverride}
+	if len(o.CACertFile) > 0 {
+		pem, err := ioutil.ReadFile(o.CACertFile)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read CA bundle: %v", err)
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("No certificates found in CA bundle %q", o.CACertFile)
+		}
+	}
+	if len(o.ClientCertFile) > 0 {
+		cert, err := tls.LoadX509KeyPair(o.ClientCertFile, o.ClientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to load client certificate: %v", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	opts := []grpc.DialOption{grpc.WithTransportCredentials(credentials.NewTLS(cfg))}
+	if len(o.TokenFile) > 0 {
+		opts = append(opts, grpc.WithPerRPCCredentials(&tokenFileCredentials{path: o.TokenFile}))
+	}
+	return opts, nil
+}
+
+// tokenFileCredentials attaches a bearer token read from a file to every RPC.
+// The token is cached and reloaded when the file's modification time or size
+// changes.
+type tokenFileCredentials struct {
+	path string
+
+	mu      sync.Mutex
+	token   string
+	modTime time.Time
+	size    int64
+}
+
+func (c *tokenFileCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	token, err := c.currentToken()
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{"authorization": "Bearer " + token}, nil
+}
+
+func (c *tokenFileCredentials) RequireTransportSecurity() bool {
+	return true
+}
+
+func (c *tokenFileCredentials) currentToken() (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	info, err := os.Stat(c.path)
+	if err != nil {
+		return "", fmt.Errorf("Failed to read metadata store token: %v", err)
+	}
+	if len(c.token) > 0 && info.ModTime().Equal(c.modTime) && info.Size() == c.size {
+		return c.token, nil
+	}
+
+	b, err := ioutil.ReadFile(c.path)
+	if err != nil {
+		return "", fmt.Errorf("Failed to read metadata store token: %v", err)
+	}
+	token := strings.TrimSpace(string(b))
+	if len(token) == 0 {
+		return "", fmt.Errorf("Metadata store token file %q is empty", c.path)
+	}
+	c.token, c.modTime, c.size = token, info.ModTime(), info.Size()
+	return c.token, nil
+}
@@ -0,0 +1,208 @@
+package metadata
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	pb "github.com/neuromage/kfp-launcher/third_party/ml_metadata"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	grpcmetadata "google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// authEchoServer echoes the caller's authorization header back as the
+// context type name.
+type authEchoServer struct {
+	pb.UnimplementedMetadataStoreServiceServer
+}
+
+func (s *authEchoServer) GetContextType(ctx context.Context, req *pb.GetContextTypeRequest) (*pb.GetContextTypeResponse, error) {
+	md, _ := grpcmetadata.FromIncomingContext(ctx)
+	auth := md.Get("authorization")
+	if len(auth) != 1 {
+		return nil, status.Error(codes.Unauthenticated, "missing authorization")
+	}
+	return &pb.GetContextTypeResponse{ContextType: &pb.ContextType{Name: proto.String(auth[0])}}, nil
+}
+
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+func newTestCert(t *testing.T, template *x509.Certificate, parent *testCert) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signerCert, signerKey := template, key
+	if parent != nil {
+		signerCert, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signerCert, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+func writeTestFile(t *testing.T, dir, name string, contents []byte) string {
+	t.Helper()
+	p := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(p, contents, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestNewClient_MutualTLSWithToken(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kfp-launcher-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	notAfter := time.Now().Add(time.Hour)
+	ca := newTestCert(t, &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotAfter:              notAfter,
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}, nil)
+	serverCert := newTestCert(t, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "mlmd"},
+		DNSNames:     []string{"metadata-grpc-service.kubeflow"},
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca)
+	clientCert := newTestCert(t, &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: "launcher"},
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca)
+
+	serverKeyPair, err := tls.X509KeyPair(serverCert.certPEM, serverCert.keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.cert)
+	server := grpc.NewServer(grpc.Creds(credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{serverKeyPair},
+		ClientCAs:    clientCAs,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	})))
+	pb.RegisterMetadataStoreServiceServer(server, &authEchoServer{})
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(lis)
+	defer server.Stop()
+
+	tokenFile := writeTestFile(t, dir, "token", []byte("first-token\n"))
+	host, port, err := net.SplitHostPort(lis.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewClient(host, port, &ClientOptions{
+		CACertFile:         writeTestFile(t, dir, "ca.pem", ca.certPEM),
+		ClientCertFile:     writeTestFile(t, dir, "client.pem", clientCert.certPEM),
+		ClientKeyFile:      writeTestFile(t, dir, "client-key.pem", clientCert.keyPEM),
+		ServerNameOverride: "metadata-grpc-service.kubeflow",
+		TokenFile:          tokenFile,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authHeader := func() string {
+		t.Helper()
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		res, err := c.svc.GetContextType(ctx, &pb.GetContextTypeRequest{TypeName: proto.String("t")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.GetContextType().GetName()
+	}
+
+	if got := authHeader(); got != "Bearer first-token" {
+		t.Errorf("authorization = %q, want %q", got, "Bearer first-token")
+	}
+
+	// Rotate the token; the next RPC must pick it up.
+	if err := ioutil.WriteFile(tokenFile, []byte("rotated-token-value"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if got := authHeader(); got != "Bearer rotated-token-value" {
+		t.Errorf("authorization after rotation = %q, want %q", got, "Bearer rotated-token-value")
+	}
+}
+
+func TestClientOptions_dialOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    *ClientOptions
+		wantErr string
+	}{
+		{name: "Plaintext", opts: &ClientOptions{}},
+		{name: "TLS with system roots", opts: &ClientOptions{UseTLS: true}},
+		{name: "Token requires TLS", opts: &ClientOptions{TokenFile: "/var/run/token"}, wantErr: "requires a TLS"},
+		{name: "Client cert without key", opts: &ClientOptions{ClientCertFile: "/tls/cert.pem"}, wantErr: "must be specified together"},
+		{name: "Missing CA bundle", opts: &ClientOptions{CACertFile: "/does/not/exist"}, wantErr: "Failed to read CA bundle"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.opts.dialOptions()
+			if len(tt.wantErr) == 0 {
+				if err != nil {
+					t.Errorf("dialOptions() error = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("dialOptions() error = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package embedded
+
+import (
+	"net"
+	"strconv"
+
+	pb "github.com/neuromage/kfp-launcher/third_party/ml_metadata"
+	"google.golang.org/grpc"
+)
+
+// Endpoint is a server listening on a loopback port, which metadata clients
+// can be pointed at.
+type Endpoint struct {
+	// Address and Port are the host and port to connect to, as accepted by
+	// metadata.NewClient.
+	Address string
+	Port    string
+
+	grpcServer *grpc.Server
+}
+
+// Serve serves s over gRPC on a free loopback port until Stop is called.
+func Serve(s *Server) (*Endpoint, error) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	grpcServer := grpc.NewServer()
+	pb.RegisterMetadataStoreServiceServer(grpcServer, s)
+	go grpcServer.Serve(lis)
+
+	addr := lis.Addr().(*net.TCPAddr)
+	return &Endpoint{
+		Address:    addr.IP.String(),
+		Port:       strconv.Itoa(addr.Port),
+		grpcServer: grpcServer,
+	}, nil
+}
+
+// Stop stops serving, after the pending RPCs complete.
+func (e *Endpoint) Stop() {
+	e.grpcServer.GracefulStop()
+}
+
+// Start serves a new server, keeping metadata in the file at path, or in
+// memory if path is empty.
+func Start(path string) (*Endpoint, error) {
+	s, err := NewServer(path)
+	if err != nil {
+		return nil, err
+	}
+	return Serve(s)
+}
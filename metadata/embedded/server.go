@@ -0,0 +1,1073 @@
+package embedded
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"time"
+
+	pb "github.com/neuromage/kfp-launcher/third_party/ml_metadata"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+func now() int64 {
+	return time.Now().UnixNano() / int64(time.Millisecond)
+}
+
+// index returns the index of the element with ID id in a table of n
+// elements, or -1 if there is none.
+func index(id int64, n int) int {
+	if id < 1 || id > int64(n) {
+		return -1
+	}
+	return int(id - 1)
+}
+
+// mergeProperties checks properties, those of a type being put, against the
+// stored properties of the type, as MLMD does, and returns the properties of
+// the updated type.
+func mergeProperties(name string, stored, properties map[string]pb.PropertyType, canAdd, canOmit bool) (map[string]pb.PropertyType, error) {
+	merged := make(map[string]pb.PropertyType)
+	for k, t := range stored {
+		merged[k] = t
+		nt, ok := properties[k]
+		if !ok && !canOmit {
+			return nil, status.Errorf(codes.AlreadyExists, "Type %q has property %q, which is omitted", name, k)
+		}
+		if ok && nt != t {
+			return nil, status.Errorf(codes.AlreadyExists, "Property %q of type %q is %v, got %v", k, name, t, nt)
+		}
+	}
+	for k, t := range properties {
+		if _, ok := stored[k]; !ok {
+			if !canAdd {
+				return nil, status.Errorf(codes.AlreadyExists, "Type %q has no property %q", name, k)
+			}
+			merged[k] = t
+		}
+	}
+	return merged, nil
+}
+
+func (st *state) putArtifactType(t *pb.ArtifactType, canAdd, canOmit bool) (int64, error) {
+	if len(t.GetName()) == 0 {
+		return 0, status.Error(codes.InvalidArgument, "Artifact type must have a name")
+	}
+	for i, e := range st.ArtifactTypes {
+		if e.GetName() == t.GetName() {
+			properties, err := mergeProperties(t.GetName(), e.GetProperties(), t.GetProperties(), canAdd, canOmit)
+			if err != nil {
+				return 0, err
+			}
+			c := proto.Clone(e).(*pb.ArtifactType)
+			c.Properties = properties
+			st.ArtifactTypes[i] = c
+			return c.GetId(), nil
+		}
+	}
+	c := proto.Clone(t).(*pb.ArtifactType)
+	c.Id = proto.Int64(int64(len(st.ArtifactTypes) + 1))
+	st.ArtifactTypes = append(st.ArtifactTypes, c)
+	return c.GetId(), nil
+}
+
+func (st *state) putExecutionType(t *pb.ExecutionType, canAdd, canOmit bool) (int64, error) {
+	if len(t.GetName()) == 0 {
+		return 0, status.Error(codes.InvalidArgument, "Execution type must have a name")
+	}
+	for i, e := range st.ExecutionTypes {
+		if e.GetName() == t.GetName() {
+			properties, err := mergeProperties(t.GetName(), e.GetProperties(), t.GetProperties(), canAdd, canOmit)
+			if err != nil {
+				return 0, err
+			}
+			c := proto.Clone(e).(*pb.ExecutionType)
+			c.Properties = properties
+			st.ExecutionTypes[i] = c
+			return c.GetId(), nil
+		}
+	}
+	c := proto.Clone(t).(*pb.ExecutionType)
+	c.Id = proto.Int64(int64(len(st.ExecutionTypes) + 1))
+	st.ExecutionTypes = append(st.ExecutionTypes, c)
+	return c.GetId(), nil
+}
+
+func (st *state) putContextType(t *pb.ContextType, canAdd, canOmit bool) (int64, error) {
+	if len(t.GetName()) == 0 {
+		return 0, status.Error(codes.InvalidArgument, "Context type must have a name")
+	}
+	for i, e := range st.ContextTypes {
+		if e.GetName() == t.GetName() {
+			properties, err := mergeProperties(t.GetName(), e.GetProperties(), t.GetProperties(), canAdd, canOmit)
+			if err != nil {
+				return 0, err
+			}
+			c := proto.Clone(e).(*pb.ContextType)
+			c.Properties = properties
+			st.ContextTypes[i] = c
+			return c.GetId(), nil
+		}
+	}
+	c := proto.Clone(t).(*pb.ContextType)
+	c.Id = proto.Int64(int64(len(st.ContextTypes) + 1))
+	st.ContextTypes = append(st.ContextTypes, c)
+	return c.GetId(), nil
+}
+
+// node is an artifact, execution or context.
+type node interface {
+	proto.Message
+	GetId() int64
+	GetTypeId() int64
+	GetName() string
+	GetProperties() map[string]*pb.Value
+	GetLastUpdateTimeSinceEpoch() int64
+}
+
+func valueHasType(v *pb.Value, t pb.PropertyType) bool {
+	switch v.GetValue().(type) {
+	case *pb.Value_IntValue:
+		return t == pb.PropertyType_INT
+	case *pb.Value_DoubleValue:
+		return t == pb.PropertyType_DOUBLE
+	case *pb.Value_StringValue:
+		return t == pb.PropertyType_STRING
+	case *pb.Value_StructValue:
+		return t == pb.PropertyType_STRUCT
+	}
+	return false
+}
+
+// checkNode checks n, of a type declaring properties, against the n stored
+// nodes of its kind. It returns the index of the node that n updates, or -1
+// if n is new. Names are unique among the nodes of a type.
+func checkNode(kind string, n node, properties map[string]pb.PropertyType, count int, at func(i int) node) (int, error) {
+	for k, v := range n.GetProperties() {
+		t, ok := properties[k]
+		if !ok {
+			return -1, status.Errorf(codes.InvalidArgument, "%s property %q is not declared by its type", kind, k)
+		}
+		if !valueHasType(v, t) {
+			return -1, status.Errorf(codes.InvalidArgument, "%s property %q must be of type %v, got %v", kind, k, t, v)
+		}
+	}
+
+	i := -1
+	if n.GetId() != 0 {
+		if i = index(n.GetId(), count); i < 0 {
+			return -1, status.Errorf(codes.NotFound, "%s %d not found", kind, n.GetId())
+		}
+		if at(i).GetTypeId() != n.GetTypeId() {
+			return -1, status.Errorf(codes.InvalidArgument, "%s %d has type %d, got %d", kind, n.GetId(), at(i).GetTypeId(), n.GetTypeId())
+		}
+	}
+	if len(n.GetName()) > 0 {
+		for j := 0; j < count; j++ {
+			if o := at(j); j != i && o.GetTypeId() == n.GetTypeId() && o.GetName() == n.GetName() {
+				return -1, status.Errorf(codes.AlreadyExists, "%s %q of type %d already exists", kind, n.GetName(), n.GetTypeId())
+			}
+		}
+	}
+	return i, nil
+}
+
+func (st *state) putArtifact(a *pb.Artifact, abortIfUpdated bool) (int64, error) {
+	i := index(a.GetTypeId(), len(st.ArtifactTypes))
+	if i < 0 {
+		return 0, status.Errorf(codes.InvalidArgument, "Unknown artifact type %d", a.GetTypeId())
+	}
+	i, err := checkNode("Artifact", a, st.ArtifactTypes[i].GetProperties(), len(st.Artifacts), func(i int) node { return st.Artifacts[i] })
+	if err != nil {
+		return 0, err
+	}
+
+	c := proto.Clone(a).(*pb.Artifact)
+	c.LastUpdateTimeSinceEpoch = proto.Int64(now())
+	if i >= 0 {
+		old := st.Artifacts[i]
+		if abortIfUpdated && a.GetLastUpdateTimeSinceEpoch() != old.GetLastUpdateTimeSinceEpoch() {
+			return 0, status.Errorf(codes.FailedPrecondition, "Artifact %d was updated since it was read", a.GetId())
+		}
+		c.CreateTimeSinceEpoch = old.CreateTimeSinceEpoch
+		st.Artifacts[i] = c
+		return c.GetId(), nil
+	}
+	c.Id = proto.Int64(int64(len(st.Artifacts) + 1))
+	c.CreateTimeSinceEpoch = c.LastUpdateTimeSinceEpoch
+	st.Artifacts = append(st.Artifacts, c)
+	return c.GetId(), nil
+}
+
+func (st *state) putExecution(e *pb.Execution) (int64, error) {
+	i := index(e.GetTypeId(), len(st.ExecutionTypes))
+	if i < 0 {
+		return 0, status.Errorf(codes.InvalidArgument, "Unknown execution type %d", e.GetTypeId())
+	}
+	i, err := checkNode("Execution", e, st.ExecutionTypes[i].GetProperties(), len(st.Executions), func(i int) node { return st.Executions[i] })
+	if err != nil {
+		return 0, err
+	}
+
+	c := proto.Clone(e).(*pb.Execution)
+	c.LastUpdateTimeSinceEpoch = proto.Int64(now())
+	if i >= 0 {
+		c.CreateTimeSinceEpoch = st.Executions[i].CreateTimeSinceEpoch
+		st.Executions[i] = c
+		return c.GetId(), nil
+	}
+	c.Id = proto.Int64(int64(len(st.Executions) + 1))
+	c.CreateTimeSinceEpoch = c.LastUpdateTimeSinceEpoch
+	st.Executions = append(st.Executions, c)
+	return c.GetId(), nil
+}
+
+// putContext inserts or updates c. If reuse is set, a new context named like
+// an existing one of its type is not inserted, and the existing context's ID
+// is returned instead.
+func (st *state) putContext(c *pb.Context, reuse bool) (int64, error) {
+	if len(c.GetName()) == 0 {
+		return 0, status.Error(codes.InvalidArgument, "Context must have a name")
+	}
+	if reuse && c.GetId() == 0 {
+		for _, o := range st.Contexts {
+			if o.GetTypeId() == c.GetTypeId() && o.GetName() == c.GetName() {
+				return o.GetId(), nil
+			}
+		}
+	}
+	i := index(c.GetTypeId(), len(st.ContextTypes))
+	if i < 0 {
+		return 0, status.Errorf(codes.InvalidArgument, "Unknown context type %d", c.GetTypeId())
+	}
+	i, err := checkNode("Context", c, st.ContextTypes[i].GetProperties(), len(st.Contexts), func(i int) node { return st.Contexts[i] })
+	if err != nil {
+		return 0, err
+	}
+
+	n := proto.Clone(c).(*pb.Context)
+	n.LastUpdateTimeSinceEpoch = proto.Int64(now())
+	if i >= 0 {
+		n.CreateTimeSinceEpoch = st.Contexts[i].CreateTimeSinceEpoch
+		st.Contexts[i] = n
+		return n.GetId(), nil
+	}
+	n.Id = proto.Int64(int64(len(st.Contexts) + 1))
+	n.CreateTimeSinceEpoch = n.LastUpdateTimeSinceEpoch
+	st.Contexts = append(st.Contexts, n)
+	return n.GetId(), nil
+}
+
+func (st *state) putEvent(e *pb.Event) error {
+	if index(e.GetArtifactId(), len(st.Artifacts)) < 0 {
+		return status.Errorf(codes.InvalidArgument, "Event refers to unknown artifact %d", e.GetArtifactId())
+	}
+	if index(e.GetExecutionId(), len(st.Executions)) < 0 {
+		return status.Errorf(codes.InvalidArgument, "Event refers to unknown execution %d", e.GetExecutionId())
+	}
+	if e.GetType() == pb.Event_UNKNOWN {
+		return status.Error(codes.InvalidArgument, "Event must have a type")
+	}
+	c := proto.Clone(e).(*pb.Event)
+	if c.MillisecondsSinceEpoch == nil {
+		c.MillisecondsSinceEpoch = proto.Int64(now())
+	}
+	st.Events = append(st.Events, c)
+	return nil
+}
+
+// attribute links an artifact to a context, unless it is linked already.
+func (st *state) attribute(artifactID, contextID int64) error {
+	if index(artifactID, len(st.Artifacts)) < 0 || index(contextID, len(st.Contexts)) < 0 {
+		return status.Errorf(codes.InvalidArgument, "Attribution of artifact %d to context %d refers to unknown nodes", artifactID, contextID)
+	}
+	for _, a := range st.Attributions {
+		if a.GetArtifactId() == artifactID && a.GetContextId() == contextID {
+			return nil
+		}
+	}
+	st.Attributions = append(st.Attributions, &pb.Attribution{ArtifactId: proto.Int64(artifactID), ContextId: proto.Int64(contextID)})
+	return nil
+}
+
+// associate links an execution to a context, unless it is linked already.
+func (st *state) associate(executionID, contextID int64) error {
+	if index(executionID, len(st.Executions)) < 0 || index(contextID, len(st.Contexts)) < 0 {
+		return status.Errorf(codes.InvalidArgument, "Association of execution %d to context %d refers to unknown nodes", executionID, contextID)
+	}
+	for _, a := range st.Associations {
+		if a.GetExecutionId() == executionID && a.GetContextId() == contextID {
+			return nil
+		}
+	}
+	st.Associations = append(st.Associations, &pb.Association{ExecutionId: proto.Int64(executionID), ContextId: proto.Int64(contextID)})
+	return nil
+}
+
+// maxPageSize is the largest page MLMD returns.
+const maxPageSize = 100
+
+// page returns the page of nodes that options ask for, and the token of the
+// next page, if any. Nodes must be ordered by ID.
+func page(nodes []node, options *pb.ListOperationOptions) ([]node, *string, error) {
+	size := int(options.GetMaxResultSize())
+	if size <= 0 || size > maxPageSize {
+		return nil, nil, status.Errorf(codes.InvalidArgument, "max_result_size must be between 1 and %d, got %d", maxPageSize, size)
+	}
+	start := 0
+	if t := options.GetNextPageToken(); len(t) > 0 {
+		var err error
+		if start, err = strconv.Atoi(t); err != nil || start < 0 {
+			return nil, nil, status.Errorf(codes.InvalidArgument, "Invalid page token %q", t)
+		}
+	}
+
+	sorted := append([]node(nil), nodes...)
+	order := options.GetOrderByField()
+	if order.GetField() == pb.ListOperationOptions_OrderByField_LAST_UPDATE_TIME {
+		sort.SliceStable(sorted, func(i, j int) bool {
+			return sorted[i].GetLastUpdateTimeSinceEpoch() < sorted[j].GetLastUpdateTimeSinceEpoch()
+		})
+	}
+	if !order.GetIsAsc() {
+		for i, j := 0, len(sorted)-1; i < j; i, j = i+1, j-1 {
+			sorted[i], sorted[j] = sorted[j], sorted[i]
+		}
+	}
+
+	if start > len(sorted) {
+		start = len(sorted)
+	}
+	end := start + size
+	if end >= len(sorted) {
+		return sorted[start:], nil, nil
+	}
+	return sorted[start:end], proto.String(strconv.Itoa(end)), nil
+}
+
+func (s *Server) PutArtifactType(ctx context.Context, req *pb.PutArtifactTypeRequest) (*pb.PutArtifactTypeResponse, error) {
+	res := &pb.PutArtifactTypeResponse{}
+	err := s.update(func(st *state) error {
+		id, err := st.putArtifactType(req.GetArtifactType(), req.GetCanAddFields(), req.GetCanOmitFields())
+		res.TypeId = proto.Int64(id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (s *Server) PutExecutionType(ctx context.Context, req *pb.PutExecutionTypeRequest) (*pb.PutExecutionTypeResponse, error) {
+	res := &pb.PutExecutionTypeResponse{}
+	err := s.update(func(st *state) error {
+		id, err := st.putExecutionType(req.GetExecutionType(), req.GetCanAddFields(), req.GetCanOmitFields())
+		res.TypeId = proto.Int64(id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (s *Server) PutContextType(ctx context.Context, req *pb.PutContextTypeRequest) (*pb.PutContextTypeResponse, error) {
+	res := &pb.PutContextTypeResponse{}
+	err := s.update(func(st *state) error {
+		id, err := st.putContextType(req.GetContextType(), req.GetCanAddFields(), req.GetCanOmitFields())
+		res.TypeId = proto.Int64(id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (s *Server) PutTypes(ctx context.Context, req *pb.PutTypesRequest) (*pb.PutTypesResponse, error) {
+	res := &pb.PutTypesResponse{}
+	canAdd, canOmit := req.GetCanAddFields(), req.GetCanOmitFields()
+	err := s.update(func(st *state) error {
+		for _, t := range req.GetArtifactTypes() {
+			id, err := st.putArtifactType(t, canAdd, canOmit)
+			if err != nil {
+				return err
+			}
+			res.ArtifactTypeIds = append(res.ArtifactTypeIds, id)
+		}
+		for _, t := range req.GetExecutionTypes() {
+			id, err := st.putExecutionType(t, canAdd, canOmit)
+			if err != nil {
+				return err
+			}
+			res.ExecutionTypeIds = append(res.ExecutionTypeIds, id)
+		}
+		for _, t := range req.GetContextTypes() {
+			id, err := st.putContextType(t, canAdd, canOmit)
+			if err != nil {
+				return err
+			}
+			res.ContextTypeIds = append(res.ContextTypeIds, id)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (s *Server) PutArtifacts(ctx context.Context, req *pb.PutArtifactsRequest) (*pb.PutArtifactsResponse, error) {
+	res := &pb.PutArtifactsResponse{}
+	err := s.update(func(st *state) error {
+		for _, a := range req.GetArtifacts() {
+			id, err := st.putArtifact(a, req.GetOptions().GetAbortIfLatestUpdatedTimeChanged())
+			if err != nil {
+				return err
+			}
+			res.ArtifactIds = append(res.ArtifactIds, id)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (s *Server) PutExecutions(ctx context.Context, req *pb.PutExecutionsRequest) (*pb.PutExecutionsResponse, error) {
+	res := &pb.PutExecutionsResponse{}
+	err := s.update(func(st *state) error {
+		for _, e := range req.GetExecutions() {
+			id, err := st.putExecution(e)
+			if err != nil {
+				return err
+			}
+			res.ExecutionIds = append(res.ExecutionIds, id)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (s *Server) PutContexts(ctx context.Context, req *pb.PutContextsRequest) (*pb.PutContextsResponse, error) {
+	res := &pb.PutContextsResponse{}
+	err := s.update(func(st *state) error {
+		for _, c := range req.GetContexts() {
+			id, err := st.putContext(c, false)
+			if err != nil {
+				return err
+			}
+			res.ContextIds = append(res.ContextIds, id)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (s *Server) PutEvents(ctx context.Context, req *pb.PutEventsRequest) (*pb.PutEventsResponse, error) {
+	err := s.update(func(st *state) error {
+		for _, e := range req.GetEvents() {
+			if err := st.putEvent(e); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &pb.PutEventsResponse{}, nil
+}
+
+// PutExecution inserts or updates an execution together with its artifacts,
+// events and contexts. The artifacts and the execution are linked to every
+// context.
+func (s *Server) PutExecution(ctx context.Context, req *pb.PutExecutionRequest) (*pb.PutExecutionResponse, error) {
+	res := &pb.PutExecutionResponse{}
+	err := s.update(func(st *state) error {
+		executionID, err := st.putExecution(req.GetExecution())
+		if err != nil {
+			return err
+		}
+		res.ExecutionId = proto.Int64(executionID)
+
+		for _, p := range req.GetArtifactEventPairs() {
+			artifactID := p.GetEvent().GetArtifactId()
+			if p.GetArtifact() != nil {
+				if artifactID, err = st.putArtifact(p.GetArtifact(), false); err != nil {
+					return err
+				}
+			}
+			if p.GetEvent() != nil {
+				e := proto.Clone(p.GetEvent()).(*pb.Event)
+				e.ArtifactId = proto.Int64(artifactID)
+				e.ExecutionId = proto.Int64(executionID)
+				if err := st.putEvent(e); err != nil {
+					return err
+				}
+			}
+			res.ArtifactIds = append(res.ArtifactIds, artifactID)
+		}
+
+		for _, c := range req.GetContexts() {
+			contextID, err := st.putContext(c, req.GetOptions().GetReuseContextIfAlreadyExist())
+			if err != nil {
+				return err
+			}
+			res.ContextIds = append(res.ContextIds, contextID)
+			if err := st.associate(executionID, contextID); err != nil {
+				return err
+			}
+			for _, artifactID := range res.ArtifactIds {
+				if err := st.attribute(artifactID, contextID); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (s *Server) PutAttributionsAndAssociations(ctx context.Context, req *pb.PutAttributionsAndAssociationsRequest) (*pb.PutAttributionsAndAssociationsResponse, error) {
+	err := s.update(func(st *state) error {
+		for _, a := range req.GetAttributions() {
+			if err := st.attribute(a.GetArtifactId(), a.GetContextId()); err != nil {
+				return err
+			}
+		}
+		for _, a := range req.GetAssociations() {
+			if err := st.associate(a.GetExecutionId(), a.GetContextId()); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &pb.PutAttributionsAndAssociationsResponse{}, nil
+}
+
+func (s *Server) PutParentContexts(ctx context.Context, req *pb.PutParentContextsRequest) (*pb.PutParentContextsResponse, error) {
+	err := s.update(func(st *state) error {
+		for _, p := range req.GetParentContexts() {
+			if index(p.GetChildId(), len(st.Contexts)) < 0 || index(p.GetParentId(), len(st.Contexts)) < 0 {
+				return status.Errorf(codes.InvalidArgument, "Parent context %d of context %d refers to unknown contexts", p.GetParentId(), p.GetChildId())
+			}
+			if p.GetChildId() == p.GetParentId() {
+				return status.Errorf(codes.InvalidArgument, "Context %d cannot be its own parent", p.GetChildId())
+			}
+			for _, o := range st.ParentContexts {
+				if o.GetChildId() == p.GetChildId() && o.GetParentId() == p.GetParentId() {
+					return status.Errorf(codes.AlreadyExists, "Context %d is a parent of context %d already", p.GetParentId(), p.GetChildId())
+				}
+			}
+			st.ParentContexts = append(st.ParentContexts, proto.Clone(p).(*pb.ParentContext))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &pb.PutParentContextsResponse{}, nil
+}
+
+func (s *Server) GetArtifactType(ctx context.Context, req *pb.GetArtifactTypeRequest) (*pb.GetArtifactTypeResponse, error) {
+	res := &pb.GetArtifactTypeResponse{}
+	s.view(func(st *state) {
+		for _, t := range st.ArtifactTypes {
+			if t.GetName() == req.GetTypeName() {
+				res.ArtifactType = proto.Clone(t).(*pb.ArtifactType)
+			}
+		}
+	})
+	if res.ArtifactType == nil {
+		return nil, status.Errorf(codes.NotFound, "Artifact type %q not found", req.GetTypeName())
+	}
+	return res, nil
+}
+
+func (s *Server) GetExecutionType(ctx context.Context, req *pb.GetExecutionTypeRequest) (*pb.GetExecutionTypeResponse, error) {
+	res := &pb.GetExecutionTypeResponse{}
+	s.view(func(st *state) {
+		for _, t := range st.ExecutionTypes {
+			if t.GetName() == req.GetTypeName() {
+				res.ExecutionType = proto.Clone(t).(*pb.ExecutionType)
+			}
+		}
+	})
+	if res.ExecutionType == nil {
+		return nil, status.Errorf(codes.NotFound, "Execution type %q not found", req.GetTypeName())
+	}
+	return res, nil
+}
+
+func (s *Server) GetContextType(ctx context.Context, req *pb.GetContextTypeRequest) (*pb.GetContextTypeResponse, error) {
+	res := &pb.GetContextTypeResponse{}
+	s.view(func(st *state) {
+		for _, t := range st.ContextTypes {
+			if t.GetName() == req.GetTypeName() {
+				res.ContextType = proto.Clone(t).(*pb.ContextType)
+			}
+		}
+	})
+	if res.ContextType == nil {
+		return nil, status.Errorf(codes.NotFound, "Context type %q not found", req.GetTypeName())
+	}
+	return res, nil
+}
+
+func (s *Server) GetArtifactTypesByID(ctx context.Context, req *pb.GetArtifactTypesByIDRequest) (*pb.GetArtifactTypesByIDResponse, error) {
+	res := &pb.GetArtifactTypesByIDResponse{}
+	s.view(func(st *state) {
+		for _, id := range req.GetTypeIds() {
+			if i := index(id, len(st.ArtifactTypes)); i >= 0 {
+				res.ArtifactTypes = append(res.ArtifactTypes, proto.Clone(st.ArtifactTypes[i]).(*pb.ArtifactType))
+			}
+		}
+	})
+	return res, nil
+}
+
+func (s *Server) GetExecutionTypesByID(ctx context.Context, req *pb.GetExecutionTypesByIDRequest) (*pb.GetExecutionTypesByIDResponse, error) {
+	res := &pb.GetExecutionTypesByIDResponse{}
+	s.view(func(st *state) {
+		for _, id := range req.GetTypeIds() {
+			if i := index(id, len(st.ExecutionTypes)); i >= 0 {
+				res.ExecutionTypes = append(res.ExecutionTypes, proto.Clone(st.ExecutionTypes[i]).(*pb.ExecutionType))
+			}
+		}
+	})
+	return res, nil
+}
+
+func (s *Server) GetContextTypesByID(ctx context.Context, req *pb.GetContextTypesByIDRequest) (*pb.GetContextTypesByIDResponse, error) {
+	res := &pb.GetContextTypesByIDResponse{}
+	s.view(func(st *state) {
+		for _, id := range req.GetTypeIds() {
+			if i := index(id, len(st.ContextTypes)); i >= 0 {
+				res.ContextTypes = append(res.ContextTypes, proto.Clone(st.ContextTypes[i]).(*pb.ContextType))
+			}
+		}
+	})
+	return res, nil
+}
+
+func (s *Server) GetArtifactTypes(ctx context.Context, req *pb.GetArtifactTypesRequest) (*pb.GetArtifactTypesResponse, error) {
+	res := &pb.GetArtifactTypesResponse{}
+	s.view(func(st *state) {
+		for _, t := range st.ArtifactTypes {
+			res.ArtifactTypes = append(res.ArtifactTypes, proto.Clone(t).(*pb.ArtifactType))
+		}
+	})
+	return res, nil
+}
+
+func (s *Server) GetExecutionTypes(ctx context.Context, req *pb.GetExecutionTypesRequest) (*pb.GetExecutionTypesResponse, error) {
+	res := &pb.GetExecutionTypesResponse{}
+	s.view(func(st *state) {
+		for _, t := range st.ExecutionTypes {
+			res.ExecutionTypes = append(res.ExecutionTypes, proto.Clone(t).(*pb.ExecutionType))
+		}
+	})
+	return res, nil
+}
+
+func (s *Server) GetContextTypes(ctx context.Context, req *pb.GetContextTypesRequest) (*pb.GetContextTypesResponse, error) {
+	res := &pb.GetContextTypesResponse{}
+	s.view(func(st *state) {
+		for _, t := range st.ContextTypes {
+			res.ContextTypes = append(res.ContextTypes, proto.Clone(t).(*pb.ContextType))
+		}
+	})
+	return res, nil
+}
+
+// The selections below return nodes ordered by ID.
+
+func (st *state) artifactTypeID(name string) int64 {
+	for _, t := range st.ArtifactTypes {
+		if t.GetName() == name {
+			return t.GetId()
+		}
+	}
+	return 0
+}
+
+func (st *state) executionTypeID(name string) int64 {
+	for _, t := range st.ExecutionTypes {
+		if t.GetName() == name {
+			return t.GetId()
+		}
+	}
+	return 0
+}
+
+func (st *state) contextTypeID(name string) int64 {
+	for _, t := range st.ContextTypes {
+		if t.GetName() == name {
+			return t.GetId()
+		}
+	}
+	return 0
+}
+
+func (st *state) selectArtifacts(keep func(a *pb.Artifact) bool) []node {
+	var nodes []node
+	for _, a := range st.Artifacts {
+		if keep(a) {
+			nodes = append(nodes, a)
+		}
+	}
+	return nodes
+}
+
+func (st *state) selectExecutions(keep func(e *pb.Execution) bool) []node {
+	var nodes []node
+	for _, e := range st.Executions {
+		if keep(e) {
+			nodes = append(nodes, e)
+		}
+	}
+	return nodes
+}
+
+func (st *state) selectContexts(keep func(c *pb.Context) bool) []node {
+	var nodes []node
+	for _, c := range st.Contexts {
+		if keep(c) {
+			nodes = append(nodes, c)
+		}
+	}
+	return nodes
+}
+
+func artifacts(nodes []node) []*pb.Artifact {
+	var as []*pb.Artifact
+	for _, n := range nodes {
+		as = append(as, proto.Clone(n).(*pb.Artifact))
+	}
+	return as
+}
+
+func executions(nodes []node) []*pb.Execution {
+	var es []*pb.Execution
+	for _, n := range nodes {
+		es = append(es, proto.Clone(n).(*pb.Execution))
+	}
+	return es
+}
+
+func contexts(nodes []node) []*pb.Context {
+	var cs []*pb.Context
+	for _, n := range nodes {
+		cs = append(cs, proto.Clone(n).(*pb.Context))
+	}
+	return cs
+}
+
+func inIDs(id int64, ids []int64) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) GetArtifacts(ctx context.Context, req *pb.GetArtifactsRequest) (*pb.GetArtifactsResponse, error) {
+	res := &pb.GetArtifactsResponse{}
+	var err error
+	s.view(func(st *state) {
+		var nodes []node
+		nodes, res.NextPageToken, err = page(st.selectArtifacts(func(*pb.Artifact) bool { return true }), req.GetOptions())
+		res.Artifacts = artifacts(nodes)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (s *Server) GetExecutions(ctx context.Context, req *pb.GetExecutionsRequest) (*pb.GetExecutionsResponse, error) {
+	res := &pb.GetExecutionsResponse{}
+	var err error
+	s.view(func(st *state) {
+		var nodes []node
+		nodes, res.NextPageToken, err = page(st.selectExecutions(func(*pb.Execution) bool { return true }), req.GetOptions())
+		res.Executions = executions(nodes)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (s *Server) GetContexts(ctx context.Context, req *pb.GetContextsRequest) (*pb.GetContextsResponse, error) {
+	res := &pb.GetContextsResponse{}
+	var err error
+	s.view(func(st *state) {
+		var nodes []node
+		nodes, res.NextPageToken, err = page(st.selectContexts(func(*pb.Context) bool { return true }), req.GetOptions())
+		res.Contexts = contexts(nodes)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (s *Server) GetArtifactsByID(ctx context.Context, req *pb.GetArtifactsByIDRequest) (*pb.GetArtifactsByIDResponse, error) {
+	res := &pb.GetArtifactsByIDResponse{}
+	s.view(func(st *state) {
+		res.Artifacts = artifacts(st.selectArtifacts(func(a *pb.Artifact) bool { return inIDs(a.GetId(), req.GetArtifactIds()) }))
+	})
+	return res, nil
+}
+
+func (s *Server) GetExecutionsByID(ctx context.Context, req *pb.GetExecutionsByIDRequest) (*pb.GetExecutionsByIDResponse, error) {
+	res := &pb.GetExecutionsByIDResponse{}
+	s.view(func(st *state) {
+		res.Executions = executions(st.selectExecutions(func(e *pb.Execution) bool { return inIDs(e.GetId(), req.GetExecutionIds()) }))
+	})
+	return res, nil
+}
+
+func (s *Server) GetContextsByID(ctx context.Context, req *pb.GetContextsByIDRequest) (*pb.GetContextsByIDResponse, error) {
+	res := &pb.GetContextsByIDResponse{}
+	s.view(func(st *state) {
+		res.Contexts = contexts(st.selectContexts(func(c *pb.Context) bool { return inIDs(c.GetId(), req.GetContextIds()) }))
+	})
+	return res, nil
+}
+
+// GetArtifactsByType returns no artifacts for unknown types, like MLMD.
+func (s *Server) GetArtifactsByType(ctx context.Context, req *pb.GetArtifactsByTypeRequest) (*pb.GetArtifactsByTypeResponse, error) {
+	res := &pb.GetArtifactsByTypeResponse{}
+	s.view(func(st *state) {
+		id := st.artifactTypeID(req.GetTypeName())
+		res.Artifacts = artifacts(st.selectArtifacts(func(a *pb.Artifact) bool { return a.GetTypeId() == id }))
+	})
+	return res, nil
+}
+
+// GetExecutionsByType returns no executions for unknown types, like MLMD.
+func (s *Server) GetExecutionsByType(ctx context.Context, req *pb.GetExecutionsByTypeRequest) (*pb.GetExecutionsByTypeResponse, error) {
+	res := &pb.GetExecutionsByTypeResponse{}
+	s.view(func(st *state) {
+		id := st.executionTypeID(req.GetTypeName())
+		res.Executions = executions(st.selectExecutions(func(e *pb.Execution) bool { return e.GetTypeId() == id }))
+	})
+	return res, nil
+}
+
+// GetContextsByType returns no contexts for unknown types, like MLMD.
+func (s *Server) GetContextsByType(ctx context.Context, req *pb.GetContextsByTypeRequest) (*pb.GetContextsByTypeResponse, error) {
+	res := &pb.GetContextsByTypeResponse{}
+	var err error
+	s.view(func(st *state) {
+		id := st.contextTypeID(req.GetTypeName())
+		nodes := st.selectContexts(func(c *pb.Context) bool { return c.GetTypeId() == id })
+		if req.GetOptions() != nil {
+			nodes, res.NextPageToken, err = page(nodes, req.GetOptions())
+		}
+		res.Contexts = contexts(nodes)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// GetArtifactByTypeAndName returns OK without an artifact if there is none,
+// like MLMD.
+func (s *Server) GetArtifactByTypeAndName(ctx context.Context, req *pb.GetArtifactByTypeAndNameRequest) (*pb.GetArtifactByTypeAndNameResponse, error) {
+	res := &pb.GetArtifactByTypeAndNameResponse{}
+	s.view(func(st *state) {
+		id := st.artifactTypeID(req.GetTypeName())
+		for _, a := range artifacts(st.selectArtifacts(func(a *pb.Artifact) bool {
+			return a.GetTypeId() == id && a.GetName() == req.GetArtifactName()
+		})) {
+			res.Artifact = a
+		}
+	})
+	return res, nil
+}
+
+// GetExecutionByTypeAndName returns OK without an execution if there is
+// none, like MLMD.
+func (s *Server) GetExecutionByTypeAndName(ctx context.Context, req *pb.GetExecutionByTypeAndNameRequest) (*pb.GetExecutionByTypeAndNameResponse, error) {
+	res := &pb.GetExecutionByTypeAndNameResponse{}
+	s.view(func(st *state) {
+		id := st.executionTypeID(req.GetTypeName())
+		for _, e := range executions(st.selectExecutions(func(e *pb.Execution) bool {
+			return e.GetTypeId() == id && e.GetName() == req.GetExecutionName()
+		})) {
+			res.Execution = e
+		}
+	})
+	return res, nil
+}
+
+// GetContextByTypeAndName returns OK without a context if there is none, like
+// MLMD.
+func (s *Server) GetContextByTypeAndName(ctx context.Context, req *pb.GetContextByTypeAndNameRequest) (*pb.GetContextByTypeAndNameResponse, error) {
+	res := &pb.GetContextByTypeAndNameResponse{}
+	s.view(func(st *state) {
+		id := st.contextTypeID(req.GetTypeName())
+		for _, c := range contexts(st.selectContexts(func(c *pb.Context) bool {
+			return c.GetTypeId() == id && c.GetName() == req.GetContextName()
+		})) {
+			res.Context = c
+		}
+	})
+	return res, nil
+}
+
+func (s *Server) GetArtifactsByURI(ctx context.Context, req *pb.GetArtifactsByURIRequest) (*pb.GetArtifactsByURIResponse, error) {
+	res := &pb.GetArtifactsByURIResponse{}
+	s.view(func(st *state) {
+		res.Artifacts = artifacts(st.selectArtifacts(func(a *pb.Artifact) bool {
+			for _, uri := range req.GetUris() {
+				if a.GetUri() == uri {
+					return true
+				}
+			}
+			return false
+		}))
+	})
+	return res, nil
+}
+
+func (s *Server) GetEventsByExecutionIDs(ctx context.Context, req *pb.GetEventsByExecutionIDsRequest) (*pb.GetEventsByExecutionIDsResponse, error) {
+	res := &pb.GetEventsByExecutionIDsResponse{}
+	s.view(func(st *state) {
+		for _, e := range st.Events {
+			if inIDs(e.GetExecutionId(), req.GetExecutionIds()) {
+				res.Events = append(res.Events, proto.Clone(e).(*pb.Event))
+			}
+		}
+	})
+	return res, nil
+}
+
+func (s *Server) GetEventsByArtifactIDs(ctx context.Context, req *pb.GetEventsByArtifactIDsRequest) (*pb.GetEventsByArtifactIDsResponse, error) {
+	res := &pb.GetEventsByArtifactIDsResponse{}
+	s.view(func(st *state) {
+		for _, e := range st.Events {
+			if inIDs(e.GetArtifactId(), req.GetArtifactIds()) {
+				res.Events = append(res.Events, proto.Clone(e).(*pb.Event))
+			}
+		}
+	})
+	return res, nil
+}
+
+func (s *Server) GetContextsByArtifact(ctx context.Context, req *pb.GetContextsByArtifactRequest) (*pb.GetContextsByArtifactResponse, error) {
+	res := &pb.GetContextsByArtifactResponse{}
+	s.view(func(st *state) {
+		var ids []int64
+		for _, a := range st.Attributions {
+			if a.GetArtifactId() == req.GetArtifactId() {
+				ids = append(ids, a.GetContextId())
+			}
+		}
+		res.Contexts = contexts(st.selectContexts(func(c *pb.Context) bool { return inIDs(c.GetId(), ids) }))
+	})
+	return res, nil
+}
+
+func (s *Server) GetContextsByExecution(ctx context.Context, req *pb.GetContextsByExecutionRequest) (*pb.GetContextsByExecutionResponse, error) {
+	res := &pb.GetContextsByExecutionResponse{}
+	s.view(func(st *state) {
+		var ids []int64
+		for _, a := range st.Associations {
+			if a.GetExecutionId() == req.GetExecutionId() {
+				ids = append(ids, a.GetContextId())
+			}
+		}
+		res.Contexts = contexts(st.selectContexts(func(c *pb.Context) bool { return inIDs(c.GetId(), ids) }))
+	})
+	return res, nil
+}
+
+func (s *Server) GetParentContextsByContext(ctx context.Context, req *pb.GetParentContextsByContextRequest) (*pb.GetParentContextsByContextResponse, error) {
+	res := &pb.GetParentContextsByContextResponse{}
+	s.view(func(st *state) {
+		var ids []int64
+		for _, p := range st.ParentContexts {
+			if p.GetChildId() == req.GetContextId() {
+				ids = append(ids, p.GetParentId())
+			}
+		}
+		res.Contexts = contexts(st.selectContexts(func(c *pb.Context) bool { return inIDs(c.GetId(), ids) }))
+	})
+	return res, nil
+}
+
+func (s *Server) GetChildrenContextsByContext(ctx context.Context, req *pb.GetChildrenContextsByContextRequest) (*pb.GetChildrenContextsByContextResponse, error) {
+	res := &pb.GetChildrenContextsByContextResponse{}
+	s.view(func(st *state) {
+		var ids []int64
+		for _, p := range st.ParentContexts {
+			if p.GetParentId() == req.GetContextId() {
+				ids = append(ids, p.GetChildId())
+			}
+		}
+		res.Contexts = contexts(st.selectContexts(func(c *pb.Context) bool { return inIDs(c.GetId(), ids) }))
+	})
+	return res, nil
+}
+
+func (s *Server) GetArtifactsByContext(ctx context.Context, req *pb.GetArtifactsByContextRequest) (*pb.GetArtifactsByContextResponse, error) {
+	res := &pb.GetArtifactsByContextResponse{}
+	var err error
+	s.view(func(st *state) {
+		var ids []int64
+		for _, a := range st.Attributions {
+			if a.GetContextId() == req.GetContextId() {
+				ids = append(ids, a.GetArtifactId())
+			}
+		}
+		var nodes []node
+		nodes, res.NextPageToken, err = page(st.selectArtifacts(func(a *pb.Artifact) bool { return inIDs(a.GetId(), ids) }), req.GetOptions())
+		res.Artifacts = artifacts(nodes)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (s *Server) GetExecutionsByContext(ctx context.Context, req *pb.GetExecutionsByContextRequest) (*pb.GetExecutionsByContextResponse, error) {
+	res := &pb.GetExecutionsByContextResponse{}
+	var err error
+	s.view(func(st *state) {
+		var ids []int64
+		for _, a := range st.Associations {
+			if a.GetContextId() == req.GetContextId() {
+				ids = append(ids, a.GetExecutionId())
+			}
+		}
+		var nodes []node
+		nodes, res.NextPageToken, err = page(st.selectExecutions(func(e *pb.Execution) bool { return inIDs(e.GetId(), ids) }), req.GetOptions())
+		res.Executions = executions(nodes)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
@@ -0,0 +1,289 @@
+package embedded
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/neuromage/kfp-launcher/metadata"
+	pb "github.com/neuromage/kfp-launcher/third_party/ml_metadata"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// TestServer_Client records an execution through the metadata client, and
+// checks that the recorded metadata survives a restart.
+func TestServer_Client(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "mlmd", "metadata.json")
+	endpoint, err := Start(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer endpoint.Stop()
+	client, err := metadata.NewClient(endpoint.Address, endpoint.Port, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pipeline, err := client.GetPipeline(ctx, "my-pipeline", "my-run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &metadata.ExecutionConfig{InputParameters: &metadata.Parameters{IntParameters: map[string]int64{"rows": 7}}}
+	execution, err := client.CreateExecution(ctx, pipeline, "train", "train-1", "python:3.7", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const schema = "title: kfp.Model\n"
+	artifact, err := client.RecordArtifact(ctx, schema, &pb.Artifact{Uri: proto.String("gs://my-bucket/model")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	outputs := []*metadata.OutputArtifact{{Name: "model", Artifact: artifact, Schema: schema}}
+	if err := client.PublishExecution(ctx, execution, &metadata.Parameters{}, outputs); err != nil {
+		t.Fatal(err)
+	}
+	// Registering the finished attempt again leaves it unchanged.
+	if _, err := client.CreateExecution(ctx, pipeline, "train", "train-1", "python:3.7", config); err == nil {
+		t.Error("CreateExecution() of a completed attempt succeeded")
+	}
+	if _, err := client.GetPipeline(ctx, "my-pipeline", "my-run"); err != nil {
+		t.Fatal(err)
+	}
+	endpoint.Stop()
+
+	s, err := NewServer(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	executions, err := s.GetExecutions(ctx, &pb.GetExecutionsRequest{Options: &pb.ListOperationOptions{MaxResultSize: proto.Int32(10)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(executions.GetExecutions()) != 1 {
+		t.Fatalf("Got executions %v, want one", executions.GetExecutions())
+	}
+	e := executions.GetExecutions()[0]
+	if e.GetName() != "my-run/train-1/attempt-0" || e.GetCustomProperties()["input:rows"].GetIntValue() != 7 || e.GetLastKnownState() != pb.Execution_COMPLETE {
+		t.Errorf("Got execution %v, want COMPLETE attempt 0 of train-1 with input rows 7", e)
+	}
+
+	run, err := s.GetContextByTypeAndName(ctx, &pb.GetContextByTypeAndNameRequest{TypeName: proto.String("kfp.PipelineRun"), ContextName: proto.String("my-run")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if run.GetContext() == nil {
+		t.Fatal("Pipeline run context not found")
+	}
+	artifacts, err := s.GetArtifactsByContext(ctx, &pb.GetArtifactsByContextRequest{ContextId: run.GetContext().Id, Options: &pb.ListOperationOptions{MaxResultSize: proto.Int32(10)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var uris []string
+	for _, a := range artifacts.GetArtifacts() {
+		uris = append(uris, a.GetUri())
+	}
+	if diff := cmp.Diff([]string{"gs://my-bucket/model"}, uris); diff != "" {
+		t.Errorf("Artifacts of the run mismatch (-want +got):\n%s", diff)
+	}
+	events, err := s.GetEventsByExecutionIDs(ctx, &pb.GetEventsByExecutionIDsRequest{ExecutionIds: []int64{e.GetId()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events.GetEvents()) != 1 || events.GetEvents()[0].GetType() != pb.Event_OUTPUT {
+		t.Errorf("Got events %v, want one OUTPUT event", events.GetEvents())
+	}
+}
+
+func TestServer_PutArtifactType(t *testing.T) {
+	ctx := context.Background()
+	s, err := NewServer("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	put := func(properties map[string]pb.PropertyType, canAdd, canOmit bool) (int64, error) {
+		res, err := s.PutArtifactType(ctx, &pb.PutArtifactTypeRequest{
+			ArtifactType:  &pb.ArtifactType{Name: proto.String("kfp.Model"), Properties: properties},
+			CanAddFields:  proto.Bool(canAdd),
+			CanOmitFields: proto.Bool(canOmit),
+		})
+		return res.GetTypeId(), err
+	}
+
+	id, err := put(map[string]pb.PropertyType{"a": pb.PropertyType_INT}, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := put(map[string]pb.PropertyType{"a": pb.PropertyType_INT}, false, false); err != nil || got != id {
+		t.Errorf("Putting the same type again = %d, %v, want %d", got, err, id)
+	}
+	for _, tc := range []struct {
+		name            string
+		properties      map[string]pb.PropertyType
+		canAdd, canOmit bool
+		want            codes.Code
+	}{
+		{"ChangedProperty", map[string]pb.PropertyType{"a": pb.PropertyType_STRING}, true, true, codes.AlreadyExists},
+		{"AddedProperty", map[string]pb.PropertyType{"a": pb.PropertyType_INT, "b": pb.PropertyType_DOUBLE}, false, false, codes.AlreadyExists},
+		{"OmittedProperty", nil, false, false, codes.AlreadyExists},
+		{"CanAdd", map[string]pb.PropertyType{"a": pb.PropertyType_INT, "b": pb.PropertyType_DOUBLE}, true, false, codes.OK},
+		{"CanOmit", nil, false, true, codes.OK},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := put(tc.properties, tc.canAdd, tc.canOmit)
+			if status.Code(err) != tc.want {
+				t.Fatalf("PutArtifactType() error = %v, want code %v", err, tc.want)
+			}
+			if err == nil && got != id {
+				t.Errorf("PutArtifactType() = %d, want %d", got, id)
+			}
+		})
+	}
+
+	res, err := s.GetArtifactType(ctx, &pb.GetArtifactTypeRequest{TypeName: proto.String("kfp.Model")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]pb.PropertyType{"a": pb.PropertyType_INT, "b": pb.PropertyType_DOUBLE}
+	if diff := cmp.Diff(want, res.GetArtifactType().GetProperties()); diff != "" {
+		t.Errorf("Artifact type properties mismatch (-want +got):\n%s", diff)
+	}
+	if _, err := s.GetArtifactType(ctx, &pb.GetArtifactTypeRequest{TypeName: proto.String("missing")}); status.Code(err) != codes.NotFound {
+		t.Errorf("GetArtifactType() of a missing type error = %v, want NotFound", err)
+	}
+}
+
+func TestServer_PutExecution(t *testing.T) {
+	ctx := context.Background()
+	s, err := NewServer("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.PutTypes(ctx, &pb.PutTypesRequest{
+		ArtifactTypes:  []*pb.ArtifactType{{Name: proto.String("kfp.Dataset")}},
+		ExecutionTypes: []*pb.ExecutionType{{Name: proto.String("kfp.ContainerExecution"), Properties: map[string]pb.PropertyType{"rows": pb.PropertyType_INT}}},
+		ContextTypes:   []*pb.ContextType{{Name: proto.String("kfp.PipelineRun")}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	req := &pb.PutExecutionRequest{
+		Execution: &pb.Execution{TypeId: proto.Int64(1), Name: proto.String("e")},
+		ArtifactEventPairs: []*pb.PutExecutionRequest_ArtifactAndEvent{{
+			Artifact: &pb.Artifact{TypeId: proto.Int64(1), Uri: proto.String("gs://my-bucket/dataset")},
+			Event:    &pb.Event{Type: pb.Event_OUTPUT.Enum()},
+		}},
+		Contexts: []*pb.Context{{TypeId: proto.Int64(1), Name: proto.String("my-run")}},
+	}
+
+	// A failed request changes nothing.
+	bad := proto.Clone(req).(*pb.PutExecutionRequest)
+	bad.Execution.Properties = map[string]*pb.Value{"rows": {Value: &pb.Value_StringValue{StringValue: "7"}}}
+	if _, err := s.PutExecution(ctx, bad); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("PutExecution() with a mistyped property error = %v, want InvalidArgument", err)
+	}
+	res, err := s.PutExecution(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &pb.PutExecutionResponse{ExecutionId: proto.Int64(1), ArtifactIds: []int64{1}, ContextIds: []int64{1}}
+	if !proto.Equal(want, res) {
+		t.Errorf("PutExecution() = %v, want %v", res, want)
+	}
+
+	// Contexts are only reused when asked to.
+	req.Execution.Name = proto.String("f")
+	req.ArtifactEventPairs = nil
+	if _, err := s.PutExecution(ctx, req); status.Code(err) != codes.AlreadyExists {
+		t.Errorf("PutExecution() with an existing context error = %v, want AlreadyExists", err)
+	}
+	req.Options = &pb.PutExecutionRequest_Options{ReuseContextIfAlreadyExist: proto.Bool(true)}
+	if res, err = s.PutExecution(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	if got := res.GetContextIds(); !cmp.Equal(got, []int64{1}) {
+		t.Errorf("PutExecution() reusing the context = %v, want context 1", got)
+	}
+
+	executions, err := s.GetExecutionsByContext(ctx, &pb.GetExecutionsByContextRequest{ContextId: proto.Int64(1), Options: &pb.ListOperationOptions{MaxResultSize: proto.Int32(10)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range executions.GetExecutions() {
+		names = append(names, e.GetName())
+	}
+	if diff := cmp.Diff([]string{"e", "f"}, names); diff != "" {
+		t.Errorf("Executions of the context mismatch (-want +got):\n%s", diff)
+	}
+	contexts, err := s.GetContextsByArtifact(ctx, &pb.GetContextsByArtifactRequest{ArtifactId: proto.Int64(1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(contexts.GetContexts()) != 1 {
+		t.Errorf("Got contexts %v of the artifact, want context 1", contexts.GetContexts())
+	}
+}
+
+func TestServer_GetContexts_Pages(t *testing.T) {
+	ctx := context.Background()
+	s, err := NewServer("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.PutContextType(ctx, &pb.PutContextTypeRequest{ContextType: &pb.ContextType{Name: proto.String("kfp.Pipeline")}}); err != nil {
+		t.Fatal(err)
+	}
+	var put []*pb.Context
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		put = append(put, &pb.Context{TypeId: proto.Int64(1), Name: proto.String(name)})
+	}
+	if _, err := s.PutContexts(ctx, &pb.PutContextsRequest{Contexts: put}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name  string
+		isAsc bool
+		want  []string
+	}{
+		{"Ascending", true, []string{"a", "b", "c", "d", "e"}},
+		{"Descending", false, []string{"e", "d", "c", "b", "a"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			options := &pb.ListOperationOptions{
+				MaxResultSize: proto.Int32(2),
+				OrderByField:  &pb.ListOperationOptions_OrderByField{IsAsc: proto.Bool(tc.isAsc)},
+			}
+			var got []string
+			pages := 0
+			for {
+				res, err := s.GetContexts(ctx, &pb.GetContextsRequest{Options: options})
+				if err != nil {
+					t.Fatal(err)
+				}
+				pages++
+				for _, c := range res.GetContexts() {
+					got = append(got, c.GetName())
+				}
+				if res.NextPageToken == nil {
+					break
+				}
+				options.NextPageToken = res.NextPageToken
+			}
+			if pages != 3 {
+				t.Errorf("Got %d pages, want 3", pages)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("GetContexts() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	options := &pb.ListOperationOptions{MaxResultSize: proto.Int32(maxPageSize + 1)}
+	if _, err := s.GetContexts(ctx, &pb.GetContextsRequest{Options: options}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("GetContexts() with too large a page error = %v, want InvalidArgument", err)
+	}
+}
@@ -0,0 +1,166 @@
+// Package embedded provides an in-process MLMD server, so that the launcher
+// can run, and be tested, without a metadata store deployment.
+package embedded
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+
+	pb "github.com/neuromage/kfp-launcher/third_party/ml_metadata"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// state holds the metadata. The ID of an element is its index plus one, as
+// nothing is ever deleted. Elements are replaced rather than modified, so that
+// a shallow copy of state is a snapshot.
+type state struct {
+	ArtifactTypes  []*pb.ArtifactType
+	ExecutionTypes []*pb.ExecutionType
+	ContextTypes   []*pb.ContextType
+	Artifacts      []*pb.Artifact
+	Executions     []*pb.Execution
+	Contexts       []*pb.Context
+	Events         []*pb.Event
+	Attributions   []*pb.Attribution
+	Associations   []*pb.Association
+	ParentContexts []*pb.ParentContext
+}
+
+// copy returns a shallow copy of st.
+func (st *state) copy() *state {
+	c := &state{}
+	src, dst := reflect.ValueOf(st).Elem(), reflect.ValueOf(c).Elem()
+	for i := 0; i < src.NumField(); i++ {
+		f := src.Field(i)
+		dst.Field(i).Set(reflect.AppendSlice(reflect.MakeSlice(f.Type(), 0, f.Len()), f))
+	}
+	return c
+}
+
+// marshal encodes st as a JSON object mapping each field to a list of
+// messages in their JSON encoding.
+func (st *state) marshal() ([]byte, error) {
+	snapshot := make(map[string][]json.RawMessage)
+	v := reflect.ValueOf(st).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Field(i)
+		msgs := make([]json.RawMessage, f.Len())
+		for j := range msgs {
+			b, err := protojson.Marshal(f.Index(j).Interface().(proto.Message))
+			if err != nil {
+				return nil, err
+			}
+			msgs[j] = b
+		}
+		snapshot[v.Type().Field(i).Name] = msgs
+	}
+	return json.MarshalIndent(snapshot, "", "  ")
+}
+
+func (st *state) unmarshal(b []byte) error {
+	var snapshot map[string][]json.RawMessage
+	if err := json.Unmarshal(b, &snapshot); err != nil {
+		return err
+	}
+	v := reflect.ValueOf(st).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Field(i)
+		name := v.Type().Field(i).Name
+		for j, raw := range snapshot[name] {
+			m := reflect.New(f.Type().Elem().Elem())
+			if err := protojson.Unmarshal(raw, m.Interface().(proto.Message)); err != nil {
+				return fmt.Errorf("%s[%d]: %v", name, j, err)
+			}
+			f.Set(reflect.Append(f, m))
+		}
+	}
+	return nil
+}
+
+// Server is an in-process MLMD server covering types, artifacts, executions,
+// contexts, events, attributions, associations and parent contexts. Metadata
+// is kept in memory, and optionally persisted to a file after every change.
+//
+// Unlike MLMD, lists ordered by create time are ordered by ID instead, which
+// only differs for nodes created in the same millisecond, and artifact and
+// execution types have no input and output types.
+type Server struct {
+	pb.UnimplementedMetadataStoreServiceServer
+
+	mu    sync.RWMutex
+	state *state
+	// path is the file metadata is persisted to, or empty.
+	path string
+}
+
+// NewServer returns a server keeping metadata in memory. If path is not
+// empty, metadata is loaded from that file if it exists, and written back to
+// it after every change.
+func NewServer(path string) (*Server, error) {
+	s := &Server{state: &state{}, path: path}
+	if len(path) == 0 {
+		return s, nil
+	}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := s.state.unmarshal(b); err != nil {
+		return nil, fmt.Errorf("Failed to load metadata from %q: %v", path, err)
+	}
+	return s, nil
+}
+
+// save writes st to the server's file, if any. The file is replaced
+// atomically, so that a crash leaves either the old or the new metadata.
+func (s *Server) save(st *state) error {
+	if len(s.path) == 0 {
+		return nil
+	}
+	b, err := st.marshal()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// update applies fn to a copy of the state, which replaces the state if fn
+// succeeds, like a transaction.
+func (s *Server) update(fn func(st *state) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := s.state.copy()
+	if err := fn(st); err != nil {
+		return err
+	}
+	if err := s.save(st); err != nil {
+		return status.Errorf(codes.Internal, "Failed to persist metadata: %v", err)
+	}
+	s.state = st
+	return nil
+}
+
+// view calls fn with the current state, which it must not modify.
+func (s *Server) view(fn func(st *state)) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	fn(s.state)
+}
@@ -0,0 +1,44 @@
+package metadata
+
+import (
+	"context"
+	"fmt"
+
+	pb "github.com/neuromage/kfp-launcher/third_party/ml_metadata"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const iterationIndexProperty = "iteration_index"
+
+// GetIterator returns pipeline extended with the iterator context of taskID,
+// which is a child of the pipeline run context. Executions created for the
+// returned pipeline belong to the iterator context too, so that the
+// iterations of a task can be found together.
+func (c *Client) GetIterator(ctx context.Context, pipeline *Pipeline, taskID string) (*Pipeline, error) {
+	name := fmt.Sprintf("%s/%s", pipeline.pipelineRunCtx.GetName(), taskID)
+	iteratorCtx, err := c.getOrInsertContext(ctx, name, iteratorContextType)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.call(ctx, "PutParentContexts", func(ctx context.Context) error {
+		_, err := c.svc.PutParentContexts(ctx, &pb.PutParentContextsRequest{
+			ParentContexts: []*pb.ParentContext{{
+				ChildId:  iteratorCtx.Id,
+				ParentId: pipeline.pipelineRunCtx.Id,
+			}},
+		})
+		return err
+	})
+	// The iterator context is linked already when a task is retried.
+	if err != nil && status.Code(err) != codes.AlreadyExists {
+		return nil, err
+	}
+
+	return &Pipeline{
+		pipelineCtx:    pipeline.pipelineCtx,
+		pipelineRunCtx: pipeline.pipelineRunCtx,
+		iteratorCtx:    iteratorCtx,
+	}, nil
+}
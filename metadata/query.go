@@ -0,0 +1,342 @@
+package metadata
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	pb "github.com/neuromage/kfp-launcher/third_party/ml_metadata"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// artifactQuery is a parsed ArtifactQuerySpec filter. Empty fields match any
+// artifact.
+type artifactQuery struct {
+	contextName      string
+	typeName         string
+	uri              string
+	name             string
+	state            *pb.Artifact_State
+	properties       map[string]string
+	customProperties map[string]string
+}
+
+// parseArtifactFilter parses the filter of an ArtifactQuerySpec. Filters are
+// conditions of the form field=value, combined with "and", where field is
+// one of contexts.name, artifact_type, uri, name, state, properties['key']
+// and custom_properties['key']. Values are quoted with single or double
+// quotes, except states and numbers, which may be bare, e.g.
+//
+//	artifact_type='kfp.Model' and state=LIVE and custom_properties['stage']='production'
+func parseArtifactFilter(filter string) (*artifactQuery, error) {
+	toks, err := tokenizeFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	q := &artifactQuery{}
+	p := &filterParser{toks: toks}
+	for {
+		if err := p.condition(q); err != nil {
+			return nil, fmt.Errorf("Invalid artifact filter %q: %v", filter, err)
+		}
+		if p.done() {
+			return q, nil
+		}
+		if t := p.next(); t.kind != tokenWord || !strings.EqualFold(t.text, "and") {
+			return nil, fmt.Errorf("Invalid artifact filter %q: expected \"and\", got %q", filter, t.text)
+		}
+	}
+}
+
+type tokenKind int
+
+const (
+	// tokenWord is an identifier, bare value or keyword.
+	tokenWord tokenKind = iota
+	tokenString
+	tokenSymbol
+	tokenEnd
+)
+
+type filterToken struct {
+	kind tokenKind
+	text string
+}
+
+func tokenizeFilter(filter string) ([]filterToken, error) {
+	var toks []filterToken
+	rs := []rune(filter)
+	for i := 0; i < len(rs); {
+		r := rs[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '=' || r == '[' || r == ']':
+			toks = append(toks, filterToken{kind: tokenSymbol, text: string(r)})
+			i++
+		case r == '\'' || r == '"':
+			var b strings.Builder
+			j := i + 1
+			for ; j < len(rs) && rs[j] != r; j++ {
+				if rs[j] == '\\' && j+1 < len(rs) {
+					j++
+				}
+				b.WriteRune(rs[j])
+			}
+			if j == len(rs) {
+				return nil, fmt.Errorf("Unterminated string in artifact filter %q", filter)
+			}
+			toks = append(toks, filterToken{kind: tokenString, text: b.String()})
+			i = j + 1
+		default:
+			j := i
+			for ; j < len(rs) && !unicode.IsSpace(rs[j]) && !strings.ContainsRune("=[]'\"", rs[j]); j++ {
+			}
+			toks = append(toks, filterToken{kind: tokenWord, text: string(rs[i:j])})
+			i = j
+		}
+	}
+	return toks, nil
+}
+
+type filterParser struct {
+	toks []filterToken
+	pos  int
+}
+
+func (p *filterParser) done() bool {
+	return p.pos >= len(p.toks)
+}
+
+func (p *filterParser) next() filterToken {
+	if p.done() {
+		return filterToken{kind: tokenEnd, text: "end of filter"}
+	}
+	t := p.toks[p.pos]
+	p.pos++
+	return t
+}
+
+func (p *filterParser) expect(symbol string) error {
+	if t := p.next(); t.kind != tokenSymbol || t.text != symbol {
+		return fmt.Errorf("expected %q, got %q", symbol, t.text)
+	}
+	return nil
+}
+
+// condition parses field=value into q.
+func (p *filterParser) condition(q *artifactQuery) error {
+	field := p.next()
+	if field.kind != tokenWord {
+		return fmt.Errorf("expected a field, got %q", field.text)
+	}
+	var key string
+	if field.text == "properties" || field.text == "custom_properties" {
+		if err := p.expect("["); err != nil {
+			return err
+		}
+		k := p.next()
+		if k.kind != tokenString {
+			return fmt.Errorf("expected a quoted property name, got %q", k.text)
+		}
+		key = k.text
+		if err := p.expect("]"); err != nil {
+			return err
+		}
+	}
+	if err := p.expect("="); err != nil {
+		return err
+	}
+	value := p.next()
+	if value.kind != tokenString && value.kind != tokenWord {
+		return fmt.Errorf("expected a value for %s, got %q", field.text, value.text)
+	}
+
+	switch field.text {
+	case "contexts.name":
+		q.contextName = value.text
+	case "artifact_type":
+		q.typeName = value.text
+	case "uri":
+		q.uri = value.text
+	case "name":
+		q.name = value.text
+	case "state":
+		s, ok := pb.Artifact_State_value[strings.ToUpper(value.text)]
+		if !ok {
+			return fmt.Errorf("unknown artifact state %q", value.text)
+		}
+		q.state = pb.Artifact_State(s).Enum()
+	case "properties":
+		if q.properties == nil {
+			q.properties = make(map[string]string)
+		}
+		q.properties[key] = value.text
+	case "custom_properties":
+		if q.customProperties == nil {
+			q.customProperties = make(map[string]string)
+		}
+		q.customProperties[key] = value.text
+	default:
+		return fmt.Errorf("unknown field %q", field.text)
+	}
+	return nil
+}
+
+// valueMatches reports whether v equals text, comparing numbers numerically.
+func valueMatches(v *pb.Value, text string) bool {
+	switch t := v.GetValue().(type) {
+	case *pb.Value_StringValue:
+		return t.StringValue == text
+	case *pb.Value_IntValue:
+		i, err := strconv.ParseInt(text, 10, 64)
+		return err == nil && i == t.IntValue
+	case *pb.Value_DoubleValue:
+		f, err := strconv.ParseFloat(text, 64)
+		return err == nil && f == t.DoubleValue
+	}
+	return false
+}
+
+// matches reports whether a, of type typeID, satisfies q. typeID is zero if
+// q does not restrict the type.
+func (q *artifactQuery) matches(a *pb.Artifact, typeID int64) bool {
+	if typeID != 0 && a.GetTypeId() != typeID {
+		return false
+	}
+	if len(q.uri) > 0 && a.GetUri() != q.uri {
+		return false
+	}
+	if len(q.name) > 0 && a.GetName() != q.name {
+		return false
+	}
+	if q.state != nil && a.GetState() != *q.state {
+		return false
+	}
+	for k, v := range q.properties {
+		if !valueMatches(a.GetProperties()[k], v) {
+			return false
+		}
+	}
+	for k, v := range q.customProperties {
+		if !valueMatches(a.GetCustomProperties()[k], v) {
+			return false
+		}
+	}
+	return true
+}
+
+// QueryArtifacts returns up to limit artifacts matching filter, most recently
+// created first. See parseArtifactFilter for the filter syntax. Without a
+// contexts.name condition, the query is scoped to the pipeline context, and
+// otherwise to the pipeline or pipeline run context of that name. A limit of
+// zero or less returns one artifact.
+func (c *Client) QueryArtifacts(ctx context.Context, pipeline *Pipeline, filter string, limit int) ([]*pb.Artifact, error) {
+	q, err := parseArtifactFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	if limit <= 0 {
+		limit = 1
+	}
+
+	scope := pipeline.pipelineCtx
+	if len(q.contextName) > 0 {
+		scope, err = c.findContext(ctx, q.contextName)
+		if err != nil || scope == nil {
+			return nil, err
+		}
+	}
+
+	var typeID int64
+	if len(q.typeName) > 0 {
+		var res *pb.GetArtifactTypeResponse
+		err := c.call(ctx, "GetArtifactType", func(ctx context.Context) (err error) {
+			res, err = c.svc.GetArtifactType(ctx, &pb.GetArtifactTypeRequest{TypeName: proto.String(q.typeName)})
+			return err
+		})
+		if status.Code(err) == codes.NotFound {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		typeID = res.GetArtifactType().GetId()
+	}
+
+	artifacts, err := c.getArtifactsByContext(ctx, scope.GetId())
+	if err != nil {
+		return nil, err
+	}
+	var matched []*pb.Artifact
+	for _, a := range artifacts {
+		if q.matches(a, typeID) {
+			matched = append(matched, a)
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		if matched[i].GetCreateTimeSinceEpoch() != matched[j].GetCreateTimeSinceEpoch() {
+			return matched[i].GetCreateTimeSinceEpoch() > matched[j].GetCreateTimeSinceEpoch()
+		}
+		return matched[i].GetId() > matched[j].GetId()
+	})
+	if len(matched) > limit {
+		matched = matched[:limit]
+	}
+	return matched, nil
+}
+
+// findContext returns the pipeline or pipeline run context called name, or
+// nil if there is none.
+func (c *Client) findContext(ctx context.Context, name string) (*pb.Context, error) {
+	for _, t := range []*pb.ContextType{pipelineContextType, pipelineRunContextType} {
+		res, err := c.getContextByTypeAndName(ctx, name, t)
+		if status.Code(err) == codes.NotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if res.GetContext() != nil {
+			return res.GetContext(), nil
+		}
+	}
+	return nil, nil
+}
+
+// artifactsPageSize is the page size of artifact listings, MLMD's maximum.
+const artifactsPageSize = 100
+
+func (c *Client) getArtifactsByContext(ctx context.Context, contextID int64) ([]*pb.Artifact, error) {
+	var (
+		artifacts []*pb.Artifact
+		pageToken *string
+	)
+	for {
+		var res *pb.GetArtifactsByContextResponse
+		err := c.call(ctx, "GetArtifactsByContext", func(ctx context.Context) (err error) {
+			res, err = c.svc.GetArtifactsByContext(ctx, &pb.GetArtifactsByContextRequest{
+				ContextId: proto.Int64(contextID),
+				Options: &pb.ListOperationOptions{
+					MaxResultSize: proto.Int32(artifactsPageSize),
+					NextPageToken: pageToken,
+				},
+			})
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		artifacts = append(artifacts, res.GetArtifacts()...)
+		if len(res.GetNextPageToken()) == 0 {
+			return artifacts, nil
+		}
+		pageToken = res.NextPageToken
+	}
+}
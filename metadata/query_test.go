@@ -0,0 +1,195 @@
+package metadata
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	pb "github.com/neuromage/kfp-launcher/third_party/ml_metadata"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+func Test_parseArtifactFilter(t *testing.T) {
+	tests := []struct {
+		name    string
+		filter  string
+		want    *artifactQuery
+		wantErr bool
+	}{
+		{
+			name:   "All fields",
+			filter: `contexts.name='my-pipeline' AND artifact_type="kfp.Model" and state=LIVE and uri='gs://b/m' and name='m' and properties['epochs']=10 and custom_properties['stage']='it\'s production'`,
+			want: &artifactQuery{
+				contextName:      "my-pipeline",
+				typeName:         "kfp.Model",
+				uri:              "gs://b/m",
+				name:             "m",
+				state:            pb.Artifact_LIVE.Enum(),
+				properties:       map[string]string{"epochs": "10"},
+				customProperties: map[string]string{"stage": "it's production"},
+			},
+		},
+		{name: "Unknown field", filter: `owner='me'`, wantErr: true},
+		{name: "Unknown state", filter: `state=ALIVE`, wantErr: true},
+		{name: "Missing and", filter: `uri='a' name='b'`, wantErr: true},
+		{name: "Unquoted property name", filter: `properties[epochs]=10`, wantErr: true},
+		{name: "Unterminated string", filter: `uri='a`, wantErr: true},
+		{name: "Missing value", filter: `uri=`, wantErr: true},
+		{name: "Empty", filter: ``, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseArtifactFilter(tt.filter)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseArtifactFilter() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if diff := cmp.Diff(tt.want, got, cmp.AllowUnexported(artifactQuery{})); diff != "" {
+				t.Errorf("parseArtifactFilter() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// queryMetadataStore extends fakeMetadataStore with contexts, artifact types
+// and attributions. Artifacts are listed in pages of two.
+type queryMetadataStore struct {
+	*fakeMetadataStore
+
+	contexts      []*pb.Context
+	artifactTypes map[string]int64
+	// attributions maps context IDs to artifact IDs.
+	attributions map[int64][]int64
+}
+
+func (f *queryMetadataStore) GetContextByTypeAndName(ctx context.Context, in *pb.GetContextByTypeAndNameRequest, opts ...grpc.CallOption) (*pb.GetContextByTypeAndNameResponse, error) {
+	for _, c := range f.contexts {
+		if c.GetName() == in.GetContextName() {
+			return &pb.GetContextByTypeAndNameResponse{Context: c}, nil
+		}
+	}
+	return &pb.GetContextByTypeAndNameResponse{}, nil
+}
+
+func (f *queryMetadataStore) GetArtifactType(ctx context.Context, in *pb.GetArtifactTypeRequest, opts ...grpc.CallOption) (*pb.GetArtifactTypeResponse, error) {
+	id, ok := f.artifactTypes[in.GetTypeName()]
+	if !ok {
+		return nil, status.Error(codes.NotFound, "no such type")
+	}
+	return &pb.GetArtifactTypeResponse{ArtifactType: &pb.ArtifactType{Id: proto.Int64(id), Name: in.TypeName}}, nil
+}
+
+func (f *queryMetadataStore) GetArtifactsByContext(ctx context.Context, in *pb.GetArtifactsByContextRequest, opts ...grpc.CallOption) (*pb.GetArtifactsByContextResponse, error) {
+	ids := f.attributions[in.GetContextId()]
+	start := 0
+	if t := in.GetOptions().GetNextPageToken(); len(t) > 0 {
+		start, _ = strconv.Atoi(t)
+	}
+	end := start + 2
+	if end > len(ids) {
+		end = len(ids)
+	}
+
+	res := &pb.GetArtifactsByContextResponse{}
+	for _, id := range ids[start:end] {
+		for _, a := range f.artifacts {
+			if a.GetId() == id {
+				res.Artifacts = append(res.Artifacts, a)
+			}
+		}
+	}
+	if end < len(ids) {
+		res.NextPageToken = proto.String(strconv.Itoa(end))
+	}
+	return res, nil
+}
+
+func TestQueryArtifacts(t *testing.T) {
+	artifact := func(id, typeID, created int64, state pb.Artifact_State, stage string) *pb.Artifact {
+		return &pb.Artifact{
+			Id:                   proto.Int64(id),
+			TypeId:               proto.Int64(typeID),
+			Uri:                  proto.String("gs://b/" + strconv.FormatInt(id, 10)),
+			State:                state.Enum(),
+			CreateTimeSinceEpoch: proto.Int64(created),
+			CustomProperties:     map[string]*pb.Value{"stage": stringValue(stage)},
+		}
+	}
+	fake := &queryMetadataStore{
+		fakeMetadataStore: &fakeMetadataStore{artifacts: []*pb.Artifact{
+			artifact(1, 10, 100, pb.Artifact_LIVE, "production"),
+			artifact(2, 10, 300, pb.Artifact_LIVE, "staging"),
+			artifact(3, 10, 200, pb.Artifact_LIVE, "production"),
+			artifact(4, 10, 400, pb.Artifact_DELETED, "production"),
+			artifact(5, 11, 500, pb.Artifact_LIVE, "production"),
+			artifact(6, 10, 600, pb.Artifact_LIVE, "production"),
+		}},
+		contexts: []*pb.Context{
+			{Id: proto.Int64(1), Name: proto.String("my-pipeline")},
+			{Id: proto.Int64(2), Name: proto.String("other-pipeline")},
+		},
+		artifactTypes: map[string]int64{"kfp.Model": 10, "kfp.Dataset": 11},
+		attributions: map[int64][]int64{
+			1: {1, 2, 3, 4, 5},
+			2: {6},
+		},
+	}
+	c := &Client{svc: fake}
+	pipeline := &Pipeline{
+		pipelineCtx:    fake.contexts[0],
+		pipelineRunCtx: &pb.Context{Id: proto.Int64(3), Name: proto.String("my-run")},
+	}
+
+	tests := []struct {
+		name    string
+		filter  string
+		limit   int
+		wantIDs []int64
+	}{
+		{
+			name:    "Latest LIVE model",
+			filter:  `artifact_type='kfp.Model' and state=LIVE`,
+			wantIDs: []int64{2},
+		},
+		{
+			name:    "Production models",
+			filter:  `artifact_type='kfp.Model' and custom_properties['stage']='production'`,
+			limit:   5,
+			wantIDs: []int64{4, 3, 1},
+		},
+		{
+			name:    "Other context",
+			filter:  `contexts.name='other-pipeline' and custom_properties['stage']='production'`,
+			wantIDs: []int64{6},
+		},
+		{
+			name:   "Unknown context",
+			filter: `contexts.name='missing'`,
+		},
+		{
+			name:   "Unknown type",
+			filter: `artifact_type='kfp.Metrics'`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := c.QueryArtifacts(context.Background(), pipeline, tt.filter, tt.limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var gotIDs []int64
+			for _, a := range got {
+				gotIDs = append(gotIDs, a.GetId())
+			}
+			if diff := cmp.Diff(tt.wantIDs, gotIDs); diff != "" {
+				t.Errorf("QueryArtifacts() IDs mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package metadata
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/golang/glog"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// RetryOptions controls how the Client retries failed metadata store RPCs.
+type RetryOptions struct {
+	// MaxAttempts is the total number of attempts made for each RPC,
+	// including the first one.
+	MaxAttempts int
+	// RPCTimeout bounds each individual attempt. Zero means no per-attempt
+	// deadline beyond the caller's context.
+	RPCTimeout time.Duration
+	// InitialBackoff is the delay before the first retry. Each following
+	// retry waits Multiplier times longer, up to MaxBackoff. Delays are
+	// randomized by up to half their length to avoid synchronized retries.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+}
+
+// DefaultRetryOptions rides out a metadata store restart of a minute or so.
+var DefaultRetryOptions = RetryOptions{
+	MaxAttempts:    8,
+	RPCTimeout:     30 * time.Second,
+	InitialBackoff: 500 * time.Millisecond,
+	MaxBackoff:     30 * time.Second,
+	Multiplier:     2,
+}
+
+// isRetryable reports whether err is a transient failure worth retrying.
+func isRetryable(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Aborted:
+		return true
+	}
+	return false
+}
+
+// backoff returns the randomized delay before retry number n, starting at 0.
+func (o *RetryOptions) backoff(n int) time.Duration {
+	d := float64(o.InitialBackoff)
+	for i := 0; i < n; i++ {
+		d *= o.Multiplier
+		if o.MaxBackoff > 0 && d > float64(o.MaxBackoff) {
+			d = float64(o.MaxBackoff)
+			break
+		}
+	}
+	return time.Duration(d/2 + rand.Float64()*d/2)
+}
+
+// call invokes rpc, retrying retryable failures according to c.retry. Each
+// attempt gets its own deadline, and retries stop as soon as ctx is done.
+//
+// Only reads and idempotent writes may be retried this way. Other writes use
+// callWrite.
+func (c *Client) call(ctx context.Context, name string, rpc func(ctx context.Context) error) error {
+	return c.retryLoop(ctx, name, rpc, nil)
+}
+
+// callWrite invokes rpc, a write that must not be applied twice. A retryable
+// failure does not tell whether the write was applied, so before sending it
+// again, applied is called to look for its effect; if it finds it, callWrite
+// returns successfully without resending. A nil applied means the write
+// cannot be looked up, and it is not retried at all.
+func (c *Client) callWrite(ctx context.Context, name string, rpc func(ctx context.Context) error, applied func(ctx context.Context) (bool, error)) error {
+	if applied == nil {
+		return c.attempt(ctx, rpc)
+	}
+	return c.retryLoop(ctx, name, rpc, applied)
+}
+
+func (c *Client) retryLoop(ctx context.Context, name string, rpc func(ctx context.Context) error, applied func(ctx context.Context) (bool, error)) error {
+	attempts := c.retry.MaxAttempts
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	var err error
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			d := c.retry.backoff(i - 1)
+			glog.Warningf("%s failed (attempt %d/%d), retrying in %v: %v", name, i, attempts, d, err)
+			select {
+			case <-ctx.Done():
+				return err
+			case <-time.After(d):
+			}
+			if applied != nil {
+				ok, lookupErr := applied(ctx)
+				if lookupErr != nil {
+					glog.Errorf("%s failed, and looking up whether it was applied failed too: %v", name, lookupErr)
+					return err
+				}
+				if ok {
+					glog.Infof("%s was applied despite failing: %v", name, err)
+					return nil
+				}
+			}
+		}
+
+		err = c.attempt(ctx, rpc)
+		if err == nil || !isRetryable(err) || ctx.Err() != nil {
+			return err
+		}
+	}
+	return err
+}
+
+func (c *Client) attempt(ctx context.Context, rpc func(ctx context.Context) error) error {
+	if c.retry.RPCTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.retry.RPCTimeout)
+		defer cancel()
+	}
+	return rpc(ctx)
+}
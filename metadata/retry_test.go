@@ -0,0 +1,243 @@
+package metadata
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	pb "github.com/neuromage/kfp-launcher/third_party/ml_metadata"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// flakyMetadataStore fails the first len(errs) PutExecution calls with the
+// given errors. A codes.DeadlineExceeded error blocks until the attempt's
+// deadline instead of failing immediately.
+type flakyMetadataStore struct {
+	pb.MetadataStoreServiceClient
+
+	errs     []error
+	attempts int
+}
+
+func (f *flakyMetadataStore) PutExecution(ctx context.Context, in *pb.PutExecutionRequest, opts ...grpc.CallOption) (*pb.PutExecutionResponse, error) {
+	f.attempts++
+	if f.attempts <= len(f.errs) {
+		err := f.errs[f.attempts-1]
+		if status.Code(err) == codes.DeadlineExceeded {
+			if _, ok := ctx.Deadline(); !ok {
+				return nil, status.Error(codes.Internal, "attempt has no deadline")
+			}
+			<-ctx.Done()
+		}
+		return nil, err
+	}
+	return &pb.PutExecutionResponse{ExecutionId: proto.Int64(1)}, nil
+}
+
+var fastRetries = RetryOptions{
+	MaxAttempts:    3,
+	RPCTimeout:     50 * time.Millisecond,
+	InitialBackoff: time.Millisecond,
+	MaxBackoff:     5 * time.Millisecond,
+	Multiplier:     2,
+}
+
+// updateRequest updates an existing execution, which is safe to retry.
+var updateRequest = &pb.PutExecutionRequest{Execution: &pb.Execution{Id: proto.Int64(1)}}
+
+func TestClient_call(t *testing.T) {
+	unavailable := status.Error(codes.Unavailable, "connection refused")
+	deadline := status.Error(codes.DeadlineExceeded, "deadline exceeded")
+	invalid := status.Error(codes.InvalidArgument, "bad request")
+
+	tests := []struct {
+		name         string
+		errs         []error
+		wantCode     codes.Code
+		wantAttempts int
+	}{
+		{name: "Succeeds first time", errs: nil, wantCode: codes.OK, wantAttempts: 1},
+		{name: "Recovers from transient failures", errs: []error{unavailable, unavailable}, wantCode: codes.OK, wantAttempts: 3},
+		{name: "Retries timed out attempts", errs: []error{deadline}, wantCode: codes.OK, wantAttempts: 2},
+		{name: "Gives up after MaxAttempts", errs: []error{unavailable, unavailable, unavailable}, wantCode: codes.Unavailable, wantAttempts: 3},
+		{name: "Does not retry permanent failures", errs: []error{invalid}, wantCode: codes.InvalidArgument, wantAttempts: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &flakyMetadataStore{errs: tt.errs}
+			c := &Client{svc: fake, retry: fastRetries}
+
+			_, err := c.putExecution(context.Background(), updateRequest)
+			if got := status.Code(err); got != tt.wantCode {
+				t.Errorf("putExecution() error = %v, want code %v", err, tt.wantCode)
+			}
+			if fake.attempts != tt.wantAttempts {
+				t.Errorf("Got %d attempts, want %d", fake.attempts, tt.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestClient_call_StopsWhenContextIsDone(t *testing.T) {
+	fake := &flakyMetadataStore{errs: []error{
+		status.Error(codes.Unavailable, "connection refused"),
+		status.Error(codes.Unavailable, "connection refused"),
+	}}
+	retry := fastRetries
+	retry.InitialBackoff = time.Hour
+	c := &Client{svc: fake, retry: retry}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := c.putExecution(ctx, updateRequest); status.Code(err) != codes.Unavailable {
+		t.Errorf("putExecution() error = %v, want the last RPC error", err)
+	}
+	if fake.attempts != 1 {
+		t.Errorf("Got %d attempts, want 1", fake.attempts)
+	}
+}
+
+// lossyMetadataStore applies writes, but fails the first one of each kind
+// with Unavailable as if the response had been lost.
+type lossyMetadataStore struct {
+	pb.MetadataStoreServiceClient
+
+	executions   []*pb.Execution
+	artifacts    []*pb.Artifact
+	putAttempts  int
+	putArtifacts int
+}
+
+func (f *lossyMetadataStore) PutExecution(ctx context.Context, in *pb.PutExecutionRequest, opts ...grpc.CallOption) (*pb.PutExecutionResponse, error) {
+	f.putAttempts++
+	e := proto.Clone(in.GetExecution()).(*pb.Execution)
+	e.Id = proto.Int64(int64(len(f.executions) + 1))
+	f.executions = append(f.executions, e)
+	if f.putAttempts == 1 {
+		return nil, status.Error(codes.Unavailable, "response lost")
+	}
+	return &pb.PutExecutionResponse{ExecutionId: e.Id}, nil
+}
+
+func (f *lossyMetadataStore) GetExecutionTypesByID(ctx context.Context, in *pb.GetExecutionTypesByIDRequest, opts ...grpc.CallOption) (*pb.GetExecutionTypesByIDResponse, error) {
+	return &pb.GetExecutionTypesByIDResponse{ExecutionTypes: []*pb.ExecutionType{{Id: proto.Int64(100), Name: proto.String(string(ContainerExecution))}}}, nil
+}
+
+func (f *lossyMetadataStore) GetExecutionByTypeAndName(ctx context.Context, in *pb.GetExecutionByTypeAndNameRequest, opts ...grpc.CallOption) (*pb.GetExecutionByTypeAndNameResponse, error) {
+	for _, e := range f.executions {
+		if e.GetName() == in.GetExecutionName() {
+			return &pb.GetExecutionByTypeAndNameResponse{Execution: e}, nil
+		}
+	}
+	return &pb.GetExecutionByTypeAndNameResponse{}, nil
+}
+
+func (f *lossyMetadataStore) PutArtifacts(ctx context.Context, in *pb.PutArtifactsRequest, opts ...grpc.CallOption) (*pb.PutArtifactsResponse, error) {
+	f.putArtifacts++
+	res := &pb.PutArtifactsResponse{}
+	for _, a := range in.GetArtifacts() {
+		a = proto.Clone(a).(*pb.Artifact)
+		a.Id = proto.Int64(int64(len(f.artifacts) + 1))
+		f.artifacts = append(f.artifacts, a)
+		res.ArtifactIds = append(res.ArtifactIds, a.GetId())
+	}
+	if f.putArtifacts == 1 {
+		return nil, status.Error(codes.Unavailable, "response lost")
+	}
+	return res, nil
+}
+
+func (f *lossyMetadataStore) GetArtifactsByURI(ctx context.Context, in *pb.GetArtifactsByURIRequest, opts ...grpc.CallOption) (*pb.GetArtifactsByURIResponse, error) {
+	res := &pb.GetArtifactsByURIResponse{}
+	for _, a := range f.artifacts {
+		if a.GetUri() == in.GetUris()[0] {
+			res.Artifacts = append(res.Artifacts, a)
+		}
+	}
+	return res, nil
+}
+
+func (f *lossyMetadataStore) GetArtifactsByID(ctx context.Context, in *pb.GetArtifactsByIDRequest, opts ...grpc.CallOption) (*pb.GetArtifactsByIDResponse, error) {
+	return &pb.GetArtifactsByIDResponse{Artifacts: []*pb.Artifact{f.artifacts[in.GetArtifactIds()[0]-1]}}, nil
+}
+
+func TestClient_putExecution_LooksUpInsertsBeforeResending(t *testing.T) {
+	tests := []struct {
+		name           string
+		execution      *pb.Execution
+		wantErr        bool
+		wantExecutions int
+	}{
+		{
+			name:           "Named execution is found",
+			execution:      &pb.Execution{TypeId: proto.Int64(100), Name: proto.String("my-run/task/attempt-0")},
+			wantExecutions: 1,
+		},
+		{
+			name:           "Unnamed execution is not resent",
+			execution:      &pb.Execution{TypeId: proto.Int64(100)},
+			wantErr:        true,
+			wantExecutions: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &lossyMetadataStore{}
+			c := &Client{svc: fake, retry: fastRetries}
+
+			id, err := c.putExecution(context.Background(), &pb.PutExecutionRequest{Execution: tt.execution})
+			if gotErr := err != nil; gotErr != tt.wantErr {
+				t.Fatalf("putExecution() error = %v, want error: %v", err, tt.wantErr)
+			}
+			if fake.putAttempts != 1 || len(fake.executions) != tt.wantExecutions {
+				t.Errorf("Got %d attempts and %d executions, want 1 and %d", fake.putAttempts, len(fake.executions), tt.wantExecutions)
+			}
+			if !tt.wantErr && id != 1 {
+				t.Errorf("putExecution() = %d, want the inserted execution 1", id)
+			}
+		})
+	}
+}
+
+func TestClient_putArtifact_LooksUpInsertsBeforeResending(t *testing.T) {
+	fake := &lossyMetadataStore{
+		// An earlier import of the same URI, which must not be mistaken for
+		// the inserted artifact.
+		artifacts: []*pb.Artifact{{Id: proto.Int64(1), TypeId: proto.Int64(200), Uri: proto.String("gs://b/model")}},
+	}
+	c := &Client{svc: fake, retry: fastRetries}
+
+	got, err := c.putArtifact(context.Background(), &pb.Artifact{TypeId: proto.Int64(200), Uri: proto.String("gs://b/model")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.GetId() != 2 {
+		t.Errorf("putArtifact() = artifact %d, want the inserted artifact 2", got.GetId())
+	}
+	if fake.putArtifacts != 1 || len(fake.artifacts) != 2 {
+		t.Errorf("Got %d PutArtifacts calls and %d artifacts, want 1 and 2", fake.putArtifacts, len(fake.artifacts))
+	}
+}
+
+func TestRetryOptions_backoff(t *testing.T) {
+	o := &RetryOptions{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second, Multiplier: 2}
+	tests := []struct {
+		retry int
+		max   time.Duration
+	}{
+		{retry: 0, max: 100 * time.Millisecond},
+		{retry: 1, max: 200 * time.Millisecond},
+		{retry: 2, max: 400 * time.Millisecond},
+		{retry: 10, max: time.Second},
+	}
+	for _, tt := range tests {
+		for i := 0; i < 20; i++ {
+			if got := o.backoff(tt.retry); got < tt.max/2 || got > tt.max {
+				t.Errorf("backoff(%d) = %v, want in [%v, %v]", tt.retry, got, tt.max/2, tt.max)
+			}
+		}
+	}
+}
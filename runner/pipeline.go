@@ -0,0 +1,89 @@
+// Package runner runs compiled pipelines locally, without Kubernetes.
+package runner
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/neuromage/kfp-launcher/third_party/pipeline_spec"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// Pipeline is a compiled pipeline to run.
+type Pipeline struct {
+	Spec       *pipeline_spec.PipelineSpec
+	Deployment *pipeline_spec.PipelineDeploymentConfig
+	// Parameters are the runtime parameter values of a PipelineJob.
+	Parameters map[string]*pipeline_spec.Value
+	// PipelineRoot is the output directory of a PipelineJob, if any.
+	PipelineRoot string
+}
+
+// unmarshalOptions ignore fields added by newer pipeline compilers.
+var unmarshalOptions = protojson.UnmarshalOptions{DiscardUnknown: true}
+
+// LoadPipeline reads a JSON encoded PipelineJob or PipelineSpec from p.
+func LoadPipeline(p string) (*Pipeline, error) {
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	pipeline, err := parsePipeline(b)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load pipeline from %q: %v", p, err)
+	}
+	return pipeline, nil
+}
+
+func parsePipeline(b []byte) (*Pipeline, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return nil, err
+	}
+
+	p := &Pipeline{
+		Spec:       &pipeline_spec.PipelineSpec{},
+		Deployment: &pipeline_spec.PipelineDeploymentConfig{},
+	}
+	specJSON := b
+	_, isJob := fields["pipelineSpec"]
+	if _, ok := fields["pipeline_spec"]; ok {
+		isJob = true
+	}
+	if isJob {
+		job := &pipeline_spec.PipelineJob{}
+		if err := unmarshalOptions.Unmarshal(b, job); err != nil {
+			return nil, err
+		}
+		var err error
+		if specJSON, err = protojson.Marshal(job.GetPipelineSpec()); err != nil {
+			return nil, err
+		}
+		p.Parameters = job.GetRuntimeConfig().GetParameters()
+		p.PipelineRoot = job.GetRuntimeConfig().GetGcsOutputDirectory()
+	}
+	if err := unmarshalOptions.Unmarshal(specJSON, p.Spec); err != nil {
+		return nil, err
+	}
+
+	if p.Spec.GetRoot().GetDag() == nil {
+		return nil, fmt.Errorf("Pipeline spec has no root DAG")
+	}
+	if p.Spec.GetDeploymentSpec() == nil {
+		return nil, fmt.Errorf("Pipeline spec has no deployment spec")
+	}
+	b, err := protojson.Marshal(p.Spec.GetDeploymentSpec())
+	if err != nil {
+		return nil, err
+	}
+	if err := unmarshalOptions.Unmarshal(b, p.Deployment); err != nil {
+		return nil, fmt.Errorf("Invalid deployment spec: %v", err)
+	}
+	return p, nil
+}
+
+// Name returns the pipeline name.
+func (p *Pipeline) Name() string {
+	return p.Spec.GetPipelineInfo().GetName()
+}
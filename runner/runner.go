@@ -0,0 +1,451 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/neuromage/kfp-launcher/component"
+	"github.com/neuromage/kfp-launcher/third_party/pipeline_spec"
+)
+
+// Options configure a local pipeline run.
+type Options struct {
+	// Launcher holds the launcher options shared by all tasks, e.g. how to
+	// reach MLMD. The pipeline, run, task, root, image and caching options are
+	// set per task by the runner.
+	Launcher component.LauncherOptions
+	// PipelineRoot defaults to the output directory of the PipelineJob, or
+	// file://<WorkDir>/pipeline_root.
+	PipelineRoot string
+	// RunID defaults to the pipeline name followed by the current time.
+	RunID string
+	// WorkDir is the absolute path of the directory holding the input and
+	// output files passed between tasks.
+	WorkDir string
+	// Parameters override runtime parameter values, in their text form.
+	Parameters map[string]string
+}
+
+// task is a task of the root DAG.
+type task struct {
+	name      string
+	spec      *pipeline_spec.PipelineTaskSpec
+	component *pipeline_spec.ComponentSpec
+	executor  *pipeline_spec.PipelineDeploymentConfig_ExecutorSpec
+	// dependencies are the names of the tasks that must run first.
+	dependencies []string
+}
+
+// taskOutputs are the output files of a finished task, by output name.
+type taskOutputs struct {
+	parameters map[string]string
+	artifacts  map[string]string
+}
+
+// run is the state of a pipeline run.
+type run struct {
+	pipeline *Pipeline
+	options  *Options
+	// parameters are the values of the pipeline runtime parameters.
+	parameters map[string]string
+	outputs    map[string]*taskOutputs
+}
+
+// Run runs the tasks of the root DAG of p, one at a time in dependency order,
+// with their container commands as local processes. Images are not pulled:
+// commands must be available on the host. Tasks pass parameters and
+// artifacts to each other through files in WorkDir and the pipeline root, and
+// are recorded in MLMD like on a cluster.
+//
+// Nested DAGs, trigger conditions and custom jobs are not supported. The
+// first failed task stops the run; its error satisfies
+// component.UserCommandExitCode and component.IsCanceled like those of
+// component.Launcher.
+func Run(ctx context.Context, p *Pipeline, options *Options) error {
+	if !filepath.IsAbs(options.WorkDir) {
+		return fmt.Errorf("WorkDir must be an absolute path, got %q", options.WorkDir)
+	}
+	o := *options
+	if len(o.RunID) == 0 {
+		o.RunID = fmt.Sprintf("%s-%s", p.Name(), time.Now().Format("20060102-150405"))
+	}
+	if len(o.PipelineRoot) == 0 {
+		o.PipelineRoot = p.PipelineRoot
+	}
+	if len(o.PipelineRoot) == 0 {
+		o.PipelineRoot = "file://" + filepath.Join(o.WorkDir, "pipeline_root")
+	}
+	if strings.HasPrefix(o.PipelineRoot, "file://") {
+		if err := os.MkdirAll(strings.TrimPrefix(o.PipelineRoot, "file://"), 0755); err != nil {
+			return err
+		}
+	}
+
+	tasks, err := dagTasks(p)
+	if err != nil {
+		return err
+	}
+	order, err := sortTasks(tasks)
+	if err != nil {
+		return err
+	}
+	parameters, err := pipelineParameters(p, o.Parameters)
+	if err != nil {
+		return err
+	}
+
+	r := &run{
+		pipeline:   p,
+		options:    &o,
+		parameters: parameters,
+		outputs:    make(map[string]*taskOutputs),
+	}
+	glog.Infof("Running pipeline %q as run %q with pipeline root %q", p.Name(), o.RunID, o.PipelineRoot)
+	for _, t := range order {
+		glog.Infof("Running task %q", t.name)
+		if err := r.runTask(ctx, t); err != nil {
+			return fmt.Errorf("Task %q failed: %w", t.name, err)
+		}
+	}
+	return nil
+}
+
+// dagTasks returns the tasks of the root DAG of p, by name.
+func dagTasks(p *Pipeline) (map[string]*task, error) {
+	tasks := make(map[string]*task)
+	for name, spec := range p.Spec.GetRoot().GetDag().GetTasks() {
+		c, ok := p.Spec.GetComponents()[spec.GetComponentRef().GetName()]
+		if !ok {
+			return nil, fmt.Errorf("Task %q refers to unknown component %q", name, spec.GetComponentRef().GetName())
+		}
+		if c.GetDag() != nil {
+			return nil, fmt.Errorf("Task %q runs a nested DAG, which is not supported", name)
+		}
+		if len(spec.GetTriggerPolicy().GetCondition()) > 0 {
+			return nil, fmt.Errorf("Task %q has a trigger condition, which is not supported", name)
+		}
+		e, ok := p.Deployment.GetExecutors()[c.GetExecutorLabel()]
+		if !ok {
+			return nil, fmt.Errorf("Task %q refers to unknown executor %q", name, c.GetExecutorLabel())
+		}
+
+		t := &task{name: name, spec: spec, component: c, executor: e}
+		deps := make(map[string]bool)
+		for _, d := range spec.GetDependentTasks() {
+			deps[d] = true
+		}
+		for _, ip := range spec.GetInputs().GetParameters() {
+			if producer := ip.GetTaskOutputParameter().GetProducerTask(); len(producer) > 0 {
+				deps[producer] = true
+			}
+		}
+		for _, ia := range spec.GetInputs().GetArtifacts() {
+			if producer := ia.GetTaskOutputArtifact().GetProducerTask(); len(producer) > 0 {
+				deps[producer] = true
+			}
+		}
+		for d := range deps {
+			t.dependencies = append(t.dependencies, d)
+		}
+		sort.Strings(t.dependencies)
+		tasks[name] = t
+	}
+	return tasks, nil
+}
+
+// sortTasks orders tasks so that each task comes after its dependencies.
+// Tasks that are ready at the same time are ordered by name.
+func sortTasks(tasks map[string]*task) ([]*task, error) {
+	pending := make(map[string]int)
+	dependents := make(map[string][]string)
+	for name, t := range tasks {
+		for _, d := range t.dependencies {
+			if _, ok := tasks[d]; !ok {
+				return nil, fmt.Errorf("Task %q depends on unknown task %q", name, d)
+			}
+			dependents[d] = append(dependents[d], name)
+		}
+		pending[name] = len(t.dependencies)
+	}
+
+	var ready []string
+	for name, n := range pending {
+		if n == 0 {
+			ready = append(ready, name)
+		}
+	}
+	var order []*task
+	for len(ready) > 0 {
+		sort.Strings(ready)
+		name := ready[0]
+		ready = ready[1:]
+		order = append(order, tasks[name])
+		for _, d := range dependents[name] {
+			pending[d]--
+			if pending[d] == 0 {
+				ready = append(ready, d)
+			}
+		}
+	}
+
+	if len(order) < len(tasks) {
+		var cycle []string
+		for name, n := range pending {
+			if n > 0 {
+				cycle = append(cycle, name)
+			}
+		}
+		sort.Strings(cycle)
+		return nil, fmt.Errorf("Tasks %v have cyclic dependencies", cycle)
+	}
+	return order, nil
+}
+
+// pipelineParameters returns the values of the runtime parameters of p, from
+// overrides, the PipelineJob or their defaults, in that order.
+func pipelineParameters(p *Pipeline, overrides map[string]string) (map[string]string, error) {
+	parameters := make(map[string]string)
+	for name, rp := range p.Spec.GetRuntimeParameters() {
+		if v := rp.GetDefaultValue(); v != nil {
+			parameters[name] = valueText(v)
+		}
+	}
+	for name, v := range p.Parameters {
+		parameters[name] = valueText(v)
+	}
+	for name, v := range overrides {
+		if !isPipelineParameter(p, name) {
+			return nil, fmt.Errorf("Unknown pipeline parameter %q", name)
+		}
+		parameters[name] = v
+	}
+	return parameters, nil
+}
+
+func isPipelineParameter(p *Pipeline, name string) bool {
+	if _, ok := p.Spec.GetRuntimeParameters()[name]; ok {
+		return true
+	}
+	_, ok := p.Spec.GetRoot().GetInputDefinitions().GetParameters()[name]
+	return ok
+}
+
+// valueText returns the text form of v, as passed in runtime info.
+func valueText(v *pipeline_spec.Value) string {
+	switch v := v.GetValue().(type) {
+	case *pipeline_spec.Value_IntValue:
+		return strconv.FormatInt(v.IntValue, 10)
+	case *pipeline_spec.Value_DoubleValue:
+		return strconv.FormatFloat(v.DoubleValue, 'f', -1, 64)
+	case *pipeline_spec.Value_StringValue:
+		return v.StringValue
+	}
+	return ""
+}
+
+// parameterType returns the runtime info type of a primitive type.
+func parameterType(t pipeline_spec.PrimitiveType_PrimitiveTypeEnum) string {
+	switch t {
+	case pipeline_spec.PrimitiveType_INT:
+		return "INT"
+	case pipeline_spec.PrimitiveType_DOUBLE:
+		return "DOUBLE"
+	}
+	return "STRING"
+}
+
+// artifactSchema returns the schema of an artifact type.
+func artifactSchema(t *pipeline_spec.ArtifactTypeSchema) (string, error) {
+	switch {
+	case len(t.GetInstanceSchema()) > 0:
+		return t.GetInstanceSchema(), nil
+	case len(t.GetSchemaTitle()) > 0:
+		return fmt.Sprintf("title: %s\n", t.GetSchemaTitle()), nil
+	case len(t.GetSchemaUri()) > 0:
+		return "", fmt.Errorf("Artifact type schema URI %q is not supported, use an instance schema or schema title", t.GetSchemaUri())
+	}
+	return "title: kfp.Artifact\n", nil
+}
+
+func (r *run) taskDir(name string) string {
+	return filepath.Join(r.options.WorkDir, "tasks", name)
+}
+
+// runTask runs t with the launcher.
+func (r *run) runTask(ctx context.Context, t *task) error {
+	rt, outputs, err := r.runtimeInfo(t)
+	if err != nil {
+		return err
+	}
+	// Do not pass on outputs of an earlier run in the same directory.
+	if err := os.RemoveAll(filepath.Join(r.taskDir(t.name), "outputs")); err != nil {
+		return err
+	}
+	runtimeInfo, err := rt.JSON()
+	if err != nil {
+		return err
+	}
+
+	opts := r.options.Launcher
+	opts.PipelineName = r.pipeline.Name()
+	opts.PipelineRunID = r.options.RunID
+	opts.PipelineTaskID = t.name
+	opts.PipelineRoot = r.options.PipelineRoot
+	opts.TaskName = t.spec.GetTaskInfo().GetName()
+	if len(opts.TaskName) == 0 {
+		opts.TaskName = t.name
+	}
+	opts.CachingOptions = t.spec.GetCachingOptions()
+	opts.ContainerImage = t.executor.GetContainer().GetImage()
+	opts.LocalDir = filepath.Join(r.taskDir(t.name), "local")
+
+	l, err := component.NewLauncher(runtimeInfo, &opts)
+	if err != nil {
+		return err
+	}
+	switch {
+	case t.executor.GetContainer() != nil:
+		c := t.executor.GetContainer()
+		cmd := append(append([]string{}, c.GetCommand()...), c.GetArgs()...)
+		if len(cmd) == 0 {
+			return fmt.Errorf("Executor has no command")
+		}
+		err = l.RunComponent(ctx, cmd[0], cmd[1:]...)
+	case t.executor.GetImporter() != nil:
+		err = l.RunImporter(ctx, t.executor.GetImporter())
+	case t.executor.GetResolver() != nil:
+		err = l.RunResolver(ctx, t.executor.GetResolver())
+	default:
+		err = fmt.Errorf("Executor type is not supported")
+	}
+	if err != nil {
+		return err
+	}
+	r.outputs[t.name] = outputs
+	return nil
+}
+
+// runtimeInfo returns the runtime info of t, with its inputs wired to the
+// outputs of finished tasks and the pipeline parameters, and the output files
+// it writes.
+func (r *run) runtimeInfo(t *task) (*component.RuntimeInfoBuilder, *taskOutputs, error) {
+	rt := component.NewRuntimeInfoBuilder()
+
+	// The launcher provides the item input of an iterator.
+	var itemInput string
+	switch {
+	case t.spec.GetArtifactIterator() != nil:
+		it := t.spec.GetArtifactIterator()
+		itemInput = it.GetItemInput()
+		rt.SetArtifactIterator(it.GetItems().GetInputArtifact(), itemInput)
+	case t.spec.GetParameterIterator() != nil:
+		it := t.spec.GetParameterIterator()
+		itemInput = it.GetItemInput()
+		if items := it.GetItems(); len(items.GetInputParameter()) > 0 {
+			rt.SetParameterIterator(items.GetInputParameter(), itemInput)
+		} else {
+			rt.SetRawParameterIterator(items.GetRaw(), itemInput)
+		}
+	}
+
+	for name, ip := range t.spec.GetInputs().GetParameters() {
+		if name == itemInput {
+			continue
+		}
+		v, err := r.inputParameterValue(ip)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Input parameter %q: %v", name, err)
+		}
+		rt.AddInputParameter(name, parameterType(t.component.GetInputDefinitions().GetParameters()[name].GetType()), v)
+	}
+	for name, ia := range t.spec.GetInputs().GetArtifacts() {
+		if name == itemInput {
+			continue
+		}
+		p, err := r.inputArtifactFile(ia)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Input artifact %q: %v", name, err)
+		}
+		rt.AddInputArtifact(name, p)
+	}
+
+	outputs := &taskOutputs{
+		parameters: make(map[string]string),
+		artifacts:  make(map[string]string),
+	}
+	dir := filepath.Join(r.taskDir(t.name), "outputs")
+	for name, op := range t.component.GetOutputDefinitions().GetParameters() {
+		p := filepath.Join(dir, "parameters", name)
+		rt.AddOutputParameter(name, parameterType(op.GetType()), p)
+		outputs.parameters[name] = p
+	}
+	for name, oa := range t.component.GetOutputDefinitions().GetArtifacts() {
+		schema, err := artifactSchema(oa.GetArtifactType())
+		if err != nil {
+			return nil, nil, fmt.Errorf("Output artifact %q: %v", name, err)
+		}
+		p := filepath.Join(dir, "artifacts", name)
+		rt.AddOutputArtifact(name, schema, p)
+		outputs.artifacts[name] = p
+	}
+	return rt, outputs, nil
+}
+
+func (r *run) inputParameterValue(ip *pipeline_spec.TaskInputsSpec_InputParameterSpec) (string, error) {
+	switch {
+	case ip.GetTaskOutputParameter() != nil:
+		top := ip.GetTaskOutputParameter()
+		producer, ok := r.outputs[top.GetProducerTask()]
+		if !ok {
+			return "", fmt.Errorf("Task %q has not run", top.GetProducerTask())
+		}
+		p, ok := producer.parameters[top.GetOutputParameterKey()]
+		if !ok {
+			return "", fmt.Errorf("Task %q has no output parameter %q", top.GetProducerTask(), top.GetOutputParameterKey())
+		}
+		b, err := ioutil.ReadFile(p)
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	case ip.GetRuntimeValue().GetConstantValue() != nil:
+		return valueText(ip.GetRuntimeValue().GetConstantValue()), nil
+	case len(ip.GetRuntimeValue().GetRuntimeParameter()) > 0:
+		return r.pipelineParameter(ip.GetRuntimeValue().GetRuntimeParameter())
+	case len(ip.GetComponentInputParameter()) > 0:
+		return r.pipelineParameter(ip.GetComponentInputParameter())
+	}
+	return "", fmt.Errorf("No value")
+}
+
+func (r *run) pipelineParameter(name string) (string, error) {
+	v, ok := r.parameters[name]
+	if !ok {
+		return "", fmt.Errorf("No value for pipeline parameter %q", name)
+	}
+	return v, nil
+}
+
+func (r *run) inputArtifactFile(ia *pipeline_spec.TaskInputsSpec_InputArtifactSpec) (string, error) {
+	toa := ia.GetTaskOutputArtifact()
+	if toa == nil {
+		return "", fmt.Errorf("Only task output artifacts are supported as inputs")
+	}
+	producer, ok := r.outputs[toa.GetProducerTask()]
+	if !ok {
+		return "", fmt.Errorf("Task %q has not run", toa.GetProducerTask())
+	}
+	p, ok := producer.artifacts[toa.GetOutputArtifactKey()]
+	if !ok {
+		return "", fmt.Errorf("Task %q has no output artifact %q", toa.GetProducerTask(), toa.GetOutputArtifactKey())
+	}
+	return p, nil
+}
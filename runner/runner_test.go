@@ -0,0 +1,366 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/neuromage/kfp-launcher/component"
+	"github.com/neuromage/kfp-launcher/metadata/embedded"
+	pb "github.com/neuromage/kfp-launcher/third_party/ml_metadata"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// testPipelineSpec trains a model on a dataset produced by an upstream task,
+// once per learning rate.
+const testPipelineSpec = `{
+  "pipelineInfo": {"name": "my-pipeline"},
+  "sdkVersion": "kfp-1.4.0",
+  "runtimeParameters": {
+    "rows": {"type": "INT", "defaultValue": {"intValue": "100"}}
+  },
+  "root": {
+    "inputDefinitions": {"parameters": {"rows": {"type": "INT"}}},
+    "dag": {
+      "tasks": {
+        "make-dataset": {
+          "taskInfo": {"name": "Make dataset"},
+          "componentRef": {"name": "comp-make-dataset"},
+          "inputs": {"parameters": {"rows": {"componentInputParameter": "rows"}}}
+        },
+        "train": {
+          "taskInfo": {"name": "train"},
+          "componentRef": {"name": "comp-train"},
+          "inputs": {
+            "parameters": {
+              "name": {"taskOutputParameter": {"producerTask": "make-dataset", "outputParameterKey": "name"}},
+              "epochs": {"runtimeValue": {"constantValue": {"intValue": "3"}}}
+            },
+            "artifacts": {
+              "dataset": {"taskOutputArtifact": {"producerTask": "make-dataset", "outputArtifactKey": "dataset"}}
+            }
+          },
+          "parameterIterator": {"items": {"raw": "[0.1, 0.01]"}, "itemInput": "learning_rate"},
+          "cachingOptions": {"enableCache": true}
+        },
+        "report": {
+          "taskInfo": {"name": "report"},
+          "componentRef": {"name": "comp-report"},
+          "dependentTasks": ["train"]
+        }
+      }
+    }
+  },
+  "components": {
+    "comp-make-dataset": {
+      "inputDefinitions": {"parameters": {"rows": {"type": "INT"}}},
+      "outputDefinitions": {
+        "parameters": {"name": {"type": "STRING"}},
+        "artifacts": {"dataset": {"artifactType": {"schemaTitle": "kfp.Dataset"}}}
+      },
+      "executorLabel": "exec-make-dataset"
+    },
+    "comp-train": {
+      "inputDefinitions": {
+        "parameters": {"name": {"type": "STRING"}, "epochs": {"type": "INT"}, "learning_rate": {"type": "DOUBLE"}},
+        "artifacts": {"dataset": {"artifactType": {"schemaTitle": "kfp.Dataset"}}}
+      },
+      "outputDefinitions": {
+        "artifacts": {"model": {"artifactType": {"instanceSchema": "title: kfp.Model\n"}}}
+      },
+      "executorLabel": "exec-train"
+    },
+    "comp-report": {"executorLabel": "exec-report"}
+  },
+  "deploymentSpec": {
+    "executors": {
+      "exec-make-dataset": {"container": {"image": "python:3.7", "command": ["sh", "-c"], "args": ["echo data"]}},
+      "exec-train": {"container": {"image": "python:3.7", "command": ["train"], "args": ["{{$.inputs.parameters['learning_rate']}}"]}},
+      "exec-report": {"container": {"image": "python:3.7", "command": ["report"]}}
+    }
+  },
+  "newerCompilerField": true
+}`
+
+func TestParsePipeline(t *testing.T) {
+	job := `{
+  "displayName": "my-job",
+  "pipelineSpec": ` + testPipelineSpec + `,
+  "runtimeConfig": {
+    "parameters": {"rows": {"intValue": "5"}},
+    "gcsOutputDirectory": "gs://my-bucket/root"
+  }
+}`
+	for name, b := range map[string]string{"PipelineSpec": testPipelineSpec, "PipelineJob": job} {
+		t.Run(name, func(t *testing.T) {
+			p, err := parsePipeline([]byte(b))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := p.Name(); got != "my-pipeline" {
+				t.Errorf("Name() = %q, want %q", got, "my-pipeline")
+			}
+			if got := len(p.Spec.GetRoot().GetDag().GetTasks()); got != 3 {
+				t.Errorf("Got %d tasks, want 3", got)
+			}
+			if got := p.Deployment.GetExecutors()["exec-train"].GetContainer().GetCommand(); !cmp.Equal(got, []string{"train"}) {
+				t.Errorf("exec-train command = %v, want [train]", got)
+			}
+			if name == "PipelineJob" {
+				if p.PipelineRoot != "gs://my-bucket/root" || p.Parameters["rows"].GetIntValue() != 5 {
+					t.Errorf("Runtime config not loaded: root %q, parameters %v", p.PipelineRoot, p.Parameters)
+				}
+			}
+		})
+	}
+
+	if _, err := parsePipeline([]byte(`{"pipelineInfo": {"name": "empty"}}`)); err == nil {
+		t.Errorf("parsePipeline() of a spec without a root DAG succeeded")
+	}
+}
+
+func TestSortTasks(t *testing.T) {
+	p, err := parsePipeline([]byte(testPipelineSpec))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tasks, err := dagTasks(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	order, err := sortTasks(tasks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, t := range order {
+		got = append(got, t.name)
+	}
+	if diff := cmp.Diff([]string{"make-dataset", "train", "report"}, got); diff != "" {
+		t.Errorf("sortTasks() mismatch (-want +got):\n%s", diff)
+	}
+
+	cyclic := map[string]*task{
+		"a": {name: "a", dependencies: []string{"b"}},
+		"b": {name: "b", dependencies: []string{"a"}},
+		"c": {name: "c"},
+	}
+	if _, err := sortTasks(cyclic); err == nil || !strings.Contains(err.Error(), "[a b]") {
+		t.Errorf("sortTasks() of cyclic tasks error = %v, want tasks [a b]", err)
+	}
+	unknown := map[string]*task{"a": {name: "a", dependencies: []string{"missing"}}}
+	if _, err := sortTasks(unknown); err == nil {
+		t.Errorf("sortTasks() with an unknown dependency succeeded")
+	}
+}
+
+func TestRuntimeInfo(t *testing.T) {
+	p, err := parsePipeline([]byte(testPipelineSpec))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tasks, err := dagTasks(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parameters, err := pipelineParameters(p, map[string]string{"rows": "7"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	work := t.TempDir()
+	r := &run{
+		pipeline:   p,
+		options:    &Options{WorkDir: work},
+		parameters: parameters,
+		outputs:    make(map[string]*taskOutputs),
+	}
+
+	rt, outputs, err := r.runtimeInfo(tasks["make-dataset"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := rt.JSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(got, `"rows":{"ParameterType":"INT","ParameterValue":"7"}`) {
+		t.Errorf("Runtime info %s does not pass the overridden value 7 for rows", got)
+	}
+	r.outputs["make-dataset"] = outputs
+	if err := os.MkdirAll(filepath.Dir(outputs.parameters["name"]), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(outputs.parameters["name"], []byte("census"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	rt, _, err = r.runtimeInfo(tasks["train"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err = rt.JSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{
+  "InputParameters": {
+    "epochs": {"ParameterType": "INT", "ParameterValue": "3"},
+    "name": {"ParameterType": "STRING", "ParameterValue": "census"}
+  },
+  "InputArtifacts": {
+    "dataset": {"FileInputPath": "` + filepath.Join(work, "tasks/make-dataset/outputs/artifacts/dataset") + `"}
+  },
+  "OutputParameters": {},
+  "OutputArtifacts": {
+    "model": {"ArtifactSchema": "title: kfp.Model\n", "FileOutputPath": "` + filepath.Join(work, "tasks/train/outputs/artifacts/model") + `"}
+  },
+  "ParameterIterator": {"Items": {"Raw": "[0.1, 0.01]"}, "ItemInput": "learning_rate"}
+}`
+	var gotJSON, wantJSON interface{}
+	json.Unmarshal([]byte(got), &gotJSON)
+	if err := json.Unmarshal([]byte(want), &wantJSON); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(wantJSON, gotJSON); diff != "" {
+		t.Errorf("runtimeInfo() mismatch (-want +got):\n%s", diff)
+	}
+
+	if _, err := pipelineParameters(p, map[string]string{"missing": "1"}); err == nil {
+		t.Errorf("pipelineParameters() with an unknown parameter succeeded")
+	}
+}
+
+// testRunPipelineSpec makes a dataset and trains a model on it, with shell
+// commands.
+const testRunPipelineSpec = `{
+  "pipelineInfo": {"name": "my-pipeline"},
+  "root": {
+    "dag": {
+      "tasks": {
+        "make-dataset": {
+          "componentRef": {"name": "comp-make-dataset"}
+        },
+        "train": {
+          "componentRef": {"name": "comp-train"},
+          "inputs": {
+            "parameters": {
+              "name": {"taskOutputParameter": {"producerTask": "make-dataset", "outputParameterKey": "name"}}
+            },
+            "artifacts": {
+              "dataset": {"taskOutputArtifact": {"producerTask": "make-dataset", "outputArtifactKey": "dataset"}}
+            }
+          }
+        }
+      }
+    }
+  },
+  "components": {
+    "comp-make-dataset": {
+      "outputDefinitions": {
+        "parameters": {"name": {"type": "STRING"}},
+        "artifacts": {"dataset": {"artifactType": {"schemaTitle": "kfp.Dataset"}}}
+      },
+      "executorLabel": "exec-make-dataset"
+    },
+    "comp-train": {
+      "inputDefinitions": {
+        "parameters": {"name": {"type": "STRING"}},
+        "artifacts": {"dataset": {"artifactType": {"schemaTitle": "kfp.Dataset"}}}
+      },
+      "outputDefinitions": {
+        "artifacts": {"model": {"artifactType": {"schemaTitle": "kfp.Model"}}}
+      },
+      "executorLabel": "exec-train"
+    }
+  },
+  "deploymentSpec": {
+    "executors": {
+      "exec-make-dataset": {"container": {
+        "image": "python:3.7",
+        "command": ["sh", "-c", "mkdir -p \"$(dirname \"$0\")\" && printf census > \"$0\" && printf 'a,b\\n' > \"$1\""],
+        "args": ["{{$.outputs.parameters['name'].output_file}}", "{{$.outputs.artifacts['dataset'].path}}"]
+      }},
+      "exec-train": {"container": {
+        "image": "python:3.7",
+        "command": ["sh", "-c", "cat \"$0\" > \"$2\" && printf %s \"$1\" >> \"$2\""],
+        "args": ["{{$.inputs.artifacts['dataset'].path}}", "{{$.inputs.parameters['name']}}", "{{$.outputs.artifacts['model'].path}}"]
+      }}
+    }
+  }
+}`
+
+func TestRun(t *testing.T) {
+	p, err := parsePipeline([]byte(testRunPipelineSpec))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mlmd, err := embedded.NewServer("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	endpoint, err := embedded.Serve(mlmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer endpoint.Stop()
+
+	work := t.TempDir()
+	err = Run(context.Background(), p, &Options{
+		Launcher: component.LauncherOptions{
+			MLMDServerAddress: endpoint.Address,
+			MLMDServerPort:    endpoint.Port,
+			MLMDMaxAttempts:   1,
+		},
+		RunID:   "my-run",
+		WorkDir: work,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The model is stored under the default pipeline root.
+	b, err := ioutil.ReadFile(filepath.Join(work, "tasks/train/outputs/artifacts/model"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	model := &pb.Artifact{}
+	if err := protojson.Unmarshal(b, model); err != nil {
+		t.Fatal(err)
+	}
+	wantURI := "file://" + filepath.Join(work, "pipeline_root/my-pipeline/my-run/train/model")
+	if model.GetUri() != wantURI {
+		t.Errorf("Model URI = %q, want %q", model.GetUri(), wantURI)
+	}
+	b, err = ioutil.ReadFile(strings.TrimPrefix(wantURI, "file://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(b), "a,b\ncensus"; got != want {
+		t.Errorf("Model = %q, want %q", got, want)
+	}
+
+	// Each task keeps its local copies of artifacts in its own directory.
+	if _, err := os.Stat(filepath.Join(work, "tasks/train/local/kfp_launcher_inputs/dataset/data")); err != nil {
+		t.Errorf("Input artifact was not downloaded to the task directory: %v", err)
+	}
+
+	res, err := mlmd.GetExecutions(context.Background(), &pb.GetExecutionsRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range res.GetExecutions() {
+		if e.GetLastKnownState() != pb.Execution_COMPLETE {
+			t.Errorf("Execution %q is %v, want COMPLETE", e.GetName(), e.GetLastKnownState())
+		}
+		names = append(names, e.GetName())
+	}
+	if diff := cmp.Diff([]string{"my-run/make-dataset/attempt-0", "my-run/train/attempt-0"}, names); diff != "" {
+		t.Errorf("Executions mismatch (-want +got):\n%s", diff)
+	}
+}
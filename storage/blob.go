@@ -0,0 +1,184 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"gocloud.dev/blob"
+	"gocloud.dev/blob/fileblob"
+	_ "gocloud.dev/blob/gcsblob"
+	"gocloud.dev/blob/s3blob"
+	"gocloud.dev/gcerrors"
+)
+
+func init() {
+	Register("gs", openGCSStore)
+	Register("s3", openS3Store)
+	Register("file", openFileStore)
+}
+
+// blobStore is an ArtifactStore backed by a Go CDK bucket.
+type blobStore struct {
+	bucket *blob.Bucket
+	// partSize is the buffer size of writers, which sets the part size of
+	// multipart uploads. Zero uses the driver's default.
+	partSize int
+}
+
+// NewBlobStore returns an ArtifactStore that reads and writes through bucket.
+// The store takes ownership of bucket and closes it on Close.
+func NewBlobStore(bucket *blob.Bucket) ArtifactStore {
+	return &blobStore{bucket: bucket}
+}
+
+func openGCSStore(ctx context.Context, config *Config) (ArtifactStore, error) {
+	u := "gs://" + config.BucketName
+	if len(config.Prefix) > 0 {
+		u = fmt.Sprintf("%s?prefix=%s", u, config.Prefix)
+	}
+	bucket, err := blob.OpenBucket(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	return &blobStore{bucket: bucket, partSize: config.PartSize}, nil
+}
+
+func openS3Store(ctx context.Context, config *Config) (ArtifactStore, error) {
+	sess, err := newS3Session(&config.S3)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create S3 session: %v", err)
+	}
+	bucket, err := s3blob.OpenBucket(ctx, sess, config.BucketName, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(config.Prefix) > 0 {
+		bucket = blob.PrefixedBucket(bucket, config.Prefix)
+	}
+	return &blobStore{bucket: bucket, partSize: config.PartSize}, nil
+}
+
+func newS3Session(o *S3Options) (*session.Session, error) {
+	region := o.Region
+	if len(region) == 0 {
+		// MinIO and most S3-compatible stores accept the default AWS region.
+		region = "us-east-1"
+	}
+	cfg := &aws.Config{
+		Region:           aws.String(region),
+		S3ForcePathStyle: aws.Bool(o.ForcePathStyle),
+		DisableSSL:       aws.Bool(o.DisableSSL),
+	}
+	if len(o.Endpoint) > 0 {
+		cfg.Endpoint = aws.String(o.Endpoint)
+	}
+	if len(o.AccessKeyID) > 0 {
+		cfg.Credentials = credentials.NewStaticCredentials(o.AccessKeyID, o.SecretAccessKey, "")
+	}
+	return session.NewSession(cfg)
+}
+
+func openFileStore(ctx context.Context, config *Config) (ArtifactStore, error) {
+	if err := os.MkdirAll(config.BucketName, 0755); err != nil {
+		return nil, err
+	}
+	bucket, err := fileblob.OpenBucket(config.BucketName, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(config.Prefix) > 0 {
+		bucket = blob.PrefixedBucket(bucket, config.Prefix)
+	}
+	return NewBlobStore(bucket), nil
+}
+
+func (s *blobStore) wrapErr(key string, err error) error {
+	if gcerrors.Code(err) == gcerrors.NotFound {
+		return fmt.Errorf("%q: %w", key, ErrNotExist)
+	}
+	return err
+}
+
+func (s *blobStore) Download(ctx context.Context, key string, w io.Writer) error {
+	r, err := s.bucket.NewReader(ctx, key, nil)
+	if err != nil {
+		return s.wrapErr(key, err)
+	}
+	defer r.Close()
+
+	_, err = io.Copy(w, r)
+	return err
+}
+
+// DownloadRange implements RangeDownloader.
+func (s *blobStore) DownloadRange(ctx context.Context, key string, offset, length int64, w io.Writer) error {
+	r, err := s.bucket.NewRangeReader(ctx, key, offset, length, nil)
+	if err != nil {
+		return s.wrapErr(key, err)
+	}
+	defer r.Close()
+
+	_, err = io.Copy(w, r)
+	return err
+}
+
+func (s *blobStore) Upload(ctx context.Context, key string, r io.Reader) error {
+	// Closing a writer commits the object, unless its context is canceled
+	// first, which discards what was written.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	w, err := s.bucket.NewWriter(ctx, key, &blob.WriterOptions{BufferSize: s.partSize})
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		cancel()
+		w.Close()
+		return err
+	}
+	// Always check the return value of Close when writing.
+	return w.Close()
+}
+
+func (s *blobStore) Exists(ctx context.Context, key string) (bool, error) {
+	return s.bucket.Exists(ctx, key)
+}
+
+func (s *blobStore) Stat(ctx context.Context, key string) (*Attributes, error) {
+	attrs, err := s.bucket.Attributes(ctx, key)
+	if err != nil {
+		return nil, s.wrapErr(key, err)
+	}
+	return &Attributes{Size: attrs.Size, ModTime: attrs.ModTime}, nil
+}
+
+func (s *blobStore) List(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	iter := s.bucket.List(&blob.ListOptions{Prefix: prefix})
+	for {
+		obj, err := iter.Next(ctx)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, obj.Key)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (s *blobStore) Delete(ctx context.Context, key string) error {
+	return s.wrapErr(key, s.bucket.Delete(ctx, key))
+}
+
+func (s *blobStore) Close() error {
+	return s.bucket.Close()
+}
@@ -0,0 +1,203 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+func init() {
+	Register("mem", openMemoryStore)
+}
+
+var (
+	memoryBucketsMu sync.Mutex
+	memoryBuckets   = make(map[string]*MemoryStore)
+)
+
+// openMemoryStore returns the process-wide MemoryStore for the bucket, so
+// that every mem://<bucket> pipeline root opened in a process shares data.
+func openMemoryStore(ctx context.Context, config *Config) (ArtifactStore, error) {
+	memoryBucketsMu.Lock()
+	defer memoryBucketsMu.Unlock()
+
+	s, ok := memoryBuckets[config.BucketName]
+	if !ok {
+		s = NewMemoryStore()
+		memoryBuckets[config.BucketName] = s
+	}
+	return &prefixedStore{store: s, prefix: config.Prefix}, nil
+}
+
+type memoryObject struct {
+	data    []byte
+	modTime time.Time
+}
+
+// MemoryStore is an in-memory ArtifactStore, intended for tests.
+type MemoryStore struct {
+	mu      sync.RWMutex
+	objects map[string]*memoryObject
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{objects: make(map[string]*memoryObject)}
+}
+
+func (s *MemoryStore) get(key string) (*memoryObject, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	o, ok := s.objects[key]
+	if !ok {
+		return nil, fmt.Errorf("%q: %w", key, ErrNotExist)
+	}
+	return o, nil
+}
+
+// Download implements ArtifactStore.
+func (s *MemoryStore) Download(ctx context.Context, key string, w io.Writer) error {
+	o, err := s.get(key)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, bytes.NewReader(o.data))
+	return err
+}
+
+// DownloadRange implements RangeDownloader.
+func (s *MemoryStore) DownloadRange(ctx context.Context, key string, offset, length int64, w io.Writer) error {
+	o, err := s.get(key)
+	if err != nil {
+		return err
+	}
+	if offset < 0 || offset > int64(len(o.data)) {
+		return fmt.Errorf("%q: offset %d out of range", key, offset)
+	}
+	end := int64(len(o.data))
+	if length >= 0 && offset+length < end {
+		end = offset + length
+	}
+	_, err = w.Write(o.data[offset:end])
+	return err
+}
+
+// Upload implements ArtifactStore.
+func (s *MemoryStore) Upload(ctx context.Context, key string, r io.Reader) error {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[key] = &memoryObject{data: b, modTime: time.Now()}
+	return nil
+}
+
+// Exists implements ArtifactStore.
+func (s *MemoryStore) Exists(ctx context.Context, key string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, ok := s.objects[key]
+	return ok, nil
+}
+
+// Stat implements ArtifactStore.
+func (s *MemoryStore) Stat(ctx context.Context, key string) (*Attributes, error) {
+	o, err := s.get(key)
+	if err != nil {
+		return nil, err
+	}
+	return &Attributes{Size: int64(len(o.data)), ModTime: o.modTime}, nil
+}
+
+// List implements ArtifactStore.
+func (s *MemoryStore) List(ctx context.Context, prefix string) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var keys []string
+	for k := range s.objects {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Delete implements ArtifactStore.
+func (s *MemoryStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.objects[key]; !ok {
+		return fmt.Errorf("%q: %w", key, ErrNotExist)
+	}
+	delete(s.objects, key)
+	return nil
+}
+
+// Close implements ArtifactStore. The contents of a MemoryStore remain
+// available after Close.
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+// prefixedStore prepends a fixed prefix to every key of the wrapped store.
+type prefixedStore struct {
+	store  ArtifactStore
+	prefix string
+}
+
+func (s *prefixedStore) Download(ctx context.Context, key string, w io.Writer) error {
+	return s.store.Download(ctx, s.prefix+key, w)
+}
+
+func (s *prefixedStore) DownloadRange(ctx context.Context, key string, offset, length int64, w io.Writer) error {
+	rd, ok := s.store.(RangeDownloader)
+	if !ok {
+		return fmt.Errorf("%q: ranged downloads are not supported", key)
+	}
+	return rd.DownloadRange(ctx, s.prefix+key, offset, length, w)
+}
+
+func (s *prefixedStore) Upload(ctx context.Context, key string, r io.Reader) error {
+	return s.store.Upload(ctx, s.prefix+key, r)
+}
+
+func (s *prefixedStore) Exists(ctx context.Context, key string) (bool, error) {
+	return s.store.Exists(ctx, s.prefix+key)
+}
+
+func (s *prefixedStore) Stat(ctx context.Context, key string) (*Attributes, error) {
+	return s.store.Stat(ctx, s.prefix+key)
+}
+
+func (s *prefixedStore) List(ctx context.Context, prefix string) ([]string, error) {
+	keys, err := s.store.List(ctx, s.prefix+prefix)
+	if err != nil {
+		return nil, err
+	}
+	for i, k := range keys {
+		keys[i] = strings.TrimPrefix(k, s.prefix)
+	}
+	return keys, nil
+}
+
+func (s *prefixedStore) Delete(ctx context.Context, key string) error {
+	return s.store.Delete(ctx, s.prefix+key)
+}
+
+func (s *prefixedStore) Close() error {
+	return s.store.Close()
+}
@@ -0,0 +1,120 @@
+// Package storage provides access to the object stores that hold pipeline
+// artifacts. Stores are looked up by URI scheme, so additional backends can
+// be plugged in with Register without changing the launcher.
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// ErrNotExist is returned (possibly wrapped) when an object does not exist.
+var ErrNotExist = errors.New("object does not exist")
+
+// Attributes describes a stored object.
+type Attributes struct {
+	Size    int64
+	ModTime time.Time
+}
+
+// ArtifactStore reads and writes artifact objects within a single bucket.
+// Keys are relative to the bucket and its prefix, and use "/" as separator.
+type ArtifactStore interface {
+	// Download copies the contents of the object at key into w.
+	Download(ctx context.Context, key string, w io.Writer) error
+	// Upload replaces the object at key with the contents of r.
+	Upload(ctx context.Context, key string, r io.Reader) error
+	// Exists reports whether an object exists at key.
+	Exists(ctx context.Context, key string) (bool, error)
+	// Stat returns the attributes of the object at key.
+	Stat(ctx context.Context, key string) (*Attributes, error)
+	// List returns the keys of all objects whose key starts with prefix, in
+	// lexical order.
+	List(ctx context.Context, prefix string) ([]string, error)
+	// Delete removes the object at key.
+	Delete(ctx context.Context, key string) error
+	// Close releases any resources held by the store.
+	Close() error
+}
+
+// RangeDownloader is implemented by stores that can download part of an
+// object, which allows large objects to be downloaded in parallel.
+type RangeDownloader interface {
+	// DownloadRange copies length bytes of the object at key, starting at
+	// offset, into w.
+	DownloadRange(ctx context.Context, key string, offset, length int64, w io.Writer) error
+}
+
+// S3Options configures access to S3 and S3-compatible stores such as MinIO.
+// When AccessKeyID is empty, the default AWS credential chain is used.
+type S3Options struct {
+	Endpoint        string
+	Region          string
+	ForcePathStyle  bool
+	DisableSSL      bool
+	AccessKeyID     string
+	SecretAccessKey string
+}
+
+// Config identifies the bucket to open an ArtifactStore against.
+type Config struct {
+	// Scheme is the URI scheme without the "://" suffix, e.g. "gs".
+	Scheme string
+	// BucketName is the bucket name, or the root directory for "file".
+	BucketName string
+	// Prefix is prepended to every key. It is either empty or ends in "/".
+	Prefix string
+	// PartSize is the size of the parts large objects are uploaded in, for
+	// stores that support multipart uploads. Zero uses the store's default.
+	PartSize int
+
+	S3 S3Options
+}
+
+// Opener opens an ArtifactStore for the bucket described by config.
+type Opener func(ctx context.Context, config *Config) (ArtifactStore, error)
+
+var (
+	openersMu sync.RWMutex
+	openers   = make(map[string]Opener)
+)
+
+// Register makes opener available for pipeline roots with the given URI
+// scheme, e.g. "hdfs". It panics if the scheme is already registered.
+func Register(scheme string, opener Opener) {
+	openersMu.Lock()
+	defer openersMu.Unlock()
+
+	if opener == nil {
+		panic("storage: Register opener is nil")
+	}
+	if _, ok := openers[scheme]; ok {
+		panic(fmt.Sprintf("storage: Register called twice for scheme %q", scheme))
+	}
+	openers[scheme] = opener
+}
+
+// IsRegistered reports whether an Opener is registered for scheme.
+func IsRegistered(scheme string) bool {
+	openersMu.RLock()
+	defer openersMu.RUnlock()
+
+	_, ok := openers[scheme]
+	return ok
+}
+
+// Open opens an ArtifactStore using the Opener registered for config.Scheme.
+func Open(ctx context.Context, config *Config) (ArtifactStore, error) {
+	openersMu.RLock()
+	opener, ok := openers[config.Scheme]
+	openersMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("No artifact store registered for scheme %q", config.Scheme)
+	}
+	return opener(ctx, config)
+}
@@ -0,0 +1,192 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore()
+
+	if err := s.Upload(ctx, "dir/foo.txt", strings.NewReader("Hello, World!")); err != nil {
+		t.Fatal(err)
+	}
+
+	exists, err := s.Exists(ctx, "dir/foo.txt")
+	if err != nil || !exists {
+		t.Errorf("Exists() = %v, %v, want true, nil", exists, err)
+	}
+
+	attrs, err := s.Stat(ctx, "dir/foo.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attrs.Size != int64(len("Hello, World!")) {
+		t.Errorf("Stat().Size = %d, want %d", attrs.Size, len("Hello, World!"))
+	}
+
+	var buf bytes.Buffer
+	if err := s.Download(ctx, "dir/foo.txt", &buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "Hello, World!" {
+		t.Errorf("Download() = %q, want %q", buf.String(), "Hello, World!")
+	}
+
+	if err := s.Delete(ctx, "dir/foo.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Stat(ctx, "dir/foo.txt"); !errors.Is(err, ErrNotExist) {
+		t.Errorf("Stat() after Delete() error = %v, want ErrNotExist", err)
+	}
+	if err := s.Download(ctx, "dir/foo.txt", &buf); !errors.Is(err, ErrNotExist) {
+		t.Errorf("Download() after Delete() error = %v, want ErrNotExist", err)
+	}
+}
+
+func TestDownloadRange(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "kfp-launcher-storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, config := range []*Config{
+		{Scheme: "mem", BucketName: "TestDownloadRange", Prefix: "root/"},
+		{Scheme: "file", BucketName: dir, PartSize: 4},
+	} {
+		t.Run(config.Scheme, func(t *testing.T) {
+			s, err := Open(ctx, config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+			if err := s.Upload(ctx, "foo.txt", strings.NewReader("Hello, World!")); err != nil {
+				t.Fatal(err)
+			}
+
+			rd, ok := s.(RangeDownloader)
+			if !ok {
+				t.Fatalf("%T does not implement RangeDownloader", s)
+			}
+			for _, tt := range []struct {
+				offset, length int64
+				want           string
+			}{
+				{offset: 0, length: 5, want: "Hello"},
+				{offset: 7, length: 100, want: "World!"},
+				{offset: 7, length: -1, want: "World!"},
+			} {
+				var buf bytes.Buffer
+				if err := rd.DownloadRange(ctx, "foo.txt", tt.offset, tt.length, &buf); err != nil {
+					t.Fatal(err)
+				}
+				if buf.String() != tt.want {
+					t.Errorf("DownloadRange(%d, %d) = %q, want %q", tt.offset, tt.length, buf.String(), tt.want)
+				}
+			}
+			if err := rd.DownloadRange(ctx, "missing.txt", 0, 1, ioutil.Discard); !errors.Is(err, ErrNotExist) {
+				t.Errorf("DownloadRange() of missing object error = %v, want ErrNotExist", err)
+			}
+		})
+	}
+}
+
+func TestOpen_MemoryBucketsAreShared(t *testing.T) {
+	ctx := context.Background()
+	config := &Config{Scheme: "mem", BucketName: "TestOpen_MemoryBucketsAreShared", Prefix: "root/"}
+
+	w, err := Open(ctx, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Upload(ctx, "foo.txt", strings.NewReader("data")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Opening the bucket without the prefix exposes the prefixed key.
+	r, err := Open(ctx, &Config{Scheme: "mem", BucketName: config.BucketName})
+	if err != nil {
+		t.Fatal(err)
+	}
+	exists, err := r.Exists(ctx, "root/foo.txt")
+	if err != nil || !exists {
+		t.Errorf("Exists() = %v, %v, want true, nil", exists, err)
+	}
+}
+
+// failingReader returns data, then fails.
+type failingReader struct {
+	data string
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, errors.New("read failed")
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestUpload_FailedReadLeavesNoObject(t *testing.T) {
+	ctx := context.Background()
+	for _, config := range []*Config{
+		{Scheme: "mem", BucketName: "TestUpload_FailedReadLeavesNoObject"},
+		{Scheme: "file", BucketName: t.TempDir(), PartSize: 4},
+	} {
+		t.Run(config.Scheme, func(t *testing.T) {
+			s, err := Open(ctx, config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+			if err := s.Upload(ctx, "foo.txt", &failingReader{data: "Hello, World!"}); err == nil {
+				t.Fatal("Upload() from a failing reader succeeded")
+			}
+			exists, err := s.Exists(ctx, "foo.txt")
+			if err != nil || exists {
+				t.Errorf("Exists() after a failed Upload() = %v, %v, want false, nil", exists, err)
+			}
+		})
+	}
+}
+
+func TestRegister(t *testing.T) {
+	ctx := context.Background()
+	custom := NewMemoryStore()
+	Register("testcustom", func(ctx context.Context, config *Config) (ArtifactStore, error) {
+		return custom, nil
+	})
+
+	if !IsRegistered("testcustom") {
+		t.Fatalf("IsRegistered(%q) = false, want true", "testcustom")
+	}
+	s, err := Open(ctx, &Config{Scheme: "testcustom", BucketName: "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s != custom {
+		t.Errorf("Open() returned %v, want the registered store", s)
+	}
+
+	if _, err := Open(ctx, &Config{Scheme: "unregistered"}); err == nil {
+		t.Errorf("Open() with unregistered scheme succeeded, want error")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Register() twice for the same scheme did not panic")
+		}
+	}()
+	Register("testcustom", func(ctx context.Context, config *Config) (ArtifactStore, error) {
+		return nil, nil
+	})
+}